@@ -1,14 +1,23 @@
 package handler
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
+	"gourl/pkg/auth"
 	"gourl/pkg/config"
 	"gourl/pkg/database"
+	"gourl/pkg/geoip"
 	"gourl/pkg/handlers"
+	"gourl/pkg/ingest"
 	"gourl/pkg/middleware"
+	"gourl/pkg/models"
+	"gourl/pkg/privacy"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/vercel/go-bridge/go/bridge"
@@ -26,6 +35,15 @@ func init() {
 		if err := database.InitDB(); err != nil {
 			log.Printf("Warning: Database initialization failed: %v", err)
 			// Continue anyway - database will be initialized on first request
+		} else {
+			// The function may be frozen once it responds, so links clicked
+			// before the rollups existed are backfilled before serving
+			n, err := store.BackfillRollups(context.Background(), store.NewSQL(database.DB, database.Current()).Clicks)
+			if err != nil {
+				log.Printf("Error backfilling click rollups: %v", err)
+			} else if n > 0 {
+				log.Printf("Backfilled click rollups of %d link(s)", n)
+			}
 		}
 
 		// Set up router once
@@ -40,6 +58,29 @@ func setupRouter() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Unlike the database, a missing signing key isn't something a later
+	// request can fix, so the function fails to start
+	if err := auth.LoadKeys(cfg.JWTKeys()); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	stores := store.NewSQL(database.DB, database.Current())
+	stores.URLs = store.NewCachedURLStore(stores.URLs, time.Duration(cfg.URLCacheTTL)*time.Second)
+
+	geo, err := geoip.NewResolver(cfg.GeoIPDB, cfg.GeoIPUseIPAPI, time.Duration(cfg.GeoIPReloadInterval)*time.Second)
+	if err != nil {
+		log.Printf("Warning: GeoIP database unavailable: %v", err)
+	}
+
+	// Serverless instances can be frozen as soon as a response is sent, so
+	// clicks are written before the redirect instead of in the background
+	clickOpts := cfg.ClickQueueOptions()
+	clickOpts.Sync = true
+	if cfg.PrivacyMode {
+		clickOpts.Anonymizer = privacy.NewAnonymizer(stores.Clicks)
+	}
+	clickQueue := ingest.New(stores.Clicks, geo, clickOpts)
+
 	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
 
 	router = gin.New()
@@ -48,6 +89,14 @@ func setupRouter() {
 	router.Use(gin.Recovery())
 	router.Use(middleware.CORS(cfg.CORSAllowedOrigins))
 	router.Use(middleware.ErrorHandler())
+	router.Use(handlers.WithStores(stores))
+	router.Use(handlers.WithGeoResolver(geo))
+	router.Use(handlers.WithClickQueue(clickQueue))
+	router.Use(handlers.WithBotClassifier(utils.NewBotClassifier(cfg.BotPatterns)))
+	router.Use(func(c *gin.Context) {
+		c.Set("config", cfg)
+		c.Next()
+	})
 
 	router.Static("/static", "./web/static")
 	
@@ -64,32 +113,66 @@ func setupRouter() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Click ingestion counters, only for holders of METRICS_TOKEN
+	router.GET("/internal/clicks", handlers.GetClickQueueStats)
+
+	// Public keys for services verifying our access tokens
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
 	auth := router.Group("/api/auth")
 	{
 		auth.POST("/register", handlers.Register)
 		auth.POST("/login", handlers.Login)
+		auth.POST("/refresh", handlers.Refresh)
+		auth.POST("/logout", handlers.Logout)
+		auth.POST("/logout-all", handlers.AuthMiddleware(), handlers.RequireSession(), handlers.LogoutAll)
 	}
 
+	// API keys only reach the routes of their scopes
+	linksWrite := handlers.RequireScope(models.ScopeLinksWrite)
+	statsRead := handlers.RequireScope(models.ScopeStatsRead)
+	linksRead := handlers.RequireScope(models.ScopeLinksRead)
+
 	api := router.Group("/api")
 	api.Use(middleware.RateLimit(rateLimiter))
 	{
-		api.POST("/shorten", handlers.CreateShortURL)
-		api.POST("/shorten/bulk", handlers.BulkCreateShortURL)
-		api.GET("/stats/:code", handlers.GetStats)
-		api.GET("/stats/:code/enhanced", handlers.GetEnhancedStats)
+		api.POST("/shorten", handlers.OptionalAuthMiddleware(), linksWrite, handlers.CreateShortURL)
+		api.POST("/shorten/bulk", handlers.OptionalAuthMiddleware(), linksWrite, handlers.BulkCreateShortURL)
+		api.GET("/stats/:code", handlers.OptionalAuthMiddleware(), statsRead, handlers.GetStats)
+		api.GET("/stats/:code/enhanced", handlers.OptionalAuthMiddleware(), statsRead, handlers.GetEnhancedStats)
+		api.GET("/stats/:code/export", handlers.OptionalAuthMiddleware(), statsRead, handlers.ExportStats) // Raw clicks are owner-only
 		api.GET("/qr/:code", handlers.GenerateQRCode)
 	}
 
 	// Protected routes (require auth)
+	// Live stats (/api/stats/:code/live) are left out: they need a
+	// long-running process that sees every click, which functions aren't
 	protected := api.Group("")
 	protected.Use(handlers.AuthMiddleware())
 	{
-		protected.GET("/my-urls", handlers.GetMyURLs)
-		protected.GET("/urls/:code", handlers.GetURLDetails)
-		protected.DELETE("/urls/:code", handlers.DeleteURL)
+		protected.GET("/my-urls", linksRead, handlers.GetMyURLs)
+		protected.GET("/analytics/overview", statsRead, handlers.GetAnalyticsOverview)
+		protected.GET("/urls/:code", linksRead, handlers.GetURLDetails)
+		protected.PATCH("/urls/:code", linksWrite, handlers.UpdateURL)
+		protected.GET("/urls/:code/history", linksRead, handlers.GetURLHistory)
+		protected.GET("/urls/:code/rules", linksRead, handlers.GetURLRules)
+		protected.PUT("/urls/:code/rules", linksWrite, handlers.SetURLRules)
+		protected.GET("/urls/:code/variants", linksRead, handlers.GetURLVariants)
+		protected.PUT("/urls/:code/variants", linksWrite, handlers.SetURLVariants)
+		protected.GET("/urls/:code/share-tokens", linksRead, handlers.GetShareTokens)
+		protected.POST("/urls/:code/share-tokens", linksWrite, handlers.CreateShareToken)
+		protected.DELETE("/urls/:code/share-tokens/:id", linksWrite, handlers.RevokeShareToken)
+		protected.DELETE("/urls/:code", linksWrite, handlers.DeleteURL)
+		protected.GET("/account", linksRead, handlers.GetAccount)
+		protected.PATCH("/account", linksWrite, handlers.UpdateAccount)
+		protected.GET("/api-keys", handlers.RequireSession(), handlers.GetAPIKeys)
+		protected.POST("/api-keys", handlers.RequireSession(), handlers.CreateAPIKey)
+		protected.DELETE("/api-keys/:id", handlers.RequireSession(), handlers.RevokeAPIKey)
 	}
 
 	router.GET("/:code", handlers.RedirectURL)
+	router.HEAD("/:code", handlers.RedirectURL)
+	router.POST("/:code/unlock", middleware.RateLimit(rateLimiter), handlers.UnlockURL)
 }
 
 func Handler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gourl/pkg/config"
+	"gourl/pkg/database"
+	"gourl/pkg/store"
+)
+
+const usage = `Usage: expire
+
+Deletes or anonymizes the raw clicks older than CLICK_RETENTION_DAYS,
+as set by CLICK_RETENTION_MODE. Rollups are kept, so stats keep counting
+the expired clicks.
+
+The server expires clicks hourly by itself; run this from cron where it
+doesn't run continuously (e.g. serverless deployments).
+
+The database is selected the same way as the server:
+DATABASE_URL / POSTGRES_URL for PostgreSQL, otherwise DB_PATH for SQLite.
+`
+
+func main() {
+	if len(os.Args) > 1 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	retention := config.LoadConfig().ClickRetention()
+	if retention.Days <= 0 {
+		fmt.Println("CLICK_RETENTION_DAYS is not set; clicks are kept forever")
+		return
+	}
+
+	// Apply pending migrations so the retention queries match the schema
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.CloseDB()
+
+	stores := store.NewSQL(database.DB, database.Current())
+	n, err := retention.Apply(context.Background(), stores.Clicks)
+	if err != nil {
+		log.Fatalf("Failed to expire clicks: %v", err)
+	}
+	fmt.Printf("Expired %d click(s) recorded before %s (%s)\n", n, retention.Cutoff(time.Now()).Format("2006-01-02"), retention.Mode)
+}
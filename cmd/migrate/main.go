@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"gourl/pkg/database"
+)
+
+const usage = `Usage: migrate <command>
+
+Commands:
+  up          Apply all pending migrations
+  down [n]    Roll back the last n migrations (default 1)
+  status      Show applied and pending migrations
+
+The database is selected the same way as the server:
+DATABASE_URL / POSTGRES_URL for PostgreSQL, otherwise DB_PATH for SQLite.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Connect without running the automatic startup migration
+	if err := database.Connect(); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.CloseDB()
+
+	migrator, err := database.NewDefaultMigrator()
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			steps, err = strconv.Atoi(os.Args[2])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps: %s", os.Args[2])
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		fmt.Printf("Rolled back %d migration(s)\n", rolledBack)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.ChecksumMismatch {
+				state += " (MODIFIED SINCE APPLIED)"
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, state)
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"gourl/pkg/database"
+	"gourl/pkg/store"
+)
+
+const usage = `Usage: rollups <command>
+
+Commands:
+  backfill [code...]  Rebuild the hourly/daily click rollups from the raw
+                      clicks of the given links (default: every link)
+
+Rollups are maintained as clicks are recorded, and the server fills empty
+rollup tables from existing clicks when it starts after an upgrade. Run
+backfill to rebuild them by hand; it is safe to run again.
+Days whose clicks have expired keep their rollups.
+
+The database is selected the same way as the server:
+DATABASE_URL / POSTGRES_URL for PostgreSQL, otherwise DB_PATH for SQLite.
+`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "backfill" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Apply pending migrations so the rollup tables exist
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.CloseDB()
+
+	ctx := context.Background()
+	stores := store.NewSQL(database.DB, database.Current())
+
+	urlIDs, err := selectURLs(ctx, stores, os.Args[2:])
+	if err != nil {
+		log.Fatalf("Failed to list links: %v", err)
+	}
+
+	failed := 0
+	for _, urlID := range urlIDs {
+		if err := stores.Clicks.RebuildRollups(ctx, urlID); err != nil {
+			log.Printf("Error rebuilding rollups of link %d: %v", urlID, err)
+			failed++
+		}
+	}
+	fmt.Printf("Rebuilt rollups of %d link(s)\n", len(urlIDs)-failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// selectURLs returns the IDs of the links with the given codes, or of every
+// link that has clicks
+func selectURLs(ctx context.Context, stores *store.Stores, codes []string) ([]int, error) {
+	var urlIDs []int
+	if len(codes) > 0 {
+		for _, code := range codes {
+			url, err := stores.URLs.GetByCode(ctx, code)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", code, err)
+			}
+			urlIDs = append(urlIDs, url.ID)
+		}
+		return urlIDs, nil
+	}
+
+	rows, err := database.DB.QueryContext(ctx, "SELECT DISTINCT url_id FROM clicks ORDER BY url_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var urlID int
+		if err := rows.Scan(&urlID); err != nil {
+			return nil, err
+		}
+		urlIDs = append(urlIDs, urlID)
+	}
+	return urlIDs, rows.Err()
+}
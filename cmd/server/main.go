@@ -1,12 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"gourl/pkg/auth"
 	"gourl/pkg/config"
 	"gourl/pkg/database"
+	"gourl/pkg/geoip"
 	"gourl/pkg/handlers"
+	"gourl/pkg/ingest"
+	"gourl/pkg/live"
 	"gourl/pkg/middleware"
+	"gourl/pkg/models"
+	"gourl/pkg/privacy"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -20,12 +35,50 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Load the keys access tokens are signed with; production refuses to
+	// start with the development secret
+	if err := auth.LoadKeys(cfg.JWTKeys()); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
 	// Initialize database
 	if err := database.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.CloseDB()
 
+	// Links clicked before the rollups existed would show no clicks until
+	// their rollups are rebuilt, which can take a while on large databases
+	go func() {
+		n, err := store.BackfillRollups(context.Background(), store.NewSQL(database.DB, database.Current()).Clicks)
+		if err != nil {
+			log.Printf("Error backfilling click rollups: %v", err)
+		} else if n > 0 {
+			log.Printf("Backfilled click rollups of %d link(s)", n)
+		}
+	}()
+
+	// Set up repositories; redirect lookups are cached in-process
+	stores := store.NewSQL(database.DB, database.Current())
+	stores.URLs = store.NewCachedURLStore(stores.URLs, time.Duration(cfg.URLCacheTTL)*time.Second)
+
+	// Set up GeoIP lookups for click analytics
+	geo, err := geoip.NewResolver(cfg.GeoIPDB, cfg.GeoIPUseIPAPI, time.Duration(cfg.GeoIPReloadInterval)*time.Second)
+	if err != nil {
+		log.Printf("Warning: GeoIP database unavailable: %v", err)
+	}
+
+	// Recorded clicks are streamed to live stats viewers
+	liveHub := live.NewHub(cfg.LiveMaxClients, cfg.LiveMaxClientsPerLink)
+
+	// Write clicks in batches off the redirect path, anonymized in privacy mode
+	clickOpts := cfg.ClickQueueOptions()
+	clickOpts.Live = liveHub
+	if cfg.PrivacyMode {
+		clickOpts.Anonymizer = privacy.NewAnonymizer(stores.Clicks)
+	}
+	clickQueue := ingest.New(stores.Clicks, geo, clickOpts)
+
 	// Initialize rate limiter
 	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst)
 
@@ -37,6 +90,11 @@ func main() {
 	r.Use(gin.Recovery())
 	r.Use(middleware.CORS(cfg.CORSAllowedOrigins))
 	r.Use(middleware.ErrorHandler())
+	r.Use(handlers.WithStores(stores))
+	r.Use(handlers.WithGeoResolver(geo))
+	r.Use(handlers.WithClickQueue(clickQueue))
+	r.Use(handlers.WithLiveHub(liveHub))
+	r.Use(handlers.WithBotClassifier(utils.NewBotClassifier(cfg.BotPatterns)))
 	// Store config in context for handlers
 	r.Use(func(c *gin.Context) {
 		c.Set("config", cfg)
@@ -59,43 +117,102 @@ func main() {
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Click ingestion counters, only for holders of METRICS_TOKEN
+	r.GET("/internal/clicks", handlers.GetClickQueueStats)
+
+	// Public keys for services verifying our access tokens
+	r.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
 	// Auth routes (no rate limiting, but have their own protection)
 	auth := r.Group("/api/auth")
 	{
 		auth.POST("/register", handlers.Register)
 		auth.POST("/login", handlers.Login)
+		auth.POST("/refresh", handlers.Refresh)
+		auth.POST("/logout", handlers.Logout)
+		auth.POST("/logout-all", handlers.AuthMiddleware(), handlers.RequireSession(), handlers.LogoutAll)
 	}
 
+	// API keys only reach the routes of their scopes
+	linksWrite := handlers.RequireScope(models.ScopeLinksWrite)
+	statsRead := handlers.RequireScope(models.ScopeStatsRead)
+	linksRead := handlers.RequireScope(models.ScopeLinksRead)
+
 	// API routes with rate limiting
 	api := r.Group("/api")
 	api.Use(middleware.RateLimit(rateLimiter))
 	{
 		// Public endpoints
-		api.POST("/shorten", handlers.CreateShortURL) // Optional auth
-		api.POST("/shorten/bulk", handlers.BulkCreateShortURL) // Bulk shortening
-		api.GET("/stats/:code", handlers.GetStats)
-		api.GET("/stats/:code/enhanced", handlers.GetEnhancedStats)
+		api.POST("/shorten", handlers.OptionalAuthMiddleware(), linksWrite, handlers.CreateShortURL) // Optional auth
+		api.POST("/shorten/bulk", handlers.OptionalAuthMiddleware(), linksWrite, handlers.BulkCreateShortURL) // Bulk shortening
+		api.GET("/stats/:code", handlers.OptionalAuthMiddleware(), statsRead, handlers.GetStats)
+		api.GET("/stats/:code/enhanced", handlers.OptionalAuthMiddleware(), statsRead, handlers.GetEnhancedStats)
+		api.GET("/stats/:code/export", handlers.OptionalAuthMiddleware(), statsRead, handlers.ExportStats) // Raw clicks are owner-only
 		api.GET("/qr/:code", handlers.GenerateQRCode) // QR code generation
 		
 		// Protected endpoints (require authentication)
 		protected := api.Group("")
 		protected.Use(handlers.AuthMiddleware())
 		{
-			protected.GET("/my-urls", handlers.GetMyURLs)
-			protected.GET("/urls/:code", handlers.GetURLDetails)
-			protected.DELETE("/urls/:code", handlers.DeleteURL)
+			protected.GET("/my-urls", linksRead, handlers.GetMyURLs)
+			protected.GET("/analytics/overview", statsRead, handlers.GetAnalyticsOverview)
+			protected.GET("/stats/:code/live", statsRead, handlers.StreamLiveStats)
+			protected.GET("/urls/:code", linksRead, handlers.GetURLDetails)
+			protected.PATCH("/urls/:code", linksWrite, handlers.UpdateURL)
+			protected.GET("/urls/:code/history", linksRead, handlers.GetURLHistory)
+			protected.GET("/urls/:code/rules", linksRead, handlers.GetURLRules)
+			protected.PUT("/urls/:code/rules", linksWrite, handlers.SetURLRules)
+			protected.GET("/urls/:code/variants", linksRead, handlers.GetURLVariants)
+			protected.PUT("/urls/:code/variants", linksWrite, handlers.SetURLVariants)
+			protected.GET("/urls/:code/share-tokens", linksRead, handlers.GetShareTokens)
+			protected.POST("/urls/:code/share-tokens", linksWrite, handlers.CreateShareToken)
+			protected.DELETE("/urls/:code/share-tokens/:id", linksWrite, handlers.RevokeShareToken)
+			protected.DELETE("/urls/:code", linksWrite, handlers.DeleteURL)
+			protected.GET("/account", linksRead, handlers.GetAccount)
+			protected.PATCH("/account", linksWrite, handlers.UpdateAccount)
+			protected.GET("/api-keys", handlers.RequireSession(), handlers.GetAPIKeys)
+			protected.POST("/api-keys", handlers.RequireSession(), handlers.CreateAPIKey)
+			protected.DELETE("/api-keys/:id", handlers.RequireSession(), handlers.RevokeAPIKey)
 		}
 	}
 
 	// Redirect route (must be last to catch all codes, but not static files)
 	r.GET("/:code", handlers.RedirectURL)
+	r.HEAD("/:code", handlers.RedirectURL)
+	r.POST("/:code/unlock", middleware.RateLimit(rateLimiter), handlers.UnlockURL)
+
+	// SIGINT/SIGTERM cancel ctx, stopping background jobs and the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	// Expire old raw clicks hourly
+	go cfg.ClickRetention().Run(ctx, stores.Clicks, time.Hour)
 
 	// Start server
 	log.Printf("Server starting on port %s (environment: %s)", cfg.Port, cfg.Environment)
 	log.Printf("Rate limit: %d requests/second, burst: %d", cfg.RateLimitRPS, cfg.RateLimitBurst)
-	if err := r.Run(":" + cfg.Port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	srv.RegisterOnShutdown(liveHub.Close) // Live streams would otherwise hold up Shutdown
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// On shutdown finish in-flight requests, then write the queued clicks
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down (timeout %ds)", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	if err := clickQueue.Close(shutdownCtx); err != nil {
+		log.Printf("Error writing queued clicks: %v", err)
 	}
+	stats := clickQueue.Stats()
+	log.Printf("Clicks: %d recorded, %d dropped, %d failed", stats.Recorded, stats.Dropped, stats.Failed)
 }
 
 
@@ -0,0 +1,14 @@
+// Package migrations embeds the versioned SQL schema files for every
+// supported database dialect.
+//
+// Files live in one directory per dialect and are named
+// NNNN_description.up.sql / NNNN_description.down.sql. The version number
+// must be unique and migrations are applied in ascending order.
+package migrations
+
+import "embed"
+
+// FS contains the sqlite/ and postgres/ migration directories
+//
+//go:embed sqlite/*.sql postgres/*.sql
+var FS embed.FS
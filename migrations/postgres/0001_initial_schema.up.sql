@@ -0,0 +1,42 @@
+-- Initial schema. Uses IF NOT EXISTS so databases created before the
+-- migration runner existed are adopted without changes.
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(255) UNIQUE NOT NULL,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_username ON users(username);
+CREATE INDEX IF NOT EXISTS idx_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS urls (
+	id SERIAL PRIMARY KEY,
+	code VARCHAR(255) UNIQUE NOT NULL,
+	original_url TEXT NOT NULL,
+	user_id INTEGER,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_code ON urls(code);
+CREATE INDEX IF NOT EXISTS idx_user_id ON urls(user_id);
+
+CREATE TABLE IF NOT EXISTS clicks (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL,
+	ip_address VARCHAR(255),
+	user_agent TEXT,
+	referrer TEXT,
+	country VARCHAR(100),
+	clicked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+
+-- Older deployments created clicks before the country column existed
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS country VARCHAR(100);
+
+CREATE INDEX IF NOT EXISTS idx_url_id ON clicks(url_id);
+CREATE INDEX IF NOT EXISTS idx_clicked_at ON clicks(clicked_at);
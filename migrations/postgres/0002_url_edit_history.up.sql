@@ -0,0 +1,14 @@
+ALTER TABLE urls ADD COLUMN updated_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS url_history (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL,
+	previous_url TEXT NOT NULL,
+	new_url TEXT NOT NULL,
+	changed_by INTEGER,
+	changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE,
+	FOREIGN KEY (changed_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_history_url_id ON url_history(url_id);
@@ -0,0 +1,3 @@
+-- Optional cap on redirects; use_count is only incremented for capped links
+ALTER TABLE urls ADD COLUMN max_clicks INTEGER;
+ALTER TABLE urls ADD COLUMN use_count INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Links are not active before starts_at; prelaunch_url is served until then
+ALTER TABLE urls ADD COLUMN starts_at TIMESTAMP;
+ALTER TABLE urls ADD COLUMN prelaunch_url TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN fallback_url;
+ALTER TABLE urls DROP COLUMN disabled;
+ALTER TABLE urls DROP COLUMN fallback_url;
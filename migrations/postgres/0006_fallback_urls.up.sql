@@ -0,0 +1,4 @@
+-- Where unavailable (expired, disabled or exhausted) links send visitors
+ALTER TABLE urls ADD COLUMN fallback_url TEXT;
+ALTER TABLE urls ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN fallback_url TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE clicks DROP COLUMN rule_id;
+DROP TABLE IF EXISTS url_rules;
@@ -0,0 +1,18 @@
+-- Ordered redirect rules: the first rule whose conditions all match picks the
+-- destination. Conditions are comma-separated lists; NULL matches everyone.
+CREATE TABLE IF NOT EXISTS url_rules (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	devices TEXT,
+	os TEXT,
+	languages TEXT,
+	countries TEXT,
+	destination TEXT NOT NULL,
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_rules_url_id ON url_rules(url_id, position);
+
+-- Rule that picked the destination of a click; kept when rules are replaced
+ALTER TABLE clicks ADD COLUMN rule_id INTEGER;
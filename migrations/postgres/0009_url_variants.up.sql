@@ -0,0 +1,19 @@
+-- Weighted destinations for A/B tests; when a link has variants, visitors
+-- not routed by a targeting rule are split between them by weight
+CREATE TABLE IF NOT EXISTS url_variants (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	destination TEXT NOT NULL,
+	weight INTEGER NOT NULL,
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_variants_url_id ON url_variants(url_id, position);
+
+-- Keep returning visitors on the variant they first saw (via cookie)
+ALTER TABLE urls ADD COLUMN sticky_variants BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Variant that served a click; kept when variants are replaced
+ALTER TABLE clicks ADD COLUMN variant_id INTEGER;
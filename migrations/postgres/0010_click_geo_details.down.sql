@@ -0,0 +1,3 @@
+ALTER TABLE clicks DROP COLUMN asn;
+ALTER TABLE clicks DROP COLUMN city;
+ALTER TABLE clicks DROP COLUMN region;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS click_rollups_daily;
+DROP TABLE IF EXISTS click_rollups_hourly;
@@ -0,0 +1,7 @@
+-- Parsed from the User-Agent when the click is recorded; NULL for clicks
+-- stored before these columns existed
+ALTER TABLE clicks ADD COLUMN browser TEXT;
+ALTER TABLE clicks ADD COLUMN browser_version TEXT;
+ALTER TABLE clicks ADD COLUMN os TEXT;
+ALTER TABLE clicks ADD COLUMN os_version TEXT;
+ALTER TABLE clicks ADD COLUMN device TEXT;
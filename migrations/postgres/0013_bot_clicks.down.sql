@@ -0,0 +1,24 @@
+-- Merge bot rows into the human ones before dropping the split
+UPDATE click_rollups_hourly h SET clicks = h.clicks + b.clicks
+	FROM click_rollups_hourly b
+	WHERE NOT h.is_bot AND b.is_bot AND b.url_id = h.url_id AND b.dimension = h.dimension
+	AND b.bucket = h.bucket AND b.value = h.value;
+DELETE FROM click_rollups_hourly b USING click_rollups_hourly h
+	WHERE b.is_bot AND NOT h.is_bot AND b.url_id = h.url_id AND b.dimension = h.dimension
+	AND b.bucket = h.bucket AND b.value = h.value;
+ALTER TABLE click_rollups_hourly DROP CONSTRAINT click_rollups_hourly_pkey;
+ALTER TABLE click_rollups_hourly DROP COLUMN is_bot;
+ALTER TABLE click_rollups_hourly ADD PRIMARY KEY (url_id, dimension, bucket, value);
+
+UPDATE click_rollups_daily h SET clicks = h.clicks + b.clicks
+	FROM click_rollups_daily b
+	WHERE NOT h.is_bot AND b.is_bot AND b.url_id = h.url_id AND b.dimension = h.dimension
+	AND b.bucket = h.bucket AND b.value = h.value;
+DELETE FROM click_rollups_daily b USING click_rollups_daily h
+	WHERE b.is_bot AND NOT h.is_bot AND b.url_id = h.url_id AND b.dimension = h.dimension
+	AND b.bucket = h.bucket AND b.value = h.value;
+ALTER TABLE click_rollups_daily DROP CONSTRAINT click_rollups_daily_pkey;
+ALTER TABLE click_rollups_daily DROP COLUMN is_bot;
+ALTER TABLE click_rollups_daily ADD PRIMARY KEY (url_id, dimension, bucket, value);
+
+ALTER TABLE clicks DROP COLUMN is_bot;
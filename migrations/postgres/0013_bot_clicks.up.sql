@@ -0,0 +1,12 @@
+-- Clicks classified as bots (crawlers, link previews, HEAD requests)
+ALTER TABLE clicks ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Rollups are kept separately for human and bot traffic; existing rows
+-- predate bot detection and count as human
+ALTER TABLE click_rollups_hourly ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_rollups_hourly DROP CONSTRAINT click_rollups_hourly_pkey;
+ALTER TABLE click_rollups_hourly ADD PRIMARY KEY (url_id, dimension, bucket, value, is_bot);
+
+ALTER TABLE click_rollups_daily ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE click_rollups_daily DROP CONSTRAINT click_rollups_daily_pkey;
+ALTER TABLE click_rollups_daily ADD PRIMARY KEY (url_id, dimension, bucket, value, is_bot);
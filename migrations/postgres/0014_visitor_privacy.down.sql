@@ -0,0 +1,3 @@
+DROP TABLE visitor_salts;
+ALTER TABLE clicks DROP COLUMN anonymized;
+ALTER TABLE clicks DROP COLUMN visitor_id;
@@ -0,0 +1,13 @@
+-- Hash of the visitor's IP address and user agent with the salt of the day,
+-- counting unique visitors in privacy mode instead of the (truncated) IP
+ALTER TABLE clicks ADD COLUMN visitor_id TEXT;
+
+-- Set once the retention job has stripped the click's personal data
+ALTER TABLE clicks ADD COLUMN anonymized BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- One random salt per UTC day; past salts are deleted so visitor IDs can't
+-- be traced back to an address
+CREATE TABLE visitor_salts (
+	day TEXT PRIMARY KEY,
+	salt TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS stats_share_tokens;
+ALTER TABLE urls DROP COLUMN stats_visibility;
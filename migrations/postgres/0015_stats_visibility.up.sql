@@ -0,0 +1,19 @@
+-- Who can read a link's stats: public (anyone), owner (its owner only) or
+-- token (its owner and holders of a share token). Existing links of
+-- accounts become owner-only; anonymous links have no owner and stay public.
+ALTER TABLE urls ADD COLUMN stats_visibility TEXT NOT NULL DEFAULT 'public';
+UPDATE urls SET stats_visibility = 'owner' WHERE user_id IS NOT NULL;
+
+-- Read-only tokens granting access to the stats of a token-visibility link.
+-- Only a SHA-256 hash of each token is kept; prefix helps owners tell them apart.
+CREATE TABLE IF NOT EXISTS stats_share_tokens (
+	id SERIAL PRIMARY KEY,
+	url_id INTEGER NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_stats_share_tokens_url_id ON stats_share_tokens(url_id);
@@ -0,0 +1,17 @@
+-- Long-lived credentials for scripts and integrations. Only a SHA-256 hash
+-- of each key is kept; prefix helps users tell their keys apart. scopes is
+-- a space-separated list (links:write, stats:read).
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP,
+	expires_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,20 @@
+-- Access tokens carry the token_version they were issued under; bumping it
+-- revokes every access token of the user at once
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+-- Rotating refresh tokens, one chain per login session. Only a SHA-256 hash
+-- of each token is kept. Used tokens stay until the session ends so reusing
+-- one (a sign it was stolen) can revoke the whole session.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	session_id TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
@@ -0,0 +1,39 @@
+-- Initial schema. Uses IF NOT EXISTS so databases created before the
+-- migration runner existed are adopted without changes.
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_username ON users(username);
+CREATE INDEX IF NOT EXISTS idx_email ON users(email);
+
+CREATE TABLE IF NOT EXISTS urls (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code TEXT UNIQUE NOT NULL,
+	original_url TEXT NOT NULL,
+	user_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_code ON urls(code);
+CREATE INDEX IF NOT EXISTS idx_user_id ON urls(user_id);
+
+CREATE TABLE IF NOT EXISTS clicks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url_id INTEGER NOT NULL,
+	ip_address TEXT,
+	user_agent TEXT,
+	referrer TEXT,
+	country TEXT,
+	clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_id ON clicks(url_id);
+CREATE INDEX IF NOT EXISTS idx_clicked_at ON clicks(clicked_at);
@@ -0,0 +1,2 @@
+-- bcrypt hash of the optional link password; NULL means the link is public
+ALTER TABLE urls ADD COLUMN password_hash TEXT;
@@ -0,0 +1,2 @@
+ALTER TABLE urls DROP COLUMN prelaunch_url;
+ALTER TABLE urls DROP COLUMN starts_at;
@@ -0,0 +1,2 @@
+-- NULL uses the server default (DEFAULT_REDIRECT_TYPE)
+ALTER TABLE urls ADD COLUMN redirect_type TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE clicks DROP COLUMN variant_id;
+ALTER TABLE urls DROP COLUMN sticky_variants;
+DROP TABLE IF EXISTS url_variants;
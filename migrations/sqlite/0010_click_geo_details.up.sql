@@ -0,0 +1,4 @@
+-- Location details resolved by the GeoIP provider
+ALTER TABLE clicks ADD COLUMN region TEXT;
+ALTER TABLE clicks ADD COLUMN city TEXT;
+ALTER TABLE clicks ADD COLUMN asn INTEGER;
@@ -0,0 +1,24 @@
+-- Click counts pre-aggregated per hour and per day (UTC) so analytics don't
+-- scan the clicks table. dimension is 'total' (with an empty value),
+-- 'referrer', 'country', 'browser', 'os' or 'device'. Maintained when clicks
+-- are recorded; the server fills them from existing clicks when it starts
+-- with empty rollups, and `go run ./cmd/rollups backfill` rebuilds them.
+CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+	url_id INTEGER NOT NULL,
+	bucket DATETIME NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (url_id, dimension, bucket, value),
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS click_rollups_daily (
+	url_id INTEGER NOT NULL,
+	bucket DATETIME NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (url_id, dimension, bucket, value),
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
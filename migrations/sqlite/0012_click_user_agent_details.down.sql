@@ -0,0 +1,5 @@
+ALTER TABLE clicks DROP COLUMN device;
+ALTER TABLE clicks DROP COLUMN os_version;
+ALTER TABLE clicks DROP COLUMN os;
+ALTER TABLE clicks DROP COLUMN browser_version;
+ALTER TABLE clicks DROP COLUMN browser;
@@ -0,0 +1,31 @@
+CREATE TABLE click_rollups_hourly_old (
+	url_id INTEGER NOT NULL,
+	bucket DATETIME NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (url_id, dimension, bucket, value),
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+INSERT INTO click_rollups_hourly_old (url_id, bucket, dimension, value, clicks)
+	SELECT url_id, bucket, dimension, value, SUM(clicks) FROM click_rollups_hourly
+	GROUP BY url_id, bucket, dimension, value;
+DROP TABLE click_rollups_hourly;
+ALTER TABLE click_rollups_hourly_old RENAME TO click_rollups_hourly;
+
+CREATE TABLE click_rollups_daily_old (
+	url_id INTEGER NOT NULL,
+	bucket DATETIME NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (url_id, dimension, bucket, value),
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+INSERT INTO click_rollups_daily_old (url_id, bucket, dimension, value, clicks)
+	SELECT url_id, bucket, dimension, value, SUM(clicks) FROM click_rollups_daily
+	GROUP BY url_id, bucket, dimension, value;
+DROP TABLE click_rollups_daily;
+ALTER TABLE click_rollups_daily_old RENAME TO click_rollups_daily;
+
+ALTER TABLE clicks DROP COLUMN is_bot;
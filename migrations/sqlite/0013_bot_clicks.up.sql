@@ -0,0 +1,35 @@
+-- Clicks classified as bots (crawlers, link previews, HEAD requests)
+ALTER TABLE clicks ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT 0;
+
+-- Rollups are kept separately for human and bot traffic. SQLite can't
+-- change a primary key, so the tables are rebuilt; existing rows predate
+-- bot detection and count as human.
+CREATE TABLE click_rollups_hourly_new (
+	url_id INTEGER NOT NULL,
+	bucket DATETIME NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	is_bot BOOLEAN NOT NULL DEFAULT 0,
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (url_id, dimension, bucket, value, is_bot),
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+INSERT INTO click_rollups_hourly_new (url_id, bucket, dimension, value, clicks)
+	SELECT url_id, bucket, dimension, value, clicks FROM click_rollups_hourly;
+DROP TABLE click_rollups_hourly;
+ALTER TABLE click_rollups_hourly_new RENAME TO click_rollups_hourly;
+
+CREATE TABLE click_rollups_daily_new (
+	url_id INTEGER NOT NULL,
+	bucket DATETIME NOT NULL,
+	dimension TEXT NOT NULL,
+	value TEXT NOT NULL,
+	is_bot BOOLEAN NOT NULL DEFAULT 0,
+	clicks INTEGER NOT NULL,
+	PRIMARY KEY (url_id, dimension, bucket, value, is_bot),
+	FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
+);
+INSERT INTO click_rollups_daily_new (url_id, bucket, dimension, value, clicks)
+	SELECT url_id, bucket, dimension, value, clicks FROM click_rollups_daily;
+DROP TABLE click_rollups_daily;
+ALTER TABLE click_rollups_daily_new RENAME TO click_rollups_daily;
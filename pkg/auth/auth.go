@@ -1,30 +1,30 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte(getJWTSecret())
-
 // Claims represents JWT claims
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
+	UserID       int    `json:"user_id"`
+	Username     string `json:"username"`
+	TokenVersion int    `json:"tv"` // Must match the user's token version
 	jwt.RegisteredClaims
 }
 
-// getJWTSecret gets JWT secret from environment or uses default
-func getJWTSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-secret-key-change-in-production" // Default for development
-	}
-	return secret
+// UnlockClaims prove that a visitor entered the password of a short URL
+type UnlockClaims struct {
+	PasswordVersion string `json:"pwv"`
+	jwt.RegisteredClaims
 }
 
 // HashPassword hashes a password using bcrypt
@@ -42,13 +42,15 @@ func CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-// GenerateToken generates a JWT token for a user
-func GenerateToken(userID int, username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
+// GenerateToken generates a JWT access token for a user, valid for ttl.
+// version is the user's current token version.
+func GenerateToken(userID int, username string, version int, ttl time.Duration) (string, error) {
+	expirationTime := time.Now().Add(ttl)
 
 	claims := &Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:       userID,
+		Username:     username,
+		TokenVersion: version,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -56,8 +58,16 @@ func GenerateToken(userID int, username string) (string, error) {
 		},
 	}
 
+	// Asymmetric keys name themselves in the header so verifiers can pick
+	// the right one while keys rotate
+	if keys.signer != nil {
+		token := jwt.NewWithClaims(keys.signKey.method, claims)
+		token.Header["kid"] = keys.signKey.kid
+		return token.SignedString(keys.signer)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := token.SignedString(keys.secret)
 	if err != nil {
 		return "", err
 	}
@@ -69,12 +79,7 @@ func GenerateToken(userID int, username string) (string, error) {
 func ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
 	if err != nil {
 		return nil, err
@@ -87,3 +92,93 @@ func ValidateToken(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
+// verificationKey picks the key that checks token: the HS256 secret, or
+// the asymmetric key named by its kid header
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if keys.secret == nil {
+			return nil, errors.New("HMAC tokens are not accepted")
+		}
+		return keys.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodEd25519:
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.public[kid]
+		if !ok {
+			return nil, errors.New("unknown key ID")
+		}
+		// A key only verifies tokens of its own algorithm
+		if key.method.Alg() != token.Method.Alg() {
+			return nil, errors.New("invalid signing method")
+		}
+		return key.key, nil
+	}
+	return nil, errors.New("invalid signing method")
+}
+
+// unlockKey derives a separate signing key for unlock tokens so they can
+// never be accepted as login tokens (and vice versa)
+func unlockKey() []byte {
+	mac := hmac.New(sha256.New, keys.unlock)
+	mac.Write([]byte("gourl-link-unlock"))
+	return mac.Sum(nil)
+}
+
+// passwordVersion fingerprints a password hash so tokens issued for an old
+// password stop working when it changes
+func passwordVersion(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateUnlockToken issues a short-lived token granting access to the
+// password-protected short URL identified by code
+func GenerateUnlockToken(code, passwordHash string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := &UnlockClaims{
+		PasswordVersion: passwordVersion(passwordHash),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   code,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(unlockKey())
+}
+
+// ValidateUnlockToken reports whether tokenString unlocks code with its current password
+func ValidateUnlockToken(tokenString, code, passwordHash string) bool {
+	claims := &UnlockClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return unlockKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return false
+	}
+
+	return claims.Subject == code && claims.PasswordVersion == passwordVersion(passwordHash)
+}
+
+// GenerateOpaqueToken returns a random token starting with kind (e.g.
+// "gst_") and the hash it is stored under. Unlike JWTs, opaque tokens are
+// looked up on every use so they can be revoked.
+func GenerateOpaqueToken(kind string) (token, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = kind + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hash an opaque token is stored under. The
+// tokens are random, so a fast unsalted hash is enough.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
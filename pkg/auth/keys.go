@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// devSecret signs tokens in development when no key is configured
+const devSecret = "your-secret-key-change-in-production"
+
+// ErrNoSigningKey is returned in production when neither a signing key nor
+// a secret of our own is configured
+var ErrNoSigningKey = errors.New("no JWT signing key configured: set JWT_SIGNING_KEY or JWT_SECRET")
+
+// KeyConfig says which keys sign and verify access tokens. Keys are PEM
+// files, or the PEM itself for platforms without a writable disk.
+type KeyConfig struct {
+	Secret     string   // HS256 secret; with a SigningKey it only verifies older tokens
+	SigningKey string   // RSA (RS256) or Ed25519 (EdDSA) private key new tokens are signed with
+	VerifyKeys []string // Earlier public or private keys whose tokens are still accepted
+	Production bool     // Refuse to fall back to the development secret
+}
+
+// publicKey is an asymmetric key tokens may be signed with
+type publicKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.PublicKey
+}
+
+// keySet holds the keys in use
+type keySet struct {
+	secret  []byte                // HS256 secret; nil when only asymmetric keys are used
+	signer  crypto.Signer         // Signs new tokens instead of secret when set
+	signKey *publicKey            // Public half of signer
+	public  map[string]*publicKey // Verification keys by kid, including signKey
+	unlock  []byte                // Derives the signing key of unlock tokens
+}
+
+// keys sign and verify tokens. LoadKeys replaces these development defaults
+// at startup.
+var keys = secretKeys([]byte(getJWTSecret()))
+
+// secretKeys returns a key set signing with an HS256 secret only
+func secretKeys(secret []byte) *keySet {
+	return &keySet{secret: secret, public: make(map[string]*publicKey), unlock: secret}
+}
+
+// getJWTSecret gets JWT secret from environment or uses default
+func getJWTSecret() string {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = devSecret // Default for development
+	}
+	return secret
+}
+
+// LoadKeys sets up token signing from cfg. It fails on unreadable or
+// unsupported keys, and in production when no real key is configured.
+func LoadKeys(cfg KeyConfig) error {
+	if cfg.SigningKey == "" {
+		if cfg.Secret == "" || cfg.Secret == devSecret {
+			if cfg.Production {
+				return ErrNoSigningKey
+			}
+			log.Printf("Warning: JWT_SECRET is not set, signing tokens with the development secret")
+			cfg.Secret = devSecret
+		}
+		if len(cfg.VerifyKeys) > 0 {
+			return errors.New("JWT_VERIFY_KEYS needs a JWT_SIGNING_KEY")
+		}
+		keys = secretKeys([]byte(cfg.Secret))
+		return nil
+	}
+
+	set := &keySet{public: make(map[string]*publicKey)}
+	if cfg.Secret != "" && cfg.Secret != devSecret {
+		set.secret = []byte(cfg.Secret)
+	}
+
+	signer, err := readPrivateKey(cfg.SigningKey)
+	if err != nil {
+		return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+	}
+	set.signer = signer
+	if set.signKey, err = newPublicKey(signer.Public()); err != nil {
+		return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+	}
+	set.public[set.signKey.kid] = set.signKey
+
+	for _, source := range cfg.VerifyKeys {
+		pub, err := readPublicKey(source)
+		if err != nil {
+			return fmt.Errorf("JWT_VERIFY_KEYS %s: %w", keyName(source), err)
+		}
+		key, err := newPublicKey(pub)
+		if err != nil {
+			return fmt.Errorf("JWT_VERIFY_KEYS %s: %w", keyName(source), err)
+		}
+		set.public[key.kid] = key
+	}
+
+	// Unlock tokens never leave this service, so they keep using HMAC; the
+	// secret is preferred so rotating the signing key doesn't log visitors
+	// out of protected links
+	set.unlock = set.secret
+	if set.unlock == nil {
+		if set.unlock, err = x509.MarshalPKCS8PrivateKey(signer); err != nil {
+			return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+		}
+	}
+
+	keys = set
+	return nil
+}
+
+// keyName names a key source in errors without printing inline PEM
+func keyName(source string) string {
+	if isPEM(source) {
+		return "(inline PEM)"
+	}
+	return source
+}
+
+// isPEM reports whether source is the PEM itself rather than a file name
+func isPEM(source string) bool {
+	return strings.HasPrefix(strings.TrimSpace(source), "-----BEGIN")
+}
+
+// readPEM returns the first PEM block of source
+func readPEM(source string) (*pem.Block, error) {
+	data := []byte(source)
+	if !isPEM(source) {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return block, nil
+}
+
+// readPrivateKey reads an RSA or Ed25519 private key
+func readPrivateKey(source string) (crypto.Signer, error) {
+	block, err := readPEM(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("expected a private key, got %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", key)
+}
+
+// readPublicKey reads an RSA or Ed25519 key, using the public half of
+// private keys
+func readPublicKey(source string) (crypto.PublicKey, error) {
+	block, err := readPEM(source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	signer, err := readPrivateKey(source)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// newPublicKey picks the signing method of key and identifies it by its
+// RFC 7638 thumbprint, so the same key always gets the same kid
+func newPublicKey(key crypto.PublicKey) (*publicKey, error) {
+	pub := &publicKey{key: key}
+	var members string
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must have at least 2048 bits, got %d", k.N.BitLen())
+		}
+		pub.method = jwt.SigningMethodRS256
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, b64(big.NewInt(int64(k.E)).Bytes()), b64(k.N.Bytes()))
+	case ed25519.PublicKey:
+		pub.method = jwt.SigningMethodEdDSA
+		members = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%q}`, b64(k))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", key)
+	}
+	sum := sha256.Sum256([]byte(members))
+	pub.kid = b64(sum[:])
+	return pub, nil
+}
+
+// b64 encodes JWK members
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`   // RSA modulus
+	E         string `json:"e,omitempty"`   // RSA exponent
+	Curve     string `json:"crv,omitempty"` // OKP curve
+	X         string `json:"x,omitempty"`   // OKP public key
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKeys returns the keys that verify access tokens, the signing key
+// first. It is empty when tokens are signed with an HS256 secret, which
+// can't be published.
+func PublicKeys() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if keys.signKey == nil {
+		return set
+	}
+	set.Keys = append(set.Keys, keys.signKey.jwk())
+
+	// The rest by kid, so responses don't change between requests
+	var older []JWK
+	for kid, key := range keys.public {
+		if kid != keys.signKey.kid {
+			older = append(older, key.jwk())
+		}
+	}
+	sort.Slice(older, func(i, j int) bool { return older[i].KeyID < older[j].KeyID })
+	set.Keys = append(set.Keys, older...)
+	return set
+}
+
+// jwk returns the key in JSON Web Key format
+func (k *publicKey) jwk() JWK {
+	jwk := JWK{KeyID: k.kid, Use: "sig", Algorithm: k.method.Alg()}
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = b64(key.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = b64(key)
+	}
+	return jwk
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useKeys restores the package keys when the test ends
+func useKeys(t *testing.T) {
+	t.Helper()
+	saved := keys
+	t.Cleanup(func() { keys = saved })
+}
+
+// ed25519PEM returns a new Ed25519 private key as inline PEM
+func ed25519PEM(t *testing.T) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestKeyIDThumbprint(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// The example keys of RFC 7638 section 3.1 and RFC 8037 appendix A.3
+	rsaKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(decode("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")),
+		E: 65537,
+	}
+	okpKey := ed25519.PublicKey(decode("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"))
+
+	tests := []struct {
+		name   string
+		key    interface{}
+		kid    string
+		method jwt.SigningMethod
+	}{
+		{"RSA", rsaKey, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwt.SigningMethodRS256},
+		{"Ed25519", okpKey, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k", jwt.SigningMethodEdDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub, err := newPublicKey(tt.key)
+			if err != nil {
+				t.Fatalf("newPublicKey: %v", err)
+			}
+			if pub.kid != tt.kid {
+				t.Errorf("kid = %s, want %s", pub.kid, tt.kid)
+			}
+			if pub.method != tt.method {
+				t.Errorf("method = %s, want %s", pub.method.Alg(), tt.method.Alg())
+			}
+		})
+	}
+
+	small := &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), 1023), E: 65537}
+	if _, err := newPublicKey(small); err == nil {
+		t.Error("newPublicKey accepted a 1024-bit RSA key")
+	}
+}
+
+func TestHS256RejectedWithAsymmetricKeys(t *testing.T) {
+	useKeys(t)
+
+	// Signed while only the development secret was configured
+	hs256, err := GenerateToken(1, "ann", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	if err := LoadKeys(KeyConfig{SigningKey: ed25519PEM(t)}); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if _, err := ValidateToken(hs256); err == nil {
+		t.Error("HS256 token accepted without a secret")
+	}
+
+	// The development secret never re-enables HS256 next to a signing key
+	if err := LoadKeys(KeyConfig{SigningKey: ed25519PEM(t), Secret: devSecret}); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if _, err := ValidateToken(hs256); err == nil {
+		t.Error("HS256 token signed with the development secret accepted")
+	}
+
+	eddsa, err := GenerateToken(1, "ann", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	claims, err := ValidateToken(eddsa)
+	if err != nil || claims.UserID != 1 {
+		t.Errorf("EdDSA token = %+v, %v", claims, err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	useKeys(t)
+	oldKey, newKey := ed25519PEM(t), ed25519PEM(t)
+
+	if err := LoadKeys(KeyConfig{SigningKey: oldKey}); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	old, err := GenerateToken(1, "ann", 0, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	if err := LoadKeys(KeyConfig{SigningKey: newKey, VerifyKeys: []string{oldKey}}); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if _, err := ValidateToken(old); err != nil {
+		t.Errorf("token of the previous key rejected: %v", err)
+	}
+	if n := len(PublicKeys().Keys); n != 2 {
+		t.Errorf("JWKS has %d keys, want 2", n)
+	}
+
+	if err := LoadKeys(KeyConfig{SigningKey: newKey}); err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	if _, err := ValidateToken(old); err == nil {
+		t.Error("token of a retired key accepted")
+	}
+}
+
+func TestLoadKeysProduction(t *testing.T) {
+	useKeys(t)
+
+	for _, secret := range []string{"", devSecret} {
+		if err := LoadKeys(KeyConfig{Secret: secret, Production: true}); !errors.Is(err, ErrNoSigningKey) {
+			t.Errorf("LoadKeys(secret %q) = %v, want ErrNoSigningKey", secret, err)
+		}
+	}
+	if err := LoadKeys(KeyConfig{Secret: "a-real-secret", Production: true}); err != nil {
+		t.Errorf("LoadKeys with a secret: %v", err)
+	}
+	if err := LoadKeys(KeyConfig{SigningKey: ed25519PEM(t), Production: true}); err != nil {
+		t.Errorf("LoadKeys with a signing key: %v", err)
+	}
+}
@@ -1,9 +1,16 @@
 package config
 
 import (
+	"log"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"gourl/pkg/auth"
+	"gourl/pkg/ingest"
+	"gourl/pkg/models"
+	"gourl/pkg/privacy"
 )
 
 // Config holds all configuration for the application
@@ -15,6 +22,41 @@ type Config struct {
 	CORSAllowedOrigins []string
 	Environment     string // "development" or "production"
 	BaseURL         string // Base URL for short links (e.g., https://yoursite.com)
+	URLCacheTTL     int    // Seconds to cache short URL lookups for redirects (0 disables)
+	NotActiveStatus int    // HTTP status for scheduled links before their start time (403 or 404)
+	FallbackURL     string // Where expired, disabled or exhausted links redirect when neither the link nor its owner sets one
+	RedirectType    string // Redirect type for links without their own (301, 302, 307, 308 or html)
+
+	GeoIPDB             []string // MaxMind DB (.mmdb) files, e.g. GeoLite2-City and GeoLite2-ASN
+	GeoIPReloadInterval int      // Seconds between checks for replaced GeoIP files (0 disables)
+	GeoIPUseIPAPI       bool     // Fall back to ip-api.com (sends visitor IPs to a third party)
+
+	BotPatterns []string // Extra User-Agent substrings counted as bot traffic (case-insensitive)
+
+	PrivacyMode        bool   // Truncate stored IPs, count visitors by daily-rotating hashes and honour DNT/Sec-GPC
+	ClickRetentionDays int    // Days raw clicks are kept (0 keeps them forever); rollups are kept regardless
+	ClickRetentionMode string // What happens to older clicks: delete or anonymize
+
+	ClickQueueSize     int    // Clicks buffered in memory before the drop policy applies
+	ClickWorkers       int    // Goroutines writing clicks to the database
+	ClickBatchSize     int    // Clicks written per transaction
+	ClickFlushInterval int    // Milliseconds a partial batch waits before it is written
+	ClickDropPolicy    string // What happens when the queue is full: block, drop_newest or drop_oldest
+	ClickBlockTimeout  int    // Milliseconds the block policy waits for room before dropping
+	ShutdownTimeout    int    // Seconds to finish requests and write queued clicks on shutdown
+
+	LiveMaxClients        int // Live stats streams open at once (0 is unlimited)
+	LiveMaxClientsPerLink int // Live stats streams open at once for one link (0 is unlimited)
+	LiveHeartbeat         int // Seconds between keep-alive comments on idle live streams
+
+	MetricsToken string // Bearer token for the click ingestion counters at /internal/clicks (empty disables them)
+
+	AccessTokenTTL      int // Seconds an access token (JWT) is valid
+	RefreshTokenTTLDays int // Days a login session lasts without being refreshed
+
+	JWTSecret     string   // HS256 secret; only verifies older tokens once JWTSigningKey is set
+	JWTSigningKey string   // PEM file (or PEM) of the RSA or Ed25519 key access tokens are signed with
+	JWTVerifyKeys []string // PEM files of earlier keys whose tokens are still accepted during rotation
 }
 
 // LoadConfig loads configuration from environment variables with defaults
@@ -27,11 +69,111 @@ func LoadConfig() *Config {
 		Environment:     getEnv("ENV", "development"),
 		CORSAllowedOrigins: getEnvAsSlice("CORS_ALLOWED_ORIGINS", []string{"*"}),
 		BaseURL:         getEnv("BASE_URL", ""), // Empty means auto-detect from request
+		URLCacheTTL:     getEnvAsInt("URL_CACHE_TTL", 30),
+		NotActiveStatus: getEnvAsInt("NOT_ACTIVE_STATUS", 403),
+		FallbackURL:     getEnv("FALLBACK_URL", ""),
+		RedirectType:    getEnv("DEFAULT_REDIRECT_TYPE", models.RedirectTemporary),
+
+		GeoIPDB:             getEnvAsSlice("GEOIP_DB", nil),
+		GeoIPReloadInterval: getEnvAsInt("GEOIP_RELOAD_INTERVAL", 60),
+
+		BotPatterns: getEnvAsSlice("BOT_PATTERNS", nil),
+
+		PrivacyMode:        getEnvAsBool("PRIVACY_MODE", false),
+		ClickRetentionDays: getEnvAsInt("CLICK_RETENTION_DAYS", 0),
+		ClickRetentionMode: getEnv("CLICK_RETENTION_MODE", string(privacy.RetainAnonymize)),
+
+		ClickQueueSize:     getEnvAsInt("CLICK_QUEUE_SIZE", 10000),
+		ClickWorkers:       getEnvAsInt("CLICK_WORKERS", 4),
+		ClickBatchSize:     getEnvAsInt("CLICK_BATCH_SIZE", 100),
+		ClickFlushInterval: getEnvAsInt("CLICK_FLUSH_INTERVAL_MS", 500),
+		ClickDropPolicy:    getEnv("CLICK_DROP_POLICY", string(ingest.DropBlock)),
+		ClickBlockTimeout:  getEnvAsInt("CLICK_BLOCK_TIMEOUT_MS", 50),
+		ShutdownTimeout:    getEnvAsInt("SHUTDOWN_TIMEOUT", 30),
+
+		LiveMaxClients:        getEnvAsInt("LIVE_MAX_CLIENTS", 100),
+		LiveMaxClientsPerLink: getEnvAsInt("LIVE_MAX_CLIENTS_PER_LINK", 5),
+		LiveHeartbeat:         getEnvAsInt("LIVE_HEARTBEAT_INTERVAL", 15),
+
+		MetricsToken: getEnv("METRICS_TOKEN", ""),
+
+		AccessTokenTTL:      getEnvAsInt("ACCESS_TOKEN_TTL", 900),
+		RefreshTokenTTLDays: getEnvAsInt("REFRESH_TOKEN_TTL_DAYS", 30),
+
+		JWTSecret:     getEnv("JWT_SECRET", ""),
+		JWTSigningKey: getEnv("JWT_SIGNING_KEY", ""),
+		JWTVerifyKeys: getEnvAsSlice("JWT_VERIFY_KEYS", nil),
+	}
+
+	// Without a local database ip-api.com stays the default provider
+	cfg.GeoIPUseIPAPI = getEnvAsBool("GEOIP_IPAPI", len(cfg.GeoIPDB) == 0)
+
+	// Only "forbidden" and "not found" make sense for links that exist but
+	// aren't live yet; 404 hides upcoming campaigns entirely
+	if cfg.NotActiveStatus != 403 && cfg.NotActiveStatus != 404 {
+		cfg.NotActiveStatus = 403
+	}
+
+	if redirectType, ok := models.ParseRedirectType(cfg.RedirectType); ok {
+		cfg.RedirectType = redirectType
+	} else {
+		log.Printf("Warning: invalid DEFAULT_REDIRECT_TYPE %q, using %s", cfg.RedirectType, models.RedirectTemporary)
+		cfg.RedirectType = models.RedirectTemporary
+	}
+
+	if _, ok := ingest.ParseDropPolicy(cfg.ClickDropPolicy); !ok {
+		log.Printf("Warning: invalid CLICK_DROP_POLICY %q, using %s", cfg.ClickDropPolicy, ingest.DropBlock)
+		cfg.ClickDropPolicy = string(ingest.DropBlock)
+	}
+
+	if cfg.LiveHeartbeat <= 0 {
+		cfg.LiveHeartbeat = 15
+	}
+
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = 900
+	}
+	if cfg.RefreshTokenTTLDays <= 0 {
+		cfg.RefreshTokenTTLDays = 30
+	}
+
+	if _, ok := privacy.ParseRetentionMode(cfg.ClickRetentionMode); !ok {
+		log.Printf("Warning: invalid CLICK_RETENTION_MODE %q, using %s", cfg.ClickRetentionMode, privacy.RetainAnonymize)
+		cfg.ClickRetentionMode = string(privacy.RetainAnonymize)
 	}
 
 	return cfg
 }
 
+// ClickQueueOptions returns the settings of the click ingestion queue
+func (c *Config) ClickQueueOptions() ingest.Options {
+	policy, _ := ingest.ParseDropPolicy(c.ClickDropPolicy)
+	return ingest.Options{
+		Size:          c.ClickQueueSize,
+		Workers:       c.ClickWorkers,
+		BatchSize:     c.ClickBatchSize,
+		FlushInterval: time.Duration(c.ClickFlushInterval) * time.Millisecond,
+		Policy:        policy,
+		BlockTimeout:  time.Duration(c.ClickBlockTimeout) * time.Millisecond,
+	}
+}
+
+// ClickRetention returns the retention policy of raw clicks
+func (c *Config) ClickRetention() privacy.Retention {
+	mode, _ := privacy.ParseRetentionMode(c.ClickRetentionMode)
+	return privacy.Retention{Days: c.ClickRetentionDays, Mode: mode}
+}
+
+// JWTKeys returns the keys access tokens are signed and verified with
+func (c *Config) JWTKeys() auth.KeyConfig {
+	return auth.KeyConfig{
+		Secret:     c.JWTSecret,
+		SigningKey: c.JWTSigningKey,
+		VerifyKeys: c.JWTVerifyKeys,
+		Production: c.Environment == "production",
+	}
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -50,6 +192,16 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsBool gets an environment variable as boolean or returns a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 // getEnvAsSlice gets an environment variable as a slice (comma-separated) or returns default
 func getEnvAsSlice(key string, defaultValue []string) []string {
 	if value := os.Getenv(key); value != "" {
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+
+	"gourl/pkg/models"
+)
+
+func TestDefaultRedirectType(t *testing.T) {
+	tests := []struct {
+		env      string
+		expected string
+	}{
+		{"", models.RedirectTemporary},
+		{"bogus", models.RedirectTemporary},
+		{"301", models.RedirectPermanent},
+		{"308", models.RedirectPermanentKeepBody},
+		{"html", models.RedirectHTML},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("DEFAULT_REDIRECT_TYPE", tt.env)
+			if got := LoadConfig().RedirectType; got != tt.expected {
+				t.Errorf("RedirectType = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
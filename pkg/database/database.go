@@ -1,11 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
-	"strings"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -13,8 +13,26 @@ import (
 
 var DB *sql.DB
 
-// InitDB initializes the database (PostgreSQL or SQLite)
+// InitDB connects to the database and applies pending schema migrations.
+// Set MIGRATE_ON_START=false to manage migrations with cmd/migrate instead.
 func InitDB() error {
+	if err := Connect(); err != nil {
+		return err
+	}
+
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		if err := migrateUp(); err != nil {
+			return fmt.Errorf("failed to migrate database: %v", err)
+		}
+	}
+
+	log.Println("Database initialized successfully")
+	return nil
+}
+
+// Connect opens the database connection (PostgreSQL or SQLite) without
+// touching the schema
+func Connect() error {
 	var err error
 	var dbURL string
 
@@ -44,7 +62,8 @@ func InitDB() error {
 			dbPath = "gourl.db"
 		}
 
-		DB, err = sql.Open("sqlite3", dbPath+"?_foreign_keys=1")
+		// busy_timeout lets concurrent writers (and the migration lock) wait instead of failing
+		DB, err = sql.Open("sqlite3", dbPath+"?_foreign_keys=1&_busy_timeout=5000")
 		if err != nil {
 			return fmt.Errorf("failed to connect to SQLite: %v", err)
 		}
@@ -57,145 +76,30 @@ func InitDB() error {
 		log.Println("Connected to SQLite database")
 	}
 
-	// Create tables (works for both PostgreSQL and SQLite)
-	if err := createTables(); err != nil {
-		return fmt.Errorf("failed to create tables: %v", err)
-	}
-
-	log.Println("Database initialized successfully")
 	return nil
 }
 
-// createTables creates all necessary tables
-func createTables() error {
-	// Detect database type
-	isPostgres := os.Getenv("DATABASE_URL") != "" || os.Getenv("POSTGRES_URL") != ""
-
-	var createTableSQL string
-
-	if isPostgres {
-		// PostgreSQL syntax
-		createTableSQL = `
-		CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			username VARCHAR(255) UNIQUE NOT NULL,
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_username ON users(username);
-		CREATE INDEX IF NOT EXISTS idx_email ON users(email);
-		
-		CREATE TABLE IF NOT EXISTS urls (
-			id SERIAL PRIMARY KEY,
-			code VARCHAR(255) UNIQUE NOT NULL,
-			original_url TEXT NOT NULL,
-			user_id INTEGER,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			expires_at TIMESTAMP,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_code ON urls(code);
-		CREATE INDEX IF NOT EXISTS idx_user_id ON urls(user_id);
-		
-		CREATE TABLE IF NOT EXISTS clicks (
-			id SERIAL PRIMARY KEY,
-			url_id INTEGER NOT NULL,
-			ip_address VARCHAR(255),
-			user_agent TEXT,
-			referrer TEXT,
-			country VARCHAR(100),
-			clicked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_url_id ON clicks(url_id);
-		CREATE INDEX IF NOT EXISTS idx_clicked_at ON clicks(clicked_at);
-		`
-	} else {
-		// SQLite syntax
-		createTableSQL = `
-		CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			email TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_username ON users(username);
-		CREATE INDEX IF NOT EXISTS idx_email ON users(email);
-		
-		CREATE TABLE IF NOT EXISTS urls (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			code TEXT UNIQUE NOT NULL,
-			original_url TEXT NOT NULL,
-			user_id INTEGER,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			expires_at DATETIME,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_code ON urls(code);
-		CREATE INDEX IF NOT EXISTS idx_user_id ON urls(user_id);
-		
-		CREATE TABLE IF NOT EXISTS clicks (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			url_id INTEGER NOT NULL,
-			ip_address TEXT,
-			user_agent TEXT,
-			referrer TEXT,
-			country TEXT,
-			clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (url_id) REFERENCES urls(id) ON DELETE CASCADE
-		);
-		
-		CREATE INDEX IF NOT EXISTS idx_url_id ON clicks(url_id);
-		CREATE INDEX IF NOT EXISTS idx_clicked_at ON clicks(clicked_at);
-		`
+// NewDefaultMigrator returns a Migrator for the connected database
+func NewDefaultMigrator() (*Migrator, error) {
+	if DB == nil {
+		return nil, fmt.Errorf("database is not connected")
 	}
+	return NewMigrator(DB, Current())
+}
 
-	_, err := DB.Exec(createTableSQL)
+// migrateUp applies all pending migrations to the connected database
+func migrateUp() error {
+	migrator, err := NewDefaultMigrator()
 	if err != nil {
 		return err
 	}
 
-	// Migrate existing databases: add country column if it doesn't exist
-	return migrateAddCountryColumn(isPostgres)
-}
-
-// migrateAddCountryColumn adds the country column to existing clicks table if it doesn't exist
-func migrateAddCountryColumn(isPostgres bool) error {
-	var alterSQL string
-	if isPostgres {
-		// PostgreSQL: Check if column exists, if not add it
-		alterSQL = `
-			DO $$ 
-			BEGIN
-				IF NOT EXISTS (
-					SELECT 1 FROM information_schema.columns 
-					WHERE table_name = 'clicks' AND column_name = 'country'
-				) THEN
-					ALTER TABLE clicks ADD COLUMN country VARCHAR(100);
-				END IF;
-			END $$;
-		`
-	} else {
-		// SQLite: Check if column exists, if not add it
-		// SQLite doesn't support IF NOT EXISTS for ALTER TABLE ADD COLUMN directly
-		// So we'll try to add it and ignore the error if it already exists
-		alterSQL = `ALTER TABLE clicks ADD COLUMN country TEXT;`
-	}
-
-	_, err := DB.Exec(alterSQL)
-	// Ignore error if column already exists
+	applied, err := migrator.Up(context.Background())
 	if err != nil {
-		errStr := err.Error()
-		if isPostgres || (!strings.Contains(errStr, "duplicate column") && !strings.Contains(errStr, "already exists")) {
-			log.Printf("Warning: Could not add country column (might already exist): %v", err)
-		}
+		return err
+	}
+	if applied > 0 {
+		log.Printf("Applied %d migration(s)", applied)
 	}
 	return nil
 }
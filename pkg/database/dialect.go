@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect captures the SQL differences between SQLite and PostgreSQL so the
+// same query text can run on either backend
+type Dialect struct {
+	name string
+}
+
+var (
+	// SQLite is the dialect used for local development
+	SQLite = Dialect{name: "sqlite"}
+	// Postgres is the dialect used in production deployments
+	Postgres = Dialect{name: "postgres"}
+)
+
+// Current returns the dialect of the configured database
+func Current() Dialect {
+	if IsPostgres() {
+		return Postgres
+	}
+	return SQLite
+}
+
+// Name returns "sqlite" or "postgres"
+func (d Dialect) Name() string {
+	return d.name
+}
+
+// IsPostgres returns true for the PostgreSQL dialect
+func (d Dialect) IsPostgres() bool {
+	return d.name == Postgres.name
+}
+
+// Rebind converts ? placeholders to the dialect's bind syntax ($1, $2, ... on
+// PostgreSQL). Question marks inside quoted literals are left alone.
+func (d Dialect) Rebind(query string) string {
+	if !d.IsPostgres() {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case ch == '\'':
+			inQuote = !inQuote
+			b.WriteByte(ch)
+		case ch == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// Date returns an expression that formats a timestamp column as YYYY-MM-DD text
+func (d Dialect) Date(column string) string {
+	if d.IsPostgres() {
+		return fmt.Sprintf("TO_CHAR(%s, 'YYYY-MM-DD')", column)
+	}
+	return fmt.Sprintf("DATE(%s)", column)
+}
+
+// DaysAgo returns an expression for the current time minus the given number of days
+func (d Dialect) DaysAgo(days int) string {
+	if d.IsPostgres() {
+		return fmt.Sprintf("NOW() - INTERVAL '%d days'", days)
+	}
+	return fmt.Sprintf("datetime('now', '-%d days')", days)
+}
+
+// Time converts a timestamp into a bind value for the dialect. SQLite stores
+// timestamps as UTC text, which its driver parses back into time.Time.
+func (d Dialect) Time(t time.Time) interface{} {
+	if d.IsPostgres() {
+		return t.UTC()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// Queryer is satisfied by *sql.DB, *sql.Tx and *sql.Conn
+type Queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// InsertReturningID runs an INSERT written with ? placeholders and returns the
+// generated id column. PostgreSQL doesn't support LastInsertId, so RETURNING id
+// is used there.
+func (d Dialect) InsertReturningID(ctx context.Context, q Queryer, query string, args ...interface{}) (int64, error) {
+	if d.IsPostgres() {
+		var id int64
+		err := q.QueryRowContext(ctx, d.Rebind(query)+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		query    string
+		expected string
+	}{
+		{"sqlite unchanged", SQLite, "SELECT * FROM urls WHERE code = ? AND user_id = ?", "SELECT * FROM urls WHERE code = ? AND user_id = ?"},
+		{"postgres numbered", Postgres, "SELECT * FROM urls WHERE code = ? AND user_id = ?", "SELECT * FROM urls WHERE code = $1 AND user_id = $2"},
+		{"postgres no placeholders", Postgres, "SELECT 1", "SELECT 1"},
+		{"postgres quoted literal", Postgres, "SELECT '?' FROM urls WHERE code = ?", "SELECT '?' FROM urls WHERE code = $1"},
+		{"postgres escaped quote", Postgres, "SELECT 'it''s ?' WHERE a = ? AND b = ?", "SELECT 'it''s ?' WHERE a = $1 AND b = $2"},
+		{"postgres many", Postgres, "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.dialect.Rebind(tt.query); got != tt.expected {
+				t.Errorf("Rebind(%q) = %q, want %q", tt.query, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDateAndDaysAgo(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		date    string
+		daysAgo string
+	}{
+		{SQLite, "DATE(clicked_at)", "datetime('now', '-7 days')"},
+		{Postgres, "TO_CHAR(clicked_at, 'YYYY-MM-DD')", "NOW() - INTERVAL '7 days'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect.Name(), func(t *testing.T) {
+			if got := tt.dialect.Date("clicked_at"); got != tt.date {
+				t.Errorf("Date = %q, want %q", got, tt.date)
+			}
+			if got := tt.dialect.DaysAgo(7); got != tt.daysAgo {
+				t.Errorf("DaysAgo = %q, want %q", got, tt.daysAgo)
+			}
+		})
+	}
+}
+
+func TestTime(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	ts := time.Date(2024, 5, 1, 14, 30, 0, 0, berlin)
+
+	if got := SQLite.Time(ts); got != "2024-05-01 12:30:00" {
+		t.Errorf("SQLite.Time = %v, want UTC text", got)
+	}
+	if got, ok := Postgres.Time(ts).(time.Time); !ok || !got.Equal(ts) || got.Location() != time.UTC {
+		t.Errorf("Postgres.Time = %v, want the same instant in UTC", Postgres.Time(ts))
+	}
+}
+
+// testDB opens an empty database of the dialect, or skips the test when
+// Postgres is requested but TEST_POSTGRES_URL is unset
+func testDB(t *testing.T, dialect Dialect) *sql.DB {
+	t.Helper()
+
+	if !dialect.IsPostgres() {
+		db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=1&_busy_timeout=5000")
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestInsertReturningID(t *testing.T) {
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		t.Run(dialect.Name(), func(t *testing.T) {
+			db := testDB(t, dialect)
+			ctx := context.Background()
+
+			table := "CREATE TEMPORARY TABLE dialect_test (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL)"
+			if dialect.IsPostgres() {
+				table = "CREATE TEMPORARY TABLE dialect_test (id SERIAL PRIMARY KEY, name TEXT NOT NULL)"
+			}
+			// Temporary tables live on one connection
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				t.Fatalf("conn: %v", err)
+			}
+			defer conn.Close()
+			if _, err := conn.ExecContext(ctx, table); err != nil {
+				t.Fatalf("create table: %v", err)
+			}
+
+			for want := int64(1); want <= 3; want++ {
+				id, err := dialect.InsertReturningID(ctx, conn, "INSERT INTO dialect_test (name) VALUES (?)", "row")
+				if err != nil {
+					t.Fatalf("InsertReturningID: %v", err)
+				}
+				if id != want {
+					t.Errorf("InsertReturningID = %d, want %d", id, want)
+				}
+			}
+
+			if _, err := dialect.InsertReturningID(ctx, conn, "INSERT INTO dialect_test (name) VALUES (?)", nil); err == nil {
+				t.Error("InsertReturningID with a NOT NULL violation succeeded")
+			}
+		})
+	}
+}
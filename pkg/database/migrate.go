@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gourl/migrations"
+)
+
+// migrationLockID is the PostgreSQL advisory lock key held while migrating
+const migrationLockID = 7245019
+
+// Migration is a single versioned schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of the up script
+}
+
+// MigrationStatus describes whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied          bool
+	AppliedAt        time.Time
+	ChecksumMismatch bool
+}
+
+// appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies and rolls back the embedded migrations for one dialect
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator loads the embedded migrations for the given dialect
+func NewMigrator(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	list, err := LoadMigrations(migrations.FS, dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: list}, nil
+}
+
+// LoadMigrations reads NNNN_name.up.sql / NNNN_name.down.sql pairs from the
+// dialect directory of fsys, sorted by version
+func LoadMigrations(fsys fs.FS, dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s migrations: %v", dialect, err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, ".sql")
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", fileName)
+		}
+		base = strings.TrimSuffix(base, "."+direction)
+
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description", fileName)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s has an invalid version number", fileName)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dialect, fileName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", fileName, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d is used by both %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	return list, nil
+}
+
+// Up applies all pending migrations and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if prev, ok := applied[mig.Version]; ok {
+				if prev.Checksum != mig.Checksum {
+					return fmt.Errorf("migration %04d_%s has been modified since it was applied", mig.Version, mig.Name)
+				}
+				continue
+			}
+
+			err := m.run(ctx, conn, mig.Up, func(exec execer) error {
+				if hook := upHooks[m.dialect.Name()][mig.Version]; hook != nil {
+					if err := hook(ctx, exec); err != nil {
+						return err
+					}
+				}
+				_, err := exec.ExecContext(ctx, m.dialect.Rebind(
+					"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+					mig.Version, mig.Name, mig.Checksum, time.Now().UTC(),
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+
+			log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %04d_%s has no down script", mig.Version, mig.Name)
+			}
+
+			err := m.run(ctx, conn, mig.Down, func(exec execer) error {
+				_, err := exec.ExecContext(ctx, m.dialect.Rebind("DELETE FROM schema_migrations WHERE version = ?"), mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rollback of %04d_%s failed: %v", mig.Version, mig.Name, err)
+			}
+
+			log.Printf("Rolled back migration %04d_%s", mig.Version, mig.Name)
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Status reports every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			status := MigrationStatus{Migration: mig}
+			if prev, ok := applied[mig.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = prev.AppliedAt
+				status.ChecksumMismatch = prev.Checksum != mig.Checksum
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// execer is satisfied by both *sql.Conn and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// upHooks finish migrations with steps SQL alone can't express, by dialect
+// and version. They run after the up script in the same transaction.
+var upHooks = map[string]map[int]func(ctx context.Context, exec execer) error{
+	"sqlite": {1: addLegacyClickCountry},
+}
+
+// addLegacyClickCountry adds the country column to clicks tables created
+// before it existed. PostgreSQL does this with ADD COLUMN IF NOT EXISTS,
+// which SQLite lacks.
+func addLegacyClickCountry(ctx context.Context, exec execer) error {
+	var exists bool
+	err := exec.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pragma_table_info('clicks') WHERE name = 'country')").Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = exec.ExecContext(ctx, "ALTER TABLE clicks ADD COLUMN country TEXT")
+	return err
+}
+
+// withLock runs fn on a dedicated connection while holding the migration lock,
+// so concurrent instances (e.g. several serverless cold starts) never race.
+// PostgreSQL uses a session advisory lock; SQLite holds a write transaction
+// for the whole run, which also makes the run atomic.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	if m.dialect.IsPostgres() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %v", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+				log.Printf("Warning: Could not release migration lock: %v", err)
+			}
+		}()
+
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+		return fn(conn)
+	}
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	if err := m.ensureTable(ctx, conn); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	if err := fn(conn); err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return fmt.Errorf("failed to commit migrations: %v", err)
+	}
+	return nil
+}
+
+// run executes a migration script and its bookkeeping statement atomically
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script string, record func(exec execer) error) error {
+	if !m.dialect.IsPostgres() {
+		// Already inside the transaction opened by withLock
+		if _, err := conn.ExecContext(ctx, script); err != nil {
+			return err
+		}
+		return record(conn)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureTable creates the schema_migrations bookkeeping table
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return nil
+}
+
+// applied returns the recorded migrations keyed by version
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+// legacySQLiteSchema is the schema of SQLite databases created before
+// clicks had a country
+const legacySQLiteSchema = `
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE urls (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	code TEXT UNIQUE NOT NULL,
+	original_url TEXT NOT NULL,
+	user_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	expires_at DATETIME
+);
+CREATE TABLE clicks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url_id INTEGER NOT NULL,
+	ip_address TEXT,
+	user_agent TEXT,
+	referrer TEXT,
+	clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO urls (code, original_url) VALUES ('legacy', 'https://example.com');
+INSERT INTO clicks (url_id, ip_address) VALUES (1, '10.0.0.1');
+`
+
+func TestMigrateUp(t *testing.T) {
+	db := testDB(t, SQLite)
+	ctx := context.Background()
+
+	migrator, err := NewMigrator(db, SQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	n, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if n != len(migrator.migrations) {
+		t.Errorf("Up applied %d migrations, want %d", n, len(migrator.migrations))
+	}
+
+	if n, err := migrator.Up(ctx); err != nil || n != 0 {
+		t.Errorf("second Up = %d, %v, want 0", n, err)
+	}
+}
+
+func TestMigrateAdoptsLegacySQLite(t *testing.T) {
+	db := testDB(t, SQLite)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, legacySQLiteSchema); err != nil {
+		t.Fatalf("create legacy schema: %v", err)
+	}
+
+	migrator, err := NewMigrator(db, SQLite)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	var ip, country string
+	err = db.QueryRowContext(ctx, "SELECT ip_address, COALESCE(country, 'none') FROM clicks WHERE url_id = 1").Scan(&ip, &country)
+	if err != nil {
+		t.Fatalf("query adopted click: %v", err)
+	}
+	if ip != "10.0.0.1" || country != "none" {
+		t.Errorf("adopted click = %s, %s", ip, country)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE clicks SET country = 'Germany'"); err != nil {
+		t.Errorf("set country: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DBResolver resolves locations from local MaxMind DB files, e.g. a
+// GeoLite2-City and a GeoLite2-ASN database, merging what each one knows.
+// Files are checked periodically and reloaded when replaced, so updates
+// (e.g. from geoipupdate) apply without a restart.
+type DBResolver struct {
+	files []*dbFile
+	stop  chan struct{}
+	once  sync.Once
+	mu    sync.Mutex // serializes Reload
+}
+
+// dbFile is one database and the file state it was loaded from
+type dbFile struct {
+	path    string
+	reader  atomic.Pointer[Reader]
+	modTime time.Time
+	size    int64
+}
+
+// OpenDB loads the given .mmdb files. If reloadInterval is positive they
+// are checked for changes at that interval until Close is called.
+func OpenDB(paths []string, reloadInterval time.Duration) (*DBResolver, error) {
+	r := &DBResolver{stop: make(chan struct{})}
+	for _, path := range paths {
+		f := &dbFile{path: path}
+		if _, err := f.load(); err != nil {
+			return nil, err
+		}
+		log.Printf("GeoIP: loaded %s (%s, built %s)", path, f.reader.Load().Metadata.DatabaseType,
+			time.Unix(int64(f.reader.Load().Metadata.BuildEpoch), 0).UTC().Format("2006-01-02"))
+		r.files = append(r.files, f)
+	}
+
+	if reloadInterval > 0 {
+		go r.watch(reloadInterval)
+	}
+	return r, nil
+}
+
+func (r *DBResolver) Resolve(ip net.IP) (*Location, error) {
+	var loc Location
+	found := false
+	for _, f := range r.files {
+		record, err := f.reader.Load().Lookup(ip)
+		if err != nil {
+			return nil, fmt.Errorf("geoip: %s: %w", f.path, err)
+		}
+		if record != nil {
+			mergeRecord(&loc, record)
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return &loc, nil
+}
+
+// Reload re-reads every database file that changed since it was loaded.
+// A file that fails to load keeps serving the previous version.
+func (r *DBResolver) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.files {
+		reloaded, err := f.load()
+		if err != nil {
+			log.Printf("GeoIP: keeping previous %s: %v", f.path, err)
+			continue
+		}
+		if reloaded {
+			log.Printf("GeoIP: reloaded %s", f.path)
+		}
+	}
+}
+
+// Close stops watching the files for changes
+func (r *DBResolver) Close() {
+	r.once.Do(func() { close(r.stop) })
+}
+
+func (r *DBResolver) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Reload()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// load (re)reads the file if its modification time or size changed.
+// Replacing the file atomically (write then rename) avoids reading it half
+// written; a truncated file fails to parse and is retried next time.
+func (f *dbFile) load() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if f.reader.Load() != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return false, nil
+	}
+
+	reader, err := OpenReader(f.path)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", f.path, err)
+	}
+	f.reader.Store(reader)
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return true, nil
+}
+
+// mergeRecord copies the fields of a GeoIP2/GeoLite2 (or DB-IP) City,
+// Country or ASN record into loc without overwriting known values
+func mergeRecord(loc *Location, record map[string]interface{}) {
+	country := field(record, "country")
+	if country == nil {
+		country = field(record, "registered_country")
+	}
+	setIfEmpty(&loc.Country, asString(field(country, "names", "en")))
+	setIfEmpty(&loc.CountryCode, asString(field(country, "iso_code")))
+
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		setIfEmpty(&loc.Region, asString(field(subdivisions[0], "names", "en")))
+	}
+	setIfEmpty(&loc.City, asString(field(record, "city", "names", "en")))
+
+	if loc.ASN == 0 {
+		loc.ASN = uint(asUint(record["autonomous_system_number"]))
+	}
+	setIfEmpty(&loc.ASOrg, asString(record["autonomous_system_organization"]))
+}
+
+// field walks nested maps by key, returning nil if any step is missing
+func field(value interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+func setIfEmpty(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
@@ -0,0 +1,136 @@
+package geoip
+
+import (
+	"net"
+	"time"
+)
+
+// Location is what a GeoResolver knows about an IP address. Fields the
+// provider doesn't know are left empty.
+type Location struct {
+	Country     string `json:"country,omitempty"`      // English name, e.g. "Germany"
+	CountryCode string `json:"country_code,omitempty"` // ISO 3166-1 alpha-2, e.g. "DE"
+	Region      string `json:"region,omitempty"`       // First-level subdivision, e.g. "Bavaria"
+	City        string `json:"city,omitempty"`
+	ASN         uint   `json:"asn,omitempty"` // Autonomous system number
+	ASOrg       string `json:"as_org,omitempty"`
+}
+
+// Labels used by Locate for addresses that can't be located
+const (
+	CountryLocal   = "Local (Testing)"
+	CountryPrivate = "Local (Private Network)"
+	CountryUnknown = "Unknown"
+)
+
+// GeoResolver looks up the location of public IP addresses. Resolve returns
+// nil without an error when the provider has no data for ip.
+type GeoResolver interface {
+	Resolve(ip net.IP) (*Location, error)
+}
+
+// Locate resolves ipAddress with r, labelling loopback and private
+// addresses instead of looking them up and reporting failures as Unknown.
+// The result always has a Country.
+func Locate(r GeoResolver, ipAddress string) Location {
+	ip := net.ParseIP(ipAddress)
+	switch {
+	case ip == nil:
+		if ipAddress == "localhost" {
+			return Location{Country: CountryLocal}
+		}
+		return Location{Country: CountryUnknown}
+	case ip.IsLoopback():
+		return Location{Country: CountryLocal}
+	case ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast():
+		return Location{Country: CountryPrivate}
+	}
+
+	if r != nil {
+		if loc, err := r.Resolve(ip); err == nil && loc != nil {
+			// Keep what is known, e.g. the network from an ASN database
+			if loc.Country == "" {
+				loc.Country = CountryUnknown
+			}
+			return *loc
+		}
+	}
+	return Location{Country: CountryUnknown}
+}
+
+// merge fills the fields of l that other knows and l doesn't
+func (l *Location) merge(other *Location) {
+	setIfEmpty(&l.Country, other.Country)
+	setIfEmpty(&l.CountryCode, other.CountryCode)
+	setIfEmpty(&l.Region, other.Region)
+	setIfEmpty(&l.City, other.City)
+	if l.ASN == 0 {
+		l.ASN = other.ASN
+	}
+	setIfEmpty(&l.ASOrg, other.ASOrg)
+}
+
+// Chain returns a resolver that asks each resolver in turn, merging what
+// they know, until one of them knows the country. Earlier resolvers win
+// where they disagree. Nil resolvers are skipped.
+func Chain(resolvers ...GeoResolver) GeoResolver {
+	var chain chainResolver
+	for _, r := range resolvers {
+		if r != nil {
+			chain = append(chain, r)
+		}
+	}
+	return chain
+}
+
+type chainResolver []GeoResolver
+
+func (c chainResolver) Resolve(ip net.IP) (*Location, error) {
+	var merged *Location
+	var firstErr error
+	for _, r := range c {
+		loc, err := r.Resolve(ip)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if loc == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &Location{}
+		}
+		merged.merge(loc)
+		if merged.Country != "" {
+			break
+		}
+	}
+	if merged == nil {
+		return nil, firstErr
+	}
+	return merged, nil
+}
+
+// NewResolver builds the resolver configured for the server: the given
+// MaxMind DB files (reloaded every reloadInterval when replaced), then
+// ip-api.com if useIPAPI is set. If a database can't be opened the error is
+// returned together with a resolver that works without it.
+func NewResolver(dbPaths []string, useIPAPI bool, reloadInterval time.Duration) (GeoResolver, error) {
+	var db GeoResolver
+	var err error
+	if len(dbPaths) > 0 {
+		var files *DBResolver
+		files, err = OpenDB(dbPaths, reloadInterval)
+		if err == nil {
+			db = files
+		}
+	}
+
+	var ipAPI GeoResolver
+	if useIPAPI {
+		ipAPI = NewIPAPIResolver()
+	}
+	return Chain(db, ipAPI), err
+}
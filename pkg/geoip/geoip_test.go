@@ -0,0 +1,79 @@
+package geoip
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// fixedResolver returns the same location (or error) for every address and
+// counts its lookups
+type fixedResolver struct {
+	loc   *Location
+	err   error
+	calls int
+}
+
+func (r *fixedResolver) Resolve(ip net.IP) (*Location, error) {
+	r.calls++
+	return r.loc, r.err
+}
+
+func TestChainMergesResolvers(t *testing.T) {
+	asn := &fixedResolver{loc: &Location{ASN: 3320, ASOrg: "Deutsche Telekom AG"}}
+	city := &fixedResolver{loc: &Location{Country: "Germany", CountryCode: "DE", City: "Berlin", ASN: 1}}
+	last := &fixedResolver{loc: &Location{Country: "France"}}
+
+	loc, err := Chain(asn, nil, city, last).Resolve(net.ParseIP("192.0.2.1"))
+	if err != nil || loc == nil {
+		t.Fatalf("Resolve = %v, %v", loc, err)
+	}
+	want := Location{Country: "Germany", CountryCode: "DE", City: "Berlin", ASN: 3320, ASOrg: "Deutsche Telekom AG"}
+	if *loc != want {
+		t.Errorf("location = %+v, want %+v", *loc, want)
+	}
+	if last.calls != 0 {
+		t.Error("resolvers after the one knowing the country were asked")
+	}
+}
+
+func TestChainErrors(t *testing.T) {
+	failing := &fixedResolver{err: errors.New("service down")}
+	none := &fixedResolver{}
+
+	if loc, err := Chain(failing, none).Resolve(net.ParseIP("192.0.2.1")); loc != nil || err == nil {
+		t.Errorf("Resolve = %v, %v, want the error", loc, err)
+	}
+
+	// A later answer wins over an earlier failure
+	asn := &fixedResolver{loc: &Location{ASN: 64500}}
+	loc, err := Chain(failing, asn).Resolve(net.ParseIP("192.0.2.1"))
+	if err != nil || loc == nil || loc.ASN != 64500 {
+		t.Errorf("Resolve = %+v, %v, want AS64500", loc, err)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	asnOnly := &fixedResolver{loc: &Location{ASN: 64500}}
+	city := &fixedResolver{loc: &Location{Country: "Germany", City: "Berlin"}}
+
+	tests := []struct {
+		name string
+		r    GeoResolver
+		ip   string
+		want Location
+	}{
+		{"loopback", city, "127.0.0.1", Location{Country: CountryLocal}},
+		{"localhost", city, "localhost", Location{Country: CountryLocal}},
+		{"private", city, "10.1.2.3", Location{Country: CountryPrivate}},
+		{"invalid", city, "not-an-ip", Location{Country: CountryUnknown}},
+		{"no resolver", nil, "192.0.2.1", Location{Country: CountryUnknown}},
+		{"found", city, "192.0.2.1", Location{Country: "Germany", City: "Berlin"}},
+		{"network only", asnOnly, "192.0.2.1", Location{Country: CountryUnknown, ASN: 64500}},
+	}
+	for _, tt := range tests {
+		if got := Locate(tt.r, tt.ip); got != tt.want {
+			t.Errorf("%s: Locate = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ipAPIResolver uses the free ip-api.com service (no API key, plain HTTP,
+// 45 requests/minute). It sends visitor IPs to a third party, so it is only
+// meant as a fallback when no local database is configured.
+type ipAPIResolver struct {
+	client *http.Client
+}
+
+// NewIPAPIResolver returns a resolver backed by ip-api.com
+func NewIPAPIResolver() GeoResolver {
+	return &ipAPIResolver{client: &http.Client{Timeout: 2 * time.Second}}
+}
+
+func (r *ipAPIResolver) Resolve(ip net.IP) (*Location, error) {
+	url := fmt.Sprintf("http://ip-api.com/json/%s?fields=status,country,countryCode,regionName,city,as", ip)
+
+	resp, err := r.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ip-api: unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Status      string `json:"status"`
+		Country     string `json:"country"`
+		CountryCode string `json:"countryCode"`
+		RegionName  string `json:"regionName"`
+		City        string `json:"city"`
+		AS          string `json:"as"` // e.g. "AS15169 Google LLC"
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Status != "success" || result.Country == "" {
+		return nil, nil
+	}
+
+	loc := &Location{
+		Country:     result.Country,
+		CountryCode: result.CountryCode,
+		Region:      result.RegionName,
+		City:        result.City,
+	}
+	if number, org, ok := strings.Cut(result.AS, " "); ok && strings.HasPrefix(number, "AS") {
+		if asn, err := strconv.ParseUint(number[2:], 10, 32); err == nil {
+			loc.ASN = uint(asn)
+			loc.ASOrg = org
+		}
+	}
+	return loc, nil
+}
@@ -0,0 +1,375 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// Reader is a minimal reader for the MaxMind DB (.mmdb) format used by
+// GeoLite2, GeoIP2 and DB-IP databases. The whole file is held in memory;
+// lookups are safe for concurrent use.
+//
+// Format: https://maxmind.github.io/MaxMind-DB/
+type Reader struct {
+	Metadata Metadata
+
+	tree      []byte // binary search tree
+	data      []byte // data section
+	ipv4Start uint   // node reached after the 96 zero bits of ::/96
+}
+
+// Metadata describes a MaxMind DB file
+type Metadata struct {
+	DatabaseType string
+	IPVersion    uint
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+}
+
+var (
+	metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+	// ErrInvalidDatabase is returned for files that aren't valid MaxMind DBs
+	ErrInvalidDatabase = errors.New("geoip: invalid MaxMind DB file")
+)
+
+// dataSectionSeparator is the number of zero bytes between tree and data
+const dataSectionSeparator = 16
+
+// OpenReader reads a .mmdb file into memory
+func OpenReader(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(buf)
+}
+
+// NewReader parses a MaxMind DB held in buf
+func NewReader(buf []byte) (*Reader, error) {
+	markerAt := bytes.LastIndex(buf, metadataMarker)
+	if markerAt < 0 {
+		return nil, fmt.Errorf("%w: metadata marker not found", ErrInvalidDatabase)
+	}
+
+	value, _, err := decoder{buf[markerAt+len(metadataMarker):]}.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{Metadata: Metadata{
+		DatabaseType: asString(fields["database_type"]),
+		IPVersion:    uint(asUint(fields["ip_version"])),
+		NodeCount:    uint(asUint(fields["node_count"])),
+		RecordSize:   uint(asUint(fields["record_size"])),
+		BuildEpoch:   asUint(fields["build_epoch"]),
+	}}
+	meta := r.Metadata
+	if meta.RecordSize != 24 && meta.RecordSize != 28 && meta.RecordSize != 32 {
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, meta.RecordSize)
+	}
+	if meta.IPVersion != 4 && meta.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidDatabase, meta.IPVersion)
+	}
+
+	// Checked before multiplying so huge counts can't overflow
+	if meta.NodeCount > uint(markerAt) {
+		return nil, fmt.Errorf("%w: search tree larger than file", ErrInvalidDatabase)
+	}
+	treeSize := meta.NodeCount * meta.RecordSize / 4
+	if treeSize+dataSectionSeparator > uint(markerAt) {
+		return nil, fmt.Errorf("%w: search tree larger than file", ErrInvalidDatabase)
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : markerAt]
+
+	if meta.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < meta.NodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup returns the record of the network containing ip, or nil if the
+// database has none
+func (r *Reader) Lookup(ip net.IP) (map[string]interface{}, error) {
+	key, node := ip.To4(), uint(0)
+	if key != nil {
+		if r.Metadata.IPVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.Metadata.IPVersion == 4 {
+			return nil, nil
+		}
+		if key = ip.To16(); key == nil {
+			return nil, fmt.Errorf("geoip: invalid IP address %v", ip)
+		}
+	}
+
+	nodeCount := r.Metadata.NodeCount
+	for i := 0; i < len(key)*8 && node < nodeCount; i++ {
+		bit := uint(key[i>>3]>>(7-uint(i&7))) & 1
+		node = r.record(node, bit)
+	}
+
+	switch {
+	case node == nodeCount:
+		return nil, nil
+	case node < nodeCount:
+		return nil, fmt.Errorf("%w: search tree deeper than the address", ErrInvalidDatabase)
+	}
+
+	offset := node - nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("%w: data pointer out of range", ErrInvalidDatabase)
+	}
+	value, _, err := decoder{r.data}.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: record is not a map", ErrInvalidDatabase)
+	}
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a tree node
+func (r *Reader) record(node, bit uint) uint {
+	b := r.tree
+	switch r.Metadata.RecordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return (uint(b[off+3])&0xF0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return (uint(b[off+3])&0x0F)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// Data section field types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDecodeDepth guards against pointer loops in corrupt files
+const maxDecodeDepth = 64
+
+var errCorrupt = fmt.Errorf("%w: unexpected end of data", ErrInvalidDatabase)
+
+// decoder decodes values of a data section; pointers are offsets into buf
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset following it
+func (d decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("%w: data nested too deeply", ErrInvalidDatabase)
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, next, err
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errCorrupt
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", ErrInvalidDatabase)
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	raw := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(raw), next, nil
+	case typeBytes:
+		return append([]byte(nil), raw...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double of size %d", ErrInvalidDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float of size %d", ErrInvalidDatabase, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("%w: integer of size %d", ErrInvalidDatabase, size)
+		}
+		return beUint(raw), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("%w: int32 of size %d", ErrInvalidDatabase, size)
+		}
+		return int64(int32(uint32(beUint(raw)))), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("%w: uint128 of size %d", ErrInvalidDatabase, size)
+		}
+		return new(big.Int).SetBytes(raw), next, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unknown data type %d", ErrInvalidDatabase, typ)
+}
+
+// pointer decodes a pointer whose control byte is ctrl
+func (d decoder) pointer(ctrl byte, offset uint) (target, next uint, err error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errCorrupt
+	}
+	v := uint(beUint(d.buf[offset : offset+n]))
+	prefix := uint(ctrl & 0x7)
+	switch n {
+	case 1:
+		target = prefix<<8 | v
+	case 2:
+		target = (prefix<<16 | v) + 2048
+	case 3:
+		target = (prefix<<24 | v) + 526336
+	default:
+		target = v
+	}
+	return target, offset + n, nil
+}
+
+// size decodes the payload size that follows a control byte
+func (d decoder) size(ctrl byte, offset uint) (size, next uint, err error) {
+	size = uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errCorrupt
+	}
+	v := uint(beUint(d.buf[offset : offset+n]))
+	switch size {
+	case 29:
+		size = 29 + v
+	case 30:
+		size = 285 + v
+	default:
+		size = 65821 + v
+	}
+	return size, offset + n, nil
+}
+
+// beUint decodes a big-endian unsigned integer of up to 8 bytes
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func asUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
+
+func asString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,462 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// pointer is a data section offset, written as an MMDB pointer
+type pointer uint
+
+// encodeValue appends v in MaxMind DB data format. Maps, slices, strings,
+// uint32s, uint64s and pointers are supported.
+func encodeValue(buf []byte, v interface{}) []byte {
+	// control writes the control bytes of typ with a payload of size (below 285)
+	control := func(typ, size int) {
+		sizeBits, extra := size, []byte(nil)
+		if size >= 29 {
+			sizeBits, extra = 29, []byte{byte(size - 29)}
+		}
+		if typ > 7 {
+			buf = append(buf, byte(sizeBits), byte(typ-7))
+		} else {
+			buf = append(buf, byte(typ<<5|sizeBits))
+		}
+		buf = append(buf, extra...)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		control(typeMap, len(keys))
+		for _, k := range keys {
+			buf = encodeValue(buf, k)
+			buf = encodeValue(buf, v[k])
+		}
+	case []interface{}:
+		control(typeArray, len(v))
+		for _, item := range v {
+			buf = encodeValue(buf, item)
+		}
+	case string:
+		control(typeString, len(v))
+		buf = append(buf, v...)
+	case uint32:
+		control(typeUint32, 4)
+		buf = binary.BigEndian.AppendUint32(buf, v)
+	case uint64:
+		control(typeUint64, 8)
+		buf = binary.BigEndian.AppendUint64(buf, v)
+	case pointer:
+		// One-byte pointers reach offsets below 2048
+		buf = append(buf, byte(typePointer<<5|int(v>>8)&7), byte(v))
+	default:
+		panic("encodeValue: unsupported type")
+	}
+	return buf
+}
+
+// testNetwork is a network of a test database and its record
+type testNetwork struct {
+	cidr   string
+	record interface{} // Encoded into the data section, or a pointer into it
+}
+
+// testDB describes a generated MaxMind DB
+type testDB struct {
+	ipVersion  int
+	recordSize int
+	shared     []interface{} // Values written first, so records can point at them
+	networks   []testNetwork
+}
+
+// build returns the database file. IPv4 networks of IPv6 databases go
+// under ::/96, where the reader looks for IPv4 addresses.
+func (db testDB) build(t *testing.T) []byte {
+	t.Helper()
+
+	var data []byte
+	for _, v := range db.shared {
+		data = encodeValue(data, v)
+	}
+
+	// Search tree: records are node numbers, or data offsets marked by a
+	// negative value until the node count is known
+	type node [2]int
+	nodes := []node{{0, 0}}
+	const empty = 0 // Node 0 is the root, so no record points at it
+	for _, n := range db.networks {
+		_, network, err := net.ParseCIDR(n.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip, ones := network.IP.To16(), 0
+		if v4 := network.IP.To4(); v4 != nil {
+			ones, _ = network.Mask.Size()
+			if db.ipVersion == 6 {
+				ip, ones = v4.To16(), ones+96
+				copy(ip[10:12], []byte{0, 0}) // ::a.b.c.d rather than ::ffff:a.b.c.d
+			} else {
+				ip = v4
+			}
+		} else {
+			ones, _ = network.Mask.Size()
+		}
+
+		var offset int
+		if p, ok := n.record.(pointer); ok {
+			offset = int(p)
+		} else {
+			offset = len(data)
+			data = encodeValue(data, n.record)
+		}
+
+		current := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[current][bit] = -1 - offset
+				break
+			}
+			if nodes[current][bit] <= empty {
+				nodes = append(nodes, node{0, 0})
+				nodes[current][bit] = len(nodes) - 1
+			}
+			current = nodes[current][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	value := func(r int) uint32 {
+		switch {
+		case r < 0:
+			return uint32(nodeCount + dataSectionSeparator + (-1 - r))
+		case r == empty:
+			return uint32(nodeCount)
+		}
+		return uint32(r)
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		left, right := value(n[0]), value(n[1])
+		switch db.recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left), byte(left>>24<<4)|byte(right>>24&0xF), byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = binary.BigEndian.AppendUint32(buf, left)
+			buf = binary.BigEndian.AppendUint32(buf, right)
+		}
+	}
+	buf = append(buf, make([]byte, dataSectionSeparator)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataMarker...)
+	return encodeValue(buf, map[string]interface{}{
+		"binary_format_major_version": uint32(2),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               "Test-City",
+		"ip_version":                  uint32(db.ipVersion),
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint32(db.recordSize),
+	})
+}
+
+// cityRecord returns a GeoLite2-City style record
+func cityRecord(country, code, city string) map[string]interface{} {
+	return map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": code, "names": map[string]interface{}{"en": country}},
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": city}},
+	}
+}
+
+// testCityDB has a city in an IPv4 and an IPv6 network
+func testCityDB(recordSize int) testDB {
+	return testDB{
+		ipVersion:  6,
+		recordSize: recordSize,
+		networks: []testNetwork{
+			{"81.2.69.0/24", cityRecord("United Kingdom", "GB", "London")},
+			{"2001:db8::/32", cityRecord("Germany", "DE", "Berlin")},
+		},
+	}
+}
+
+// lookupCity returns the city of ip in r, or "" if r has no record
+func lookupCity(t *testing.T, r *Reader, ip string) string {
+	t.Helper()
+	record, err := r.Lookup(net.ParseIP(ip))
+	if err != nil {
+		t.Fatalf("Lookup(%s): %v", ip, err)
+	}
+	if record == nil {
+		return ""
+	}
+	return asString(field(record, "city", "names", "en"))
+}
+
+func TestReaderRecordSizes(t *testing.T) {
+	for _, size := range []int{24, 28, 32} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			r, err := NewReader(testCityDB(size).build(t))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			if r.Metadata.RecordSize != uint(size) || r.Metadata.IPVersion != 6 || r.Metadata.DatabaseType != "Test-City" {
+				t.Errorf("metadata = %+v", r.Metadata)
+			}
+
+			tests := []struct{ ip, city string }{
+				{"81.2.69.142", "London"}, // IPv4 in an IPv6 database
+				{"::81.2.69.1", "London"}, // The same network in IPv6 notation
+				{"2001:db8:1::1", "Berlin"},
+				{"81.2.70.1", ""}, // Missing addresses
+				{"2001:db9::1", ""},
+			}
+			for _, tt := range tests {
+				if got := lookupCity(t, r, tt.ip); got != tt.city {
+					t.Errorf("%s = %q, want %q", tt.ip, got, tt.city)
+				}
+			}
+		})
+	}
+}
+
+func TestReaderLargeRecords(t *testing.T) {
+	// Record values beyond 24 bits, which the small test databases don't reach
+	tests := []struct {
+		size        uint
+		tree        []byte
+		left, right uint
+	}{
+		{24, []byte{0x12, 0x34, 0x56, 0xAB, 0xCD, 0xEF}, 0x123456, 0xABCDEF},
+		{28, []byte{0x12, 0x34, 0x56, 0xAB, 0x78, 0x9A, 0xBC}, 0xA123456, 0xB789ABC},
+		{32, []byte{0xF1, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}, 0xF1234567, 0x89ABCDEF},
+	}
+	for _, tt := range tests {
+		r := &Reader{Metadata: Metadata{RecordSize: tt.size, NodeCount: 1}, tree: tt.tree}
+		if left, right := r.record(0, 0), r.record(0, 1); left != tt.left || right != tt.right {
+			t.Errorf("%d-bit records = %#x, %#x, want %#x, %#x", tt.size, left, right, tt.left, tt.right)
+		}
+	}
+}
+
+func TestReaderIPv4Database(t *testing.T) {
+	db := testCityDB(24)
+	db.ipVersion = 4
+	db.networks = db.networks[:1]
+	r, err := NewReader(db.build(t))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if got := lookupCity(t, r, "81.2.69.142"); got != "London" {
+		t.Errorf("IPv4 lookup = %q, want London", got)
+	}
+	if got := lookupCity(t, r, "2001:db8::1"); got != "" {
+		t.Errorf("IPv6 lookup in an IPv4 database = %q, want none", got)
+	}
+}
+
+func TestReaderPointers(t *testing.T) {
+	germany := map[string]interface{}{"iso_code": "DE", "names": map[string]interface{}{"en": "Germany"}}
+	db := testDB{
+		ipVersion:  6,
+		recordSize: 24,
+		shared:     []interface{}{germany}, // At offset 0
+		networks: []testNetwork{
+			{"192.0.2.0/24", map[string]interface{}{"country": pointer(0), "city": map[string]interface{}{"names": map[string]interface{}{"en": "Berlin"}}}},
+			{"198.51.100.0/24", map[string]interface{}{"country": pointer(0), "city": map[string]interface{}{"names": map[string]interface{}{"en": "Munich"}}}},
+			{"203.0.113.0/24", pointer(0)}, // Networks may share a record
+		},
+	}
+	r, err := NewReader(db.build(t))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	for ip, city := range map[string]string{"192.0.2.1": "Berlin", "198.51.100.1": "Munich"} {
+		record, err := r.Lookup(net.ParseIP(ip))
+		if err != nil {
+			t.Fatalf("Lookup(%s): %v", ip, err)
+		}
+		var loc Location
+		mergeRecord(&loc, record)
+		if loc.Country != "Germany" || loc.CountryCode != "DE" || loc.City != city {
+			t.Errorf("%s = %+v, want %s, Germany", ip, loc, city)
+		}
+	}
+
+	record, err := r.Lookup(net.ParseIP("203.0.113.1"))
+	if err != nil || asString(record["iso_code"]) != "DE" {
+		t.Errorf("pointer record = %v, %v", record, err)
+	}
+}
+
+// noPanic fails the test if f panics
+func noPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if p := recover(); p != nil {
+			t.Fatalf("%s panicked: %v", name, p)
+		}
+	}()
+	f()
+}
+
+func TestReaderCorruptFiles(t *testing.T) {
+	valid := testCityDB(28).build(t)
+	ips := []net.IP{net.ParseIP("81.2.69.142"), net.ParseIP("2001:db8::1"), net.ParseIP("10.0.0.1")}
+
+	// Every truncation either fails to open or looks up without panicking
+	for n := 0; n < len(valid); n++ {
+		noPanic(t, "truncated file", func() {
+			r, err := NewReader(valid[:n])
+			if err == nil {
+				for _, ip := range ips {
+					r.Lookup(ip)
+				}
+			}
+		})
+	}
+
+	// So does every single corrupted byte
+	for i := range valid {
+		corrupt := append([]byte(nil), valid...)
+		corrupt[i] ^= 0xFF
+		noPanic(t, "corrupt file", func() {
+			if r, err := NewReader(corrupt); err == nil {
+				for _, ip := range ips {
+					r.Lookup(ip)
+				}
+			}
+		})
+	}
+
+	tests := []struct {
+		name string
+		db   []byte
+	}{
+		{"empty", nil},
+		{"no metadata", bytes.Repeat([]byte{0}, 64)},
+		{"bad record size", func() []byte {
+			return encodeValue(append(make([]byte, 32), metadataMarker...), map[string]interface{}{
+				"ip_version": uint32(6), "node_count": uint32(1), "record_size": uint32(20),
+			})
+		}()},
+		{"huge node count", func() []byte {
+			return encodeValue(append(make([]byte, 32), metadataMarker...), map[string]interface{}{
+				"ip_version": uint32(6), "node_count": uint64(1 << 62), "record_size": uint32(24),
+			})
+		}()},
+	}
+	for _, tt := range tests {
+		if _, err := NewReader(tt.db); !errors.Is(err, ErrInvalidDatabase) {
+			t.Errorf("%s: NewReader = %v, want ErrInvalidDatabase", tt.name, err)
+		}
+	}
+}
+
+func TestReaderPointerLoop(t *testing.T) {
+	// The value at offset 0 points at itself
+	db := testDB{ipVersion: 6, recordSize: 24, shared: []interface{}{pointer(0)}, networks: []testNetwork{{"192.0.2.0/24", pointer(0)}}}
+	r, err := NewReader(db.build(t))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.Lookup(net.ParseIP("192.0.2.1")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("Lookup = %v, want ErrInvalidDatabase", err)
+	}
+}
+
+// writeDB atomically replaces the file at path, as geoipupdate does
+func writeDB(t *testing.T, path string, data []byte) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDBResolverReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeDB(t, path, testCityDB(24).build(t))
+
+	r, err := OpenDB([]string{path}, 0)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer r.Close()
+
+	city := func() string {
+		t.Helper()
+		loc, err := r.Resolve(net.ParseIP("81.2.69.142"))
+		if err != nil || loc == nil {
+			t.Fatalf("Resolve = %v, %v", loc, err)
+		}
+		return loc.City
+	}
+	if got := city(); got != "London" {
+		t.Fatalf("city = %q, want London", got)
+	}
+
+	updated := testCityDB(24)
+	updated.networks[0].record = cityRecord("United Kingdom", "GB", "Manchester")
+	writeDB(t, path, updated.build(t))
+	r.Reload()
+	if got := city(); got != "Manchester" {
+		t.Errorf("city after reload = %q, want Manchester", got)
+	}
+
+	// A broken update keeps the previous database
+	writeDB(t, path, []byte("not a database"))
+	r.Reload()
+	if got := city(); got != "Manchester" {
+		t.Errorf("city after a failed reload = %q, want Manchester", got)
+	}
+
+	if _, err := OpenDB([]string{filepath.Join(t.TempDir(), "missing.mmdb")}, 0); err == nil {
+		t.Error("OpenDB of a missing file succeeded")
+	}
+}
+
+func TestDBResolverMergesFiles(t *testing.T) {
+	dir := t.TempDir()
+	cityPath, asnPath := filepath.Join(dir, "city.mmdb"), filepath.Join(dir, "asn.mmdb")
+	writeDB(t, cityPath, testCityDB(24).build(t))
+	writeDB(t, asnPath, testDB{ipVersion: 6, recordSize: 24, networks: []testNetwork{
+		{"81.2.69.0/24", map[string]interface{}{"autonomous_system_number": uint32(20712), "autonomous_system_organization": "Andrews & Arnold Ltd"}},
+	}}.build(t))
+
+	r, err := OpenDB([]string{cityPath, asnPath}, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer r.Close()
+
+	loc, err := r.Resolve(net.ParseIP("81.2.69.142"))
+	if err != nil || loc == nil {
+		t.Fatalf("Resolve = %v, %v", loc, err)
+	}
+	if loc.City != "London" || loc.ASN != 20712 || loc.ASOrg != "Andrews & Arnold Ltd" {
+		t.Errorf("location = %+v, want London with AS20712", loc)
+	}
+	if loc, err := r.Resolve(net.ParseIP("192.0.2.1")); loc != nil || err != nil {
+		t.Errorf("missing address = %+v, %v, want nil", loc, err)
+	}
+}
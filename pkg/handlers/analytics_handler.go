@@ -1,19 +1,20 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"log"
 	"net/http"
-	"strings"
-	"time"
+	"sort"
 
-	"gourl/pkg/database"
 	"gourl/pkg/models"
+	"gourl/pkg/store"
 
 	"github.com/gin-gonic/gin"
 )
 
-// GetEnhancedStats returns detailed analytics with time-based stats
+// GetEnhancedStats returns detailed analytics with time-based stats. The
+// series and breakdowns cover the period selected by the from, to,
+// granularity and tz query parameters (see parseStatsPeriod).
 func GetEnhancedStats(c *gin.Context) {
 	code := c.Param("code")
 	if code == "" {
@@ -21,17 +22,13 @@ func GetEnhancedStats(c *gin.Context) {
 		return
 	}
 
-	// Get URL information
-	var urlID int
-	var originalURL string
-	var createdAt time.Time
-	err := database.DB.QueryRow(
-		"SELECT id, original_url, created_at FROM urls WHERE code = ?",
-		code,
-	).Scan(&urlID, &originalURL, &createdAt)
+	stores := getStores(c)
+	ctx := c.Request.Context()
 
+	// Get URL information
+	url, err := stores.URLs.GetByCode(ctx, code)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 			return
 		}
@@ -39,221 +36,204 @@ func GetEnhancedStats(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if !canViewStats(c, url) {
+		return
+	}
 
-	// Get total clicks count
-	var totalClicks int
-	err = database.DB.QueryRow(
-		"SELECT COUNT(*) FROM clicks WHERE url_id = ?",
-		urlID,
-	).Scan(&totalClicks)
-	if err != nil {
-		log.Printf("Error counting clicks: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	// Period and bucketing of the series and breakdowns
+	period, errMsg := parseStatsPeriod(c, url.CreatedAt)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 		return
 	}
 
-	// Get unique IP addresses count
-	var uniqueIPs int
-	err = database.DB.QueryRow(
-		"SELECT COUNT(DISTINCT ip_address) FROM clicks WHERE url_id = ?",
-		urlID,
-	).Scan(&uniqueIPs)
+	response, err := buildEnhancedStats(ctx, stores, url, period)
 	if err != nil {
-		log.Printf("Error counting unique IPs: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	// Get clicks by day (last 30 days)
-	clicksByDay := make(map[string]int)
-	var query string
-	if database.IsPostgres() {
-		query = `
-			SELECT DATE(clicked_at)::text as day, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = $1 AND clicked_at >= NOW() - INTERVAL '30 days'
-			GROUP BY DATE(clicked_at)
-			ORDER BY day DESC
-		`
-	} else {
-		query = `
-			SELECT DATE(clicked_at) as day, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = ? AND clicked_at >= datetime('now', '-30 days')
-			GROUP BY DATE(clicked_at)
-			ORDER BY day DESC
-		`
-	}
-	rows, err := database.DB.Query(query, urlID)
+	c.JSON(http.StatusOK, response)
+}
+
+// buildEnhancedStats runs the queries behind GetEnhancedStats. Only failures
+// of the totals and the series are returned (after logging them); failed
+// breakdowns are logged and left empty.
+func buildEnhancedStats(ctx context.Context, stores *store.Stores, url *models.URL, period statsPeriod) (*models.EnhancedStatsResponse, error) {
+	traffic := period.query.Traffic
+
+	// Get total clicks count, split into human and bot traffic
+	counts, err := countTraffic(ctx, stores.Clicks, url.ID)
 	if err != nil {
-		log.Printf("Error querying clicks by day: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var day string
-			var count int
-			if err := rows.Scan(&day, &count); err == nil {
-				clicksByDay[day] = count
-			}
-		}
+		log.Printf("Error counting clicks: %v", err)
+		return nil, err
 	}
 
-	// Get top referrers
-	topReferrers := []models.ReferrerStat{}
-	if database.IsPostgres() {
-		query = `
-			SELECT referrer, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = $1 AND referrer IS NOT NULL AND referrer != ''
-			GROUP BY referrer
-			ORDER BY count DESC
-			LIMIT 10
-		`
-	} else {
-		query = `
-			SELECT referrer, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = ? AND referrer IS NOT NULL AND referrer != ''
-			GROUP BY referrer
-			ORDER BY count DESC
-			LIMIT 10
-		`
-	}
-	rows, err = database.DB.Query(query, urlID)
+	// Get unique visitors count
+	uniqueIPs, err := stores.Clicks.CountUniqueVisitors(ctx, url.ID, traffic)
 	if err != nil {
-		log.Printf("Error querying referrers: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var ref models.ReferrerStat
-			if err := rows.Scan(&ref.Referrer, &ref.Count); err == nil {
-				topReferrers = append(topReferrers, ref)
-			}
-		}
+		log.Printf("Error counting unique visitors: %v", err)
+		return nil, err
 	}
 
-	// Get user agents breakdown
-	userAgents := make(map[string]int)
-	if database.IsPostgres() {
-		query = `
-			SELECT user_agent, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = $1 AND user_agent IS NOT NULL AND user_agent != ''
-			GROUP BY user_agent
-			ORDER BY count DESC
-			LIMIT 20
-		`
-	} else {
-		query = `
-			SELECT user_agent, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = ? AND user_agent IS NOT NULL AND user_agent != ''
-			GROUP BY user_agent
-			ORDER BY count DESC
-			LIMIT 20
-		`
-	}
-	rows, err = database.DB.Query(query, urlID)
+	// Get clicks by day (last 30 days)
+	clicksByDay, err := stores.Clicks.ClicksByDay(ctx, url.ID, 30, traffic)
 	if err != nil {
-		log.Printf("Error querying user agents: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var ua string
-			var count int
-			if err := rows.Scan(&ua, &count); err == nil {
-				// Simplify user agent (extract browser name)
-				ua = simplifyUserAgent(ua)
-				userAgents[ua] += count
-			}
-		}
+		log.Printf("Error querying clicks by day: %v", err)
+		clicksByDay = make(map[string]int)
 	}
 
-	// Get countries breakdown
-	countries := make(map[string]int)
-	if database.IsPostgres() {
-		query = `
-			SELECT country, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = $1 AND country IS NOT NULL AND country != ''
-			GROUP BY country
-			ORDER BY count DESC
-			LIMIT 20
-		`
-	} else {
-		query = `
-			SELECT country, COUNT(*) as count
-			FROM clicks
-			WHERE url_id = ? AND country IS NOT NULL AND country != ''
-			GROUP BY country
-			ORDER BY count DESC
-			LIMIT 20
-		`
-	}
-	rows, err = database.DB.Query(query, urlID)
+	// Get the zero-filled time series of the period
+	hourly, err := stores.Clicks.HourlyClicks(ctx, url.ID, store.ClickFilter{From: period.from, To: period.to, Traffic: traffic})
 	if err != nil {
-		log.Printf("Error querying countries: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var country string
-			var count int
-			if err := rows.Scan(&country, &count); err == nil {
-				countries[country] = count
-			}
-		}
+		log.Printf("Error querying clicks by hour: %v", err)
+		return nil, err
 	}
+	series, periodClicks := period.series(hourly)
 
-	response := models.EnhancedStatsResponse{
-		Code:          code,
-		OriginalURL:   originalURL,
-		CreatedAt:     createdAt,
-		TotalClicks:   totalClicks,
-		UniqueIPs:     uniqueIPs,
-		ClicksByDay:   clicksByDay,
-		TopReferrers:  topReferrers,
-		UserAgents:    userAgents,
-		Countries:     countries,
+	// Get top referrers
+	topReferrers := []models.ReferrerStat{}
+	referrers, err := stores.Clicks.TopValues(ctx, url.ID, store.DimReferrer, period.query, 10)
+	if err != nil {
+		log.Printf("Error querying referrers: %v", err)
+	}
+	for _, ref := range referrers {
+		topReferrers = append(topReferrers, models.ReferrerStat{Referrer: ref.Value, Count: ref.Count})
 	}
 
-	// Always include countries field, even if empty
-	if countries == nil {
-		response.Countries = make(map[string]int)
+	// Get browser, operating system and device breakdowns
+	browsers := countsByValue(ctx, stores, url.ID, store.DimBrowser, period.query, 20)
+	operatingSystems := countsByValue(ctx, stores, url.ID, store.DimOS, period.query, 20)
+	devices := countsByValue(ctx, stores, url.ID, store.DimDevice, period.query, 10)
+
+	// Get countries breakdown
+	countries := countsByValue(ctx, stores, url.ID, store.DimCountry, period.query, 20)
+
+	// Get targeting rule breakdown
+	ruleStats, err := buildRuleStats(ctx, stores, url, period.query)
+	if err != nil {
+		log.Printf("Error querying clicks by rule: %v", err)
 	}
 
-	c.JSON(http.StatusOK, response)
+	// Get A/B variant breakdown
+	variantStats, err := buildVariantStats(ctx, stores, url, period.query)
+	if err != nil {
+		log.Printf("Error querying clicks by variant: %v", err)
+	}
+
+	return &models.EnhancedStatsResponse{
+		Code:             url.Code,
+		OriginalURL:      url.OriginalURL,
+		CreatedAt:        url.CreatedAt,
+		Traffic:          string(traffic),
+		TotalClicks:      counts.of(traffic),
+		HumanClicks:      counts.human,
+		BotClicks:        counts.bots,
+		UniqueIPs:        uniqueIPs,
+		ClicksByDay:      clicksByDay,
+		Range:            period.statsRange(periodClicks),
+		Series:           series,
+		TopReferrers:     topReferrers,
+		UserAgents:       browsers,
+		Browsers:         browsers,
+		OperatingSystems: operatingSystems,
+		Devices:          devices,
+		Countries:        countries,
+		Rules:            ruleStats,
+		Variants:         variantStats,
+
+		MaxClicks:       url.MaxClicks,
+		RemainingClicks: url.RemainingClicks(),
+	}, nil
 }
 
-// simplifyUserAgent extracts browser name from user agent string
-func simplifyUserAgent(ua string) string {
-	ua = strings.ToLower(ua)
-	
-	// Detect common browsers
-	if strings.Contains(ua, "chrome") && !strings.Contains(ua, "edg") {
-		return "Chrome"
-	}
-	if strings.Contains(ua, "firefox") {
-		return "Firefox"
-	}
-	if strings.Contains(ua, "safari") && !strings.Contains(ua, "chrome") {
-		return "Safari"
-	}
-	if strings.Contains(ua, "edg") {
-		return "Edge"
-	}
-	if strings.Contains(ua, "opera") {
-		return "Opera"
-	}
-	if strings.Contains(ua, "curl") {
-		return "curl"
-	}
-	if strings.Contains(ua, "wget") {
-		return "wget"
+// countsByValue returns the most frequent values of a dimension among clicks
+// matching f as a map. Errors are logged and reported as an empty breakdown.
+func countsByValue(ctx context.Context, stores *store.Stores, urlID int, dim store.Dimension, f store.ClickFilter, limit int) map[string]int {
+	counts := make(map[string]int)
+	values, err := stores.Clicks.TopValues(ctx, urlID, dim, f, limit)
+	if err != nil {
+		log.Printf("Error querying %s breakdown: %v", dim, err)
 	}
-	if strings.Contains(ua, "bot") || strings.Contains(ua, "crawler") {
-		return "Bot/Crawler"
+	for _, v := range values {
+		counts[v.Value] = v.Count
 	}
-	
-	return "Other"
+	return counts
 }
 
+// buildRuleStats breaks the clicks of a targeted link matching f down by
+// the rule that routed them. Clicks that matched no rule are reported with a nil
+// rule ID. All counts come from the raw clicks, so they add up even when
+// retention has deleted older ones. Returns nil for links that have never
+// used targeting.
+func buildRuleStats(ctx context.Context, stores *store.Stores, url *models.URL, f store.ClickFilter) ([]models.RuleStat, error) {
+	clicksByRule, unmatched, err := stores.Clicks.ClicksByRule(ctx, url.ID, f)
+	if err != nil || (len(clicksByRule) == 0 && len(url.Rules) == 0) {
+		return nil, err
+	}
+
+	ruleStats := []models.RuleStat{}
+	for i := range url.Rules {
+		rule := &url.Rules[i]
+		ruleStats = append(ruleStats, models.RuleStat{
+			RuleID:      &rule.ID,
+			Position:    &rule.Position,
+			Destination: rule.Destination,
+			Clicks:      clicksByRule[rule.ID],
+		})
+		delete(clicksByRule, rule.ID)
+	}
+
+	// Rules that have since been replaced
+	removed := make([]int, 0, len(clicksByRule))
+	for ruleID := range clicksByRule {
+		removed = append(removed, ruleID)
+	}
+	sort.Ints(removed)
+	for _, ruleID := range removed {
+		id := ruleID
+		ruleStats = append(ruleStats, models.RuleStat{RuleID: &id, Clicks: clicksByRule[ruleID]})
+	}
+
+	ruleStats = append(ruleStats, models.RuleStat{Destination: url.OriginalURL, Clicks: unmatched})
+	return ruleStats, nil
+}
+
+// buildVariantStats reports clicks and unique visitors per A/B variant matching
+// f, current variants first. Returns nil for links that were never A/B-tested.
+func buildVariantStats(ctx context.Context, stores *store.Stores, url *models.URL, f store.ClickFilter) ([]models.VariantStat, error) {
+	clicksByVariant, err := stores.Clicks.ClicksByVariant(ctx, url.ID, f)
+	if err != nil || (len(clicksByVariant) == 0 && len(url.Variants) == 0) {
+		return nil, err
+	}
+
+	variantStats := []models.VariantStat{}
+	for _, v := range url.Variants {
+		counts := clicksByVariant[v.ID]
+		variantStats = append(variantStats, models.VariantStat{
+			VariantID:      v.ID,
+			Name:           v.Name,
+			Destination:    v.Destination,
+			Weight:         v.Weight,
+			Clicks:         counts.Clicks,
+			UniqueVisitors: counts.Unique,
+		})
+		delete(clicksByVariant, v.ID)
+	}
+
+	// Variants that have since been replaced
+	removed := make([]int, 0, len(clicksByVariant))
+	for variantID := range clicksByVariant {
+		removed = append(removed, variantID)
+	}
+	sort.Ints(removed)
+	for _, variantID := range removed {
+		counts := clicksByVariant[variantID]
+		variantStats = append(variantStats, models.VariantStat{
+			VariantID:      variantID,
+			Clicks:         counts.Clicks,
+			UniqueVisitors: counts.Unique,
+		})
+	}
+	return variantStats, nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+)
+
+func TestBuildRuleStats(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	url := s.createURL(t, &models.URL{Code: "split", OriginalURL: "https://example.com/default"})
+
+	// Clicks recorded before targeting was set up
+	if n, err := buildRuleStats(ctx, s.stores, url, store.ClickFilter{}); err != nil || n != nil {
+		t.Fatalf("buildRuleStats without targeting = %v, %v, want nil", n, err)
+	}
+
+	rules := []models.TargetingRule{
+		{Devices: []string{"mobile"}, Destination: "https://example.com/mobile"},
+		{Languages: []string{"de"}, Destination: "https://example.com/de"},
+	}
+	if err := s.stores.URLs.SetRules(ctx, url, rules); err != nil {
+		t.Fatalf("SetRules: %v", err)
+	}
+	mobile, german := url.Rules[0].ID, url.Rules[1].ID
+	removed := german + 100
+
+	now := time.Now().UTC()
+	old := now.Add(-72 * time.Hour)
+	clicks := []models.Click{
+		{URLID: url.ID, RuleID: &mobile, ClickedAt: now},
+		{URLID: url.ID, RuleID: &mobile, ClickedAt: now},
+		{URLID: url.ID, RuleID: &mobile, ClickedAt: old},
+		{URLID: url.ID, RuleID: &german, ClickedAt: now},
+		{URLID: url.ID, RuleID: &removed, ClickedAt: now},
+		{URLID: url.ID, ClickedAt: now},
+		{URLID: url.ID, ClickedAt: old},
+		{URLID: url.ID, RuleID: &mobile, IsBot: true, ClickedAt: now},
+		{URLID: url.ID, IsBot: true, ClickedAt: now},
+	}
+	if err := s.stores.Clicks.RecordBatch(ctx, clicks); err != nil {
+		t.Fatalf("RecordBatch: %v", err)
+	}
+
+	type split struct {
+		mobile, german, removed, unmatched int
+	}
+	tests := []struct {
+		name   string
+		filter store.ClickFilter
+		want   split
+	}{
+		{"human all time", store.ClickFilter{Traffic: store.TrafficHuman}, split{3, 1, 1, 2}},
+		{"all traffic", store.ClickFilter{Traffic: store.TrafficAll}, split{4, 1, 1, 3}},
+		{"bots", store.ClickFilter{Traffic: store.TrafficBot}, split{1, 0, 0, 1}},
+		{"last day", store.ClickFilter{Traffic: store.TrafficHuman, From: now.Add(-24 * time.Hour)}, split{2, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := buildRuleStats(ctx, s.stores, url, tt.filter)
+			if err != nil {
+				t.Fatalf("buildRuleStats: %v", err)
+			}
+			// Current rules in order, then removed rules that have clicks, then unmatched clicks
+			if len(stats) < 3 {
+				t.Fatalf("got %d stats: %+v", len(stats), stats)
+			}
+			if *stats[0].RuleID != mobile || *stats[1].RuleID != german || *stats[0].Position >= *stats[1].Position || stats[1].Destination != "https://example.com/de" {
+				t.Errorf("rules out of order: %+v", stats[:2])
+			}
+			unmatched := stats[len(stats)-1]
+			if unmatched.RuleID != nil || unmatched.Destination != url.OriginalURL {
+				t.Errorf("unmatched = %+v", unmatched)
+			}
+
+			got := split{mobile: stats[0].Clicks, german: stats[1].Clicks, unmatched: unmatched.Clicks}
+			if len(stats) == 4 {
+				if *stats[2].RuleID != removed || stats[2].Position != nil {
+					t.Errorf("removed rule = %+v", stats[2])
+				}
+				got.removed = stats[2].Clicks
+			} else if len(stats) != 3 {
+				t.Errorf("got %d stats: %+v", len(stats), stats)
+			}
+			if got != tt.want {
+				t.Errorf("clicks = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
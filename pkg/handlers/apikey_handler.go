@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gourl/pkg/auth"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyPrefixLen is how much of an API key is kept to tell it apart
+const apiKeyPrefixLen = len(apiKeyKind) + 6
+
+// GetAPIKeys lists the authenticated user's API keys
+func GetAPIKeys(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	keys, err := getStores(c).Users.APIKeys(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error querying API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// CreateAPIKey issues an API key for the authenticated user. The key is
+// only shown once.
+func CreateAPIKey(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be between 1 and 100 characters"})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	scopes, errMsg := parseScopes(req.Scopes)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	raw, hash, err := auth.GenerateOpaqueToken(apiKeyKind)
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    raw[:apiKeyPrefixLen],
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := getStores(c).Users.CreateAPIKey(c.Request.Context(), key); err != nil {
+		log.Printf("Error creating API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	key.Key = raw
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": key})
+}
+
+// RevokeAPIKey deletes one of the authenticated user's API keys
+func RevokeAPIKey(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := getStores(c).Users.DeleteAPIKey(c.Request.Context(), userID, id); err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		log.Printf("Error revoking API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// parseScopes validates the scopes of a new key, defaulting to all of them
+func parseScopes(requested []string) ([]string, string) {
+	if len(requested) == 0 {
+		return append([]string(nil), models.Scopes...), ""
+	}
+	wanted := make(map[string]bool)
+	for _, scope := range requested {
+		wanted[strings.ToLower(strings.TrimSpace(scope))] = true
+	}
+	var scopes []string
+	for _, scope := range models.Scopes {
+		if wanted[scope] {
+			scopes = append(scopes, scope)
+			delete(wanted, scope)
+		}
+	}
+	if len(wanted) > 0 {
+		return nil, "Scopes must be " + strings.Join(models.Scopes, " or ")
+	}
+	return scopes, ""
+}
@@ -1,15 +1,14 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"gourl/pkg/auth"
-	"gourl/pkg/database"
 	"gourl/pkg/models"
+	"gourl/pkg/store"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,9 +21,11 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	stores := getStores(c)
+	ctx := c.Request.Context()
+
 	// Check if username already exists
-	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ? OR email = ?)", req.Username, req.Email).Scan(&exists)
+	exists, err := stores.Users.Exists(ctx, req.Username, req.Email)
 	if err != nil {
 		log.Printf("Error checking user existence: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -44,36 +45,33 @@ func Register(c *gin.Context) {
 	}
 
 	// Insert user
-	result, err := database.DB.Exec(
-		"INSERT INTO users (username, email, password_hash) VALUES (?, ?, ?)",
-		req.Username, req.Email, passwordHash,
-	)
-	if err != nil {
+	user := models.User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+	}
+	if err := stores.Users.Create(ctx, &user); err != nil {
+		if err == store.ErrConflict {
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email already exists"})
+			return
+		}
 		log.Printf("Error inserting user: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
 
-	userID, _ := result.LastInsertId()
-	
-	// Generate token
-	token, err := auth.GenerateToken(int(userID), req.Username)
+	// Generate tokens
+	tokens, err := startSession(c, &user)
 	if err != nil {
 		log.Printf("Error generating token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	user := models.User{
-		ID:        int(userID),
-		Username:  req.Username,
-		Email:     req.Email,
-		CreatedAt: time.Now(),
-	}
-
 	c.JSON(http.StatusCreated, models.LoginResponse{
-		Token: token,
-		User:  user,
+		TokenResponse: *tokens,
+		User:          user,
 	})
 }
 
@@ -86,14 +84,9 @@ func Login(c *gin.Context) {
 	}
 
 	// Get user from database
-	var user models.User
-	err := database.DB.QueryRow(
-		"SELECT id, username, email, password_hash, created_at FROM users WHERE username = ?",
-		req.Username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
-
+	user, err := getStores(c).Users.GetByUsername(c.Request.Context(), req.Username)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
 		}
@@ -108,8 +101,8 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// Generate token
-	token, err := auth.GenerateToken(user.ID, user.Username)
+	// Generate tokens
+	tokens, err := startSession(c, user)
 	if err != nil {
 		log.Printf("Error generating token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
@@ -117,42 +110,335 @@ func Login(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, models.LoginResponse{
-		Token: token,
-		User:  user,
+		TokenResponse: *tokens,
+		User:          *user,
 	})
 }
 
-// AuthMiddleware validates JWT tokens
+// refreshTokenKind starts every refresh token
+const refreshTokenKind = "grt_"
+
+// startSession issues the first access and refresh token of a new login
+// session, dropping the user's expired refresh tokens along the way
+func startSession(c *gin.Context, user *models.User) (*models.TokenResponse, error) {
+	if err := getStores(c).Users.DeleteExpiredRefreshTokens(c.Request.Context(), user.ID, time.Now()); err != nil {
+		log.Printf("Error deleting expired refresh tokens: %v", err)
+	}
+
+	// Session IDs only need to be unique, which random opaque tokens are
+	sessionID, _, err := auth.GenerateOpaqueToken("")
+	if err != nil {
+		return nil, err
+	}
+	return issueTokens(c, user, sessionID)
+}
+
+// issueTokens issues an access token and the next refresh token of a session
+func issueTokens(c *gin.Context, user *models.User, sessionID string) (*models.TokenResponse, error) {
+	cfg := getConfig(c)
+	ttl := time.Duration(cfg.AccessTokenTTL) * time.Second
+	token, err := auth.GenerateToken(user.ID, user.Username, user.TokenVersion, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, hash, err := auth.GenerateOpaqueToken(refreshTokenKind)
+	if err != nil {
+		return nil, err
+	}
+	refresh := &models.RefreshToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().AddDate(0, 0, cfg.RefreshTokenTTLDays),
+	}
+	if err := getStores(c).Users.CreateRefreshToken(c.Request.Context(), refresh); err != nil {
+		return nil, err
+	}
+
+	return &models.TokenResponse{
+		Token:        token,
+		RefreshToken: raw,
+		ExpiresIn:    cfg.AccessTokenTTL,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh
+// token. Each refresh token works once; presenting a used one means it
+// leaked, so the whole session is revoked.
+func Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	users := getStores(c).Users
+	ctx := c.Request.Context()
+
+	refresh, err := users.RefreshTokenByHash(ctx, auth.HashOpaqueToken(req.RefreshToken))
+	if err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		log.Printf("Error querying refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	now := time.Now()
+	if now.After(refresh.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	used := refresh.UsedAt != nil
+	if !used {
+		ok, err := users.UseRefreshToken(ctx, refresh.ID, now)
+		if err != nil {
+			log.Printf("Error using refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		used = !ok // Exchanged by a concurrent request
+	}
+	if used {
+		log.Printf("Refresh token reused for user %d, revoking session", refresh.UserID)
+		if err := users.DeleteSession(ctx, refresh.UserID, refresh.SessionID); err != nil {
+			log.Printf("Error revoking session: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token already used, please log in again"})
+		return
+	}
+
+	user, err := users.GetByID(ctx, refresh.UserID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		log.Printf("Error querying user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tokens, err := issueTokens(c, user, refresh.SessionID)
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout ends the session of a refresh token. Access tokens already issued
+// for it stay valid until they expire; use LogoutAll to revoke them too.
+func Logout(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	users := getStores(c).Users
+	ctx := c.Request.Context()
+
+	// Unknown tokens are fine: the session is over either way
+	refresh, err := users.RefreshTokenByHash(ctx, auth.HashOpaqueToken(req.RefreshToken))
+	if err != nil && err != store.ErrNotFound {
+		log.Printf("Error querying refresh token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if refresh != nil {
+		if err := users.DeleteSession(ctx, refresh.UserID, refresh.SessionID); err != nil {
+			log.Printf("Error revoking session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// LogoutAll ends every session of the authenticated user and revokes all
+// of their access tokens, including the one used for this request. API
+// keys are not affected.
+func LogoutAll(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	if err := getStores(c).Users.RevokeSessions(c.Request.Context(), userID); err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error revoking sessions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+// apiKeyKind starts every API key, telling keys apart from JWTs
+const apiKeyKind = "guk_"
+
+// apiKeyTouchInterval limits how often the last use of a key is written
+const apiKeyTouchInterval = time.Minute
+
+// credentials returns the token the request authenticates with: an API key
+// in X-API-Key or the token of an "Authorization: Bearer" header. malformed
+// reports an Authorization header in any other format.
+func credentials(c *gin.Context) (token string, malformed bool) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key, false
+	}
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		return "", false
+	}
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", true
+	}
+	return parts[1], false
+}
+
+// authenticate identifies the user behind token, a JWT or an API key, and
+// stores them in the context. On failure it returns the status and message
+// of the error response.
+func authenticate(c *gin.Context, token string) (status int, errMsg string) {
+	if !strings.HasPrefix(token, apiKeyKind) {
+		claims, err := auth.ValidateToken(token)
+		if err != nil {
+			return http.StatusUnauthorized, "Invalid or expired token"
+		}
+		// Looked up on every request so logging out everywhere takes
+		// effect immediately rather than when the tokens expire
+		version, err := getStores(c).Users.TokenVersion(c.Request.Context(), claims.UserID)
+		if err != nil {
+			if err == store.ErrNotFound {
+				return http.StatusUnauthorized, "Invalid or expired token"
+			}
+			log.Printf("Error querying token version: %v", err)
+			return http.StatusInternalServerError, "Database error"
+		}
+		if claims.TokenVersion != version {
+			return http.StatusUnauthorized, "Token has been revoked"
+		}
+		c.Set("userID", claims.UserID)
+		c.Set("username", claims.Username)
+		return 0, ""
+	}
+
+	users := getStores(c).Users
+	key, err := users.APIKeyByHash(c.Request.Context(), auth.HashOpaqueToken(token))
+	if err != nil {
+		if err == store.ErrNotFound {
+			return http.StatusUnauthorized, "Invalid API key"
+		}
+		log.Printf("Error querying API key: %v", err)
+		return http.StatusInternalServerError, "Database error"
+	}
+	now := time.Now()
+	if key.IsExpired(now) {
+		return http.StatusUnauthorized, "API key expired"
+	}
+
+	// Last use is shown to the key's owner; minute precision is plenty
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := users.TouchAPIKey(c.Request.Context(), key.ID, now); err != nil {
+			log.Printf("Error recording API key use: %v", err)
+		}
+	}
+
+	c.Set("userID", key.UserID)
+	c.Set("apiKey", key)
+	return 0, ""
+}
+
+// getAPIKey returns the API key the request authenticated with, or nil for
+// JWTs and anonymous requests
+func getAPIKey(c *gin.Context) *models.APIKey {
+	if k, exists := c.Get("apiKey"); exists {
+		if key, ok := k.(*models.APIKey); ok {
+			return key
+		}
+	}
+	return nil
+}
+
+// AuthMiddleware validates JWTs and API keys
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		token, malformed := credentials(c)
+		if malformed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+			c.Abort()
+			return
+		}
+		if token == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+		if status, errMsg := authenticate(c, token); errMsg != "" {
+			c.JSON(status, gin.H{"error": errMsg})
 			c.Abort()
 			return
 		}
 
-		token := parts[1]
-		claims, err := auth.ValidateToken(token)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleware sets the user info when a valid JWT or API key is
+// supplied but lets anonymous requests through, so public endpoints can
+// attribute ownership (e.g. links created while logged in)
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, _ := credentials(c); token != "" {
+			authenticate(c, token)
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope limits a route to JWTs and API keys with scope. Anonymous
+// requests pass, so it can also guard routes with optional authentication.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := getAPIKey(c); key != nil && !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
 			c.Abort()
 			return
 		}
 
-		// Store user info in context
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
+		c.Next()
+	}
+}
+
+// RequireSession limits a route to JWTs, so a leaked API key can't be used
+// to create more keys or log its owner out
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if getAPIKey(c) != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys can't be used for this endpoint"})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
 }
 
+// GetJWKS publishes the public keys access tokens are signed with, so other
+// services can verify them. Empty while tokens are signed with a secret.
+func GetJWKS(c *gin.Context) {
+	// Short enough that verifiers pick up a new key soon after rotation
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.PublicKeys())
+}
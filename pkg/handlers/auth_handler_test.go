@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gourl/pkg/models"
+)
+
+// createAPIKey creates an API key with scopes and returns the raw key
+func (s *testServer) createAPIKey(t *testing.T, token string, scopes ...string) string {
+	t.Helper()
+	w := s.do("POST", "/api/api-keys", models.CreateAPIKeyRequest{Name: "test", Scopes: scopes}, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create API key: %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		APIKey models.APIKey `json:"api_key"`
+	}
+	decode(t, w, &resp)
+	return resp.APIKey.Key
+}
+
+// refresh exchanges a refresh token
+func (s *testServer) refresh(refreshToken string) *httptest.ResponseRecorder {
+	return s.do("POST", "/api/auth/refresh", models.RefreshRequest{RefreshToken: refreshToken}, "")
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	s.createURL(t, &models.URL{Code: "mine", OriginalURL: "https://example.com", UserID: &owner.User.ID})
+
+	statsOnly := s.createAPIKey(t, owner.Token, models.ScopeStatsRead)
+	if w := s.do("GET", "/api/stats/mine", nil, statsOnly); w.Code != http.StatusOK {
+		t.Errorf("stats with stats:read key = %d (%s)", w.Code, w.Body)
+	}
+	w := s.do("POST", "/api/shorten", models.CreateURLRequest{URL: "https://example.com/new"}, statsOnly)
+	if w.Code != http.StatusForbidden || errorMessage(t, w) != "API key lacks the links:write scope" {
+		t.Errorf("shorten with stats:read key = %d (%s), want 403", w.Code, w.Body)
+	}
+	if w := s.do("PATCH", "/api/urls/mine", map[string]interface{}{"disabled": true}, statsOnly); w.Code != http.StatusForbidden {
+		t.Errorf("PATCH with stats:read key = %d, want 403", w.Code)
+	}
+
+	linksOnly := s.createAPIKey(t, owner.Token, models.ScopeLinksWrite)
+	if w := s.do("POST", "/api/shorten", models.CreateURLRequest{URL: "https://example.com/new"}, linksOnly); w.Code != http.StatusCreated {
+		t.Errorf("shorten with links:write key = %d (%s)", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/api/stats/mine", nil, linksOnly); w.Code != http.StatusForbidden {
+		t.Errorf("stats with links:write key = %d, want 403", w.Code)
+	}
+
+	// Keys can't mint more keys or act as a session
+	if w := s.do("POST", "/api/api-keys", models.CreateAPIKeyRequest{Name: "more"}, linksOnly); w.Code != http.StatusForbidden {
+		t.Errorf("create key with an API key = %d, want 403", w.Code)
+	}
+	if w := s.do("POST", "/api/auth/logout-all", nil, linksOnly); w.Code != http.StatusForbidden {
+		t.Errorf("logout-all with an API key = %d, want 403", w.Code)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	s := newTestServer(t)
+	first := s.register(t, "ann")
+
+	w := s.refresh(first.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh = %d (%s)", w.Code, w.Body)
+	}
+	var second models.TokenResponse
+	decode(t, w, &second)
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token wasn't rotated")
+	}
+
+	// Replaying the used token revokes the whole session, including the
+	// token that replaced it
+	w = s.refresh(first.RefreshToken)
+	if w.Code != http.StatusUnauthorized || errorMessage(t, w) != "Refresh token already used, please log in again" {
+		t.Errorf("reused refresh token = %d (%s), want 401", w.Code, w.Body)
+	}
+	if w := s.refresh(second.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after reuse = %d, want 401", w.Code)
+	}
+
+	// Other sessions are unaffected
+	w = s.do("POST", "/api/auth/login", models.LoginRequest{Username: "ann", Password: "secret123"}, "")
+	var other models.LoginResponse
+	decode(t, w, &other)
+	if w := s.refresh(other.RefreshToken); w.Code != http.StatusOK {
+		t.Errorf("refresh of another session = %d (%s)", w.Code, w.Body)
+	}
+
+	if w := s.refresh("not-a-token"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token = %d, want 401", w.Code)
+	}
+}
+
+func TestLogoutAllRevokesAccessTokens(t *testing.T) {
+	s := newTestServer(t)
+	ann := s.register(t, "ann")
+	bob := s.register(t, "bob")
+
+	if w := s.do("GET", "/api/my-urls", nil, ann.Token); w.Code != http.StatusOK {
+		t.Fatalf("my-urls = %d (%s)", w.Code, w.Body)
+	}
+	if w := s.do("POST", "/api/auth/logout-all", nil, ann.Token); w.Code != http.StatusOK {
+		t.Fatalf("logout-all = %d (%s)", w.Code, w.Body)
+	}
+
+	// The bumped token version rejects unexpired access tokens at once
+	w := s.do("GET", "/api/my-urls", nil, ann.Token)
+	if w.Code != http.StatusUnauthorized || errorMessage(t, w) != "Token has been revoked" {
+		t.Errorf("my-urls after logout-all = %d (%s), want 401", w.Code, w.Body)
+	}
+	if w := s.refresh(ann.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout-all = %d, want 401", w.Code)
+	}
+	if w := s.do("GET", "/api/my-urls", nil, bob.Token); w.Code != http.StatusOK {
+		t.Errorf("another user's token = %d, want 200", w.Code)
+	}
+
+	// A new login works again
+	w = s.do("POST", "/api/auth/login", models.LoginRequest{Username: "ann", Password: "secret123"}, "")
+	var login models.LoginResponse
+	decode(t, w, &login)
+	if w := s.do("GET", "/api/my-urls", nil, login.Token); w.Code != http.StatusOK {
+		t.Errorf("my-urls after logging in again = %d (%s)", w.Code, w.Body)
+	}
+}
+
+func TestAPIKeyReadScope(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	s.createURL(t, &models.URL{Code: "mine", OriginalURL: "https://example.com", UserID: &owner.User.ID})
+
+	statsOnly := s.createAPIKey(t, owner.Token, models.ScopeStatsRead)
+	readOnly := s.createAPIKey(t, owner.Token, models.ScopeLinksRead)
+	writer := s.createAPIKey(t, owner.Token, models.ScopeLinksWrite)
+
+	for _, path := range []string{
+		"/api/my-urls",
+		"/api/urls/mine",
+		"/api/urls/mine/history",
+		"/api/urls/mine/rules",
+		"/api/urls/mine/variants",
+		"/api/urls/mine/share-tokens",
+		"/api/account",
+	} {
+		w := s.do("GET", path, nil, statsOnly)
+		if w.Code != http.StatusForbidden || errorMessage(t, w) != "API key lacks the links:read scope" {
+			t.Errorf("GET %s with stats:read key = %d (%s), want 403", path, w.Code, w.Body)
+		}
+		if w := s.do("GET", path, nil, readOnly); w.Code != http.StatusOK {
+			t.Errorf("GET %s with links:read key = %d (%s)", path, w.Code, w.Body)
+		}
+		if w := s.do("GET", path, nil, writer); w.Code != http.StatusOK {
+			t.Errorf("GET %s with links:write key = %d (%s)", path, w.Code, w.Body)
+		}
+	}
+
+	if w := s.do("PATCH", "/api/urls/mine", map[string]interface{}{"disabled": true}, readOnly); w.Code != http.StatusForbidden {
+		t.Errorf("PATCH with links:read key = %d, want 403", w.Code)
+	}
+}
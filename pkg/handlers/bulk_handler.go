@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"time"
 
-	"gourl/pkg/database"
 	"gourl/pkg/models"
 	"gourl/pkg/utils"
 
@@ -26,18 +25,12 @@ func BulkCreateShortURL(c *gin.Context) {
 	}
 
 	// Get user ID if authenticated
-	var userID interface{}
-	if uid, exists := c.Get("userID"); exists {
-		if id, ok := uid.(int); ok {
-			userID = id
-		}
-	} else {
-		userID = nil
-	}
+	userID := getOptionalUserID(c)
+	stores := getStores(c)
+	ctx := c.Request.Context()
 
 	responses := []models.CreateURLResponse{}
 	now := time.Now()
-	createdAt := now.Format("2006-01-02 15:04:05")
 	baseURL := getBaseURL(c)
 
 	for _, urlReq := range req.URLs {
@@ -76,9 +69,36 @@ func BulkCreateShortURL(c *gin.Context) {
 			}
 		}
 
+		// Hash the optional link password
+		passwordHash, errMsg := hashLinkPassword(urlReq.Password)
+		url := &models.URL{
+			Code:            code,
+			OriginalURL:     urlReq.URL,
+			UserID:          userID,
+			CreatedAt:       now,
+			ExpiresAt:       urlReq.ExpiresAt,
+			StartsAt:        urlReq.StartsAt,
+			PrelaunchURL:    urlReq.Prelaunch,
+			FallbackURL:     urlReq.Fallback,
+			RedirectType:    urlReq.Redirect,
+			PasswordHash:    passwordHash,
+			MaxClicks:       urlReq.MaxClicks,
+			StatsVisibility: urlReq.Stats,
+		}
+		if errMsg == "" {
+			errMsg = validateLinkOptions(url)
+		}
+		if errMsg != "" {
+			log.Printf("Invalid options for %s: %s", urlReq.URL, errMsg)
+			responses = append(responses, models.CreateURLResponse{
+				OriginalURL: urlReq.URL,
+				Code:        "",
+			})
+			continue
+		}
+
 		// Check if code exists
-		var exists bool
-		err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM urls WHERE code = ?)", code).Scan(&exists)
+		exists, err := stores.URLs.CodeExists(ctx, code)
 		if err != nil || exists {
 			responses = append(responses, models.CreateURLResponse{
 				OriginalURL: urlReq.URL,
@@ -88,18 +108,7 @@ func BulkCreateShortURL(c *gin.Context) {
 		}
 
 		// Insert into database
-		var expiresAt interface{}
-		if urlReq.ExpiresAt != nil {
-			expiresAt = urlReq.ExpiresAt.Format("2006-01-02 15:04:05")
-		} else {
-			expiresAt = nil
-		}
-
-		_, err = database.DB.Exec(
-			"INSERT INTO urls (code, original_url, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
-			code, urlReq.URL, userID, createdAt, expiresAt,
-		)
-		if err != nil {
+		if err := stores.URLs.Create(ctx, url); err != nil {
 			log.Printf("Error inserting URL: %v", err)
 			responses = append(responses, models.CreateURLResponse{
 				OriginalURL: urlReq.URL,
@@ -114,6 +123,8 @@ func BulkCreateShortURL(c *gin.Context) {
 			OriginalURL: urlReq.URL,
 			Code:        code,
 			CreatedAt:   now,
+			StartsAt:    urlReq.StartsAt,
+			ExpiresAt:   urlReq.ExpiresAt,
 		})
 	}
 
@@ -122,4 +133,3 @@ func BulkCreateShortURL(c *gin.Context) {
 		Count: len(responses),
 	})
 }
-
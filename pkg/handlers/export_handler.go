@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Export formats
+const (
+	exportCSV    = "csv"
+	exportJSON   = "json"   // A single array
+	exportNDJSON = "ndjson" // One object per line
+)
+
+var exportContentTypes = map[string]string{
+	exportCSV:    "text/csv; charset=utf-8",
+	exportJSON:   "application/json",
+	exportNDJSON: "application/x-ndjson",
+}
+
+// exportFlushEvery is how many records are written between flushes to the client
+const exportFlushEvery = 100
+
+// ExportStats handles GET /api/stats/{code}/export. data=clicks (the
+// default) streams the raw clicks in the period and is limited to the
+// link's owner; data=stats exports the aggregates of GetEnhancedStats as
+// rows, for whoever may view them. The period is selected like for the
+// enhanced stats.
+func ExportStats(c *gin.Context) {
+	code := c.Param("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", exportCSV))
+	if _, ok := exportContentTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv, json or ndjson"})
+		return
+	}
+	data := strings.ToLower(c.DefaultQuery("data", "clicks"))
+	if data != "clicks" && data != "stats" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Data must be clicks or stats"})
+		return
+	}
+
+	stores := getStores(c)
+	ctx := c.Request.Context()
+
+	url, err := stores.URLs.GetByCode(ctx, code)
+	if err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+			return
+		}
+		log.Printf("Error querying URL: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Raw clicks carry visitor details, so only the owner gets them
+	if data == "clicks" {
+		uid, loggedIn := c.Get("userID")
+		if !loggedIn {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to export clicks"})
+			return
+		}
+		if userID, _ := uid.(int); url.UserID == nil || *url.UserID != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to export the clicks of this URL"})
+			return
+		}
+	} else if !canViewStats(c, url) {
+		return
+	}
+
+	period, errMsg := parseStatsPeriod(c, url.CreatedAt)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	var stats *models.EnhancedStatsResponse
+	if data == "stats" {
+		// Aggregates are computed before anything is sent so failures can
+		// still be reported with a status code
+		if stats, err = buildEnhancedStats(ctx, stores, url, period); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", exportContentTypes[format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, code, data, format))
+	c.Status(http.StatusOK)
+
+	if data == "stats" {
+		out := newRecordStream(c.Writer, format, statsRowColumns)
+		for _, row := range statsRows(stats) {
+			if err := out.write(row, row.fields()); err != nil {
+				log.Printf("Error exporting stats: %v", err)
+				return
+			}
+		}
+		if err := out.close(); err != nil {
+			log.Printf("Error exporting stats: %v", err)
+		}
+		return
+	}
+
+	out := newRecordStream(c.Writer, format, clickColumns)
+	err = stores.Clicks.EachClick(ctx, url.ID, period.query, func(click *models.Click) error {
+		return out.write(click, clickFields(click))
+	})
+	if err == nil {
+		err = out.close()
+	}
+	if err != nil {
+		// Headers are gone; a truncated file is all the client will see
+		log.Printf("Error exporting clicks: %v", err)
+	}
+}
+
+// recordStream writes records as a CSV table, a JSON array or NDJSON,
+// flushing them to the client as it goes
+type recordStream struct {
+	w      gin.ResponseWriter
+	format string
+	csv    *csv.Writer
+	n      int
+	err    error // First write error; later writes are skipped
+}
+
+// newRecordStream starts a stream, writing the CSV header or opening the JSON array
+func newRecordStream(w gin.ResponseWriter, format string, columns []string) *recordStream {
+	s := &recordStream{w: w, format: format}
+	switch format {
+	case exportCSV:
+		s.csv = csv.NewWriter(w)
+		s.err = s.csv.Write(columns)
+	case exportJSON:
+		_, s.err = w.Write([]byte("["))
+	}
+	return s
+}
+
+// write adds a record: CSV uses fields, the JSON formats marshal value
+func (s *recordStream) write(value interface{}, fields []string) error {
+	if s.err != nil {
+		return s.err
+	}
+	switch s.format {
+	case exportCSV:
+		s.err = s.csv.Write(fields)
+	default:
+		var line []byte
+		if line, s.err = json.Marshal(value); s.err != nil {
+			return s.err
+		}
+		switch {
+		case s.format == exportNDJSON:
+			line = append(line, '\n')
+		case s.n > 0:
+			line = append([]byte(","), line...)
+		}
+		_, s.err = s.w.Write(line)
+	}
+
+	if s.n++; s.n%exportFlushEvery == 0 {
+		s.flush()
+	}
+	return s.err
+}
+
+// close ends the stream, closing the JSON array
+func (s *recordStream) close() error {
+	if s.err == nil && s.format == exportJSON {
+		_, s.err = s.w.Write([]byte("]"))
+	}
+	s.flush()
+	return s.err
+}
+
+func (s *recordStream) flush() {
+	if s.csv != nil {
+		s.csv.Flush()
+		if s.err == nil {
+			s.err = s.csv.Error()
+		}
+	}
+	s.w.Flush()
+}
+
+var clickColumns = []string{
+	"id", "clicked_at", "ip_address", "visitor_id", "country", "region", "city", "asn", "referrer", "user_agent",
+	"browser", "browser_version", "os", "os_version", "device", "is_bot", "rule_id", "variant_id",
+}
+
+// clickFields returns the CSV fields of a click, in clickColumns order
+func clickFields(click *models.Click) []string {
+	return []string{
+		strconv.Itoa(click.ID),
+		click.ClickedAt.UTC().Format(time.RFC3339),
+		click.IPAddress,
+		click.VisitorID,
+		csvText(click.Country),
+		csvText(click.Region),
+		csvText(click.City),
+		optionalInt(click.ASN),
+		csvText(click.Referrer),
+		csvText(click.UserAgent),
+		csvText(click.Browser),
+		csvText(click.BrowserVersion),
+		csvText(click.OS),
+		csvText(click.OSVersion),
+		click.Device,
+		strconv.FormatBool(click.IsBot),
+		optionalID(click.RuleID),
+		optionalID(click.VariantID),
+	}
+}
+
+// csvText keeps visitor-controlled text from being run as a formula by
+// spreadsheets that open the export
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func optionalInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func optionalID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// statsRow is one line of an aggregated stats export
+type statsRow struct {
+	Section  string `json:"section"` // total, series, referrer, browser, os, device, country, rule or variant
+	Key      string `json:"key"`
+	Clicks   int    `json:"clicks"`
+	Visitors *int   `json:"visitors,omitempty"` // Unique visitors, where known
+}
+
+var statsRowColumns = []string{"section", "key", "clicks", "visitors"}
+
+func (r statsRow) fields() []string {
+	return []string{r.Section, csvText(r.Key), strconv.Itoa(r.Clicks), optionalID(r.Visitors)}
+}
+
+// statsRows flattens enhanced stats into export rows. Breakdowns are
+// ordered by clicks, the series by time.
+func statsRows(stats *models.EnhancedStatsResponse) []statsRow {
+	visitors := stats.UniqueIPs
+	rows := []statsRow{
+		{Section: "total", Key: "clicks", Clicks: stats.TotalClicks, Visitors: &visitors},
+		{Section: "total", Key: "human_clicks", Clicks: stats.HumanClicks},
+		{Section: "total", Key: "bot_clicks", Clicks: stats.BotClicks},
+		{Section: "total", Key: "period_clicks", Clicks: stats.Range.Clicks},
+	}
+	for _, point := range stats.Series {
+		rows = append(rows, statsRow{Section: "series", Key: point.Start.Format(time.RFC3339), Clicks: point.Clicks})
+	}
+	for _, ref := range stats.TopReferrers {
+		rows = append(rows, statsRow{Section: "referrer", Key: ref.Referrer, Clicks: ref.Count})
+	}
+	rows = appendCounts(rows, "browser", stats.Browsers)
+	rows = appendCounts(rows, "os", stats.OperatingSystems)
+	rows = appendCounts(rows, "device", stats.Devices)
+	rows = appendCounts(rows, "country", stats.Countries)
+	for _, rule := range stats.Rules {
+		key := rule.Destination
+		if rule.RuleID != nil {
+			key = fmt.Sprintf("rule %d: %s", *rule.RuleID, rule.Destination)
+		}
+		rows = append(rows, statsRow{Section: "rule", Key: strings.TrimSuffix(key, ": "), Clicks: rule.Clicks})
+	}
+	for _, variant := range stats.Variants {
+		unique := variant.UniqueVisitors
+		key := variant.Name
+		if key == "" {
+			key = fmt.Sprintf("variant %d", variant.VariantID)
+		}
+		rows = append(rows, statsRow{Section: "variant", Key: key, Clicks: variant.Clicks, Visitors: &unique})
+	}
+	return rows
+}
+
+// appendCounts adds the rows of a breakdown, most clicks first
+func appendCounts(rows []statsRow, section string, counts map[string]int) []statsRow {
+	start := len(rows)
+	for key, n := range counts {
+		rows = append(rows, statsRow{Section: section, Key: key, Clicks: n})
+	}
+	added := rows[start:]
+	sort.Slice(added, func(i, j int) bool {
+		if added[i].Clicks != added[j].Clicks {
+			return added[i].Clicks > added[j].Clicks
+		}
+		return added[i].Key < added[j].Key
+	})
+	return rows
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+// seedExportClicks stores one click per day on Jan 1, 2 and 3, 2025 with
+// text a spreadsheet would run as a formula
+func seedExportClicks(t *testing.T, s *testServer, urlID int) {
+	t.Helper()
+	var clicks []models.Click
+	for day := 1; day <= 3; day++ {
+		clicks = append(clicks, models.Click{
+			URLID:     urlID,
+			IPAddress: "203.0.113.7",
+			UserAgent: browserUA,
+			Referrer:  "=HYPERLINK(\"https://evil.example\")",
+			Country:   "DE",
+			Browser:   "Chrome",
+			Device:    "desktop",
+			ClickedAt: time.Date(2025, 1, day, 12, 0, 0, 0, time.UTC),
+		})
+	}
+	if err := s.stores.Clicks.RecordBatch(context.Background(), clicks); err != nil {
+		t.Fatalf("RecordBatch: %v", err)
+	}
+}
+
+func TestExportClicksOwnerOnly(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	other := s.register(t, "bob")
+	s.createURL(t, &models.URL{Code: "owned", OriginalURL: "https://example.com", UserID: &owner.User.ID})
+	s.createURL(t, &models.URL{Code: "anon", OriginalURL: "https://example.com"})
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"anonymous", "/api/stats/owned/export", "", http.StatusUnauthorized},
+		{"other user", "/api/stats/owned/export", other.Token, http.StatusForbidden},
+		{"anonymous link", "/api/stats/anon/export", owner.Token, http.StatusForbidden},
+		{"owner", "/api/stats/owned/export", owner.Token, http.StatusOK},
+		{"missing", "/api/stats/missing/export", owner.Token, http.StatusNotFound},
+		{"bad format", "/api/stats/owned/export?format=xml", owner.Token, http.StatusBadRequest},
+		{"bad data", "/api/stats/owned/export?data=urls", owner.Token, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := s.do("GET", tt.path, nil, tt.token); w.Code != tt.status {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestExportClickFormats(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	created := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	url := s.createURL(t, &models.URL{Code: "owned", OriginalURL: "https://example.com", UserID: &owner.User.ID, CreatedAt: created})
+	s.createURL(t, &models.URL{Code: "quiet", OriginalURL: "https://example.com", UserID: &owner.User.ID, CreatedAt: created})
+	seedExportClicks(t, s, url.ID)
+
+	t.Run("csv", func(t *testing.T) {
+		w := s.do("GET", "/api/stats/owned/export?format=csv", nil, owner.Token)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+			t.Fatalf("export = %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="owned-clicks.csv"` {
+			t.Errorf("Content-Disposition = %q", got)
+		}
+		records, err := csv.NewReader(w.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("parse csv: %v", err)
+		}
+		if len(records) != 4 || strings.Join(records[0], ",") != strings.Join(clickColumns, ",") {
+			t.Fatalf("records = %q", records)
+		}
+		for _, record := range records[1:] {
+			if len(record) != len(clickColumns) {
+				t.Fatalf("record has %d fields, want %d", len(record), len(clickColumns))
+			}
+			if record[8] != `'=HYPERLINK("https://evil.example")` || record[4] != "DE" {
+				t.Errorf("record = %q", record)
+			}
+		}
+		if records[1][1] != "2025-01-01T12:00:00Z" || records[3][1] != "2025-01-03T12:00:00Z" {
+			t.Errorf("clicked_at = %s .. %s, want oldest first", records[1][1], records[3][1])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		w := s.do("GET", "/api/stats/owned/export?format=json", nil, owner.Token)
+		if w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+		}
+		var clicks []models.Click
+		decode(t, w, &clicks)
+		if len(clicks) != 3 || clicks[0].Referrer != `=HYPERLINK("https://evil.example")` || clicks[2].URLID != url.ID {
+			t.Errorf("clicks = %+v", clicks)
+		}
+	})
+
+	t.Run("empty json", func(t *testing.T) {
+		w := s.do("GET", "/api/stats/quiet/export?format=json", nil, owner.Token)
+		if w.Code != http.StatusOK || w.Body.String() != "[]" {
+			t.Errorf("export = %d %q, want []", w.Code, w.Body)
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		w := s.do("GET", "/api/stats/owned/export?format=ndjson", nil, owner.Token)
+		if w.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+		}
+		lines := bufio.NewScanner(w.Body)
+		n := 0
+		for lines.Scan() {
+			var click models.Click
+			if err := json.Unmarshal(lines.Bytes(), &click); err != nil {
+				t.Fatalf("line %d %q: %v", n, lines.Text(), err)
+			}
+			n++
+		}
+		if n != 3 {
+			t.Errorf("lines = %d, want 3", n)
+		}
+	})
+
+	t.Run("period", func(t *testing.T) {
+		w := s.do("GET", "/api/stats/owned/export?format=json&from=2025-01-02&to=2025-01-02", nil, owner.Token)
+		var clicks []models.Click
+		decode(t, w, &clicks)
+		if len(clicks) != 1 || !clicks[0].ClickedAt.Equal(time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)) {
+			t.Errorf("clicks on Jan 2 = %+v", clicks)
+		}
+
+		w = s.do("GET", "/api/stats/owned/export?format=json&from=2025-01-02T13:00:00Z", nil, owner.Token)
+		decode(t, w, &clicks)
+		if len(clicks) != 1 || clicks[0].ClickedAt.Day() != 3 {
+			t.Errorf("clicks from Jan 2 13:00 = %+v", clicks)
+		}
+	})
+}
+
+func TestExportStatsVisibility(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	other := s.register(t, "bob")
+	created := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	private := s.createURL(t, &models.URL{Code: "private", OriginalURL: "https://example.com", UserID: &owner.User.ID, StatsVisibility: models.StatsOwner, CreatedAt: created})
+	public := s.createURL(t, &models.URL{Code: "public", OriginalURL: "https://example.com", UserID: &owner.User.ID, CreatedAt: created})
+	seedExportClicks(t, s, private.ID)
+	seedExportClicks(t, s, public.ID)
+
+	tests := []struct {
+		name   string
+		code   string
+		token  string
+		status int
+	}{
+		{"owner-only anonymous", "private", "", http.StatusUnauthorized},
+		{"owner-only other user", "private", other.Token, http.StatusForbidden},
+		{"owner-only owner", "private", owner.Token, http.StatusOK},
+		{"public anonymous", "public", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("GET", "/api/stats/"+tt.code+"/export?data=stats&format=json", nil, tt.token)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var rows []statsRow
+			decode(t, w, &rows)
+			found := map[string]int{}
+			for _, row := range rows {
+				found[row.Section+"/"+row.Key] = row.Clicks
+			}
+			if found["total/clicks"] != 3 || found["country/DE"] != 3 || found["browser/Chrome"] != 3 {
+				t.Errorf("rows = %+v", rows)
+			}
+		})
+	}
+
+	w := s.do("GET", "/api/stats/public/export?data=stats&format=csv", nil, "")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) < 2 || strings.Join(records[0], ",") != "section,key,clicks,visitors" {
+		t.Fatalf("csv = %q, %v", records, err)
+	}
+	for _, record := range records[1:] {
+		if record[0] == "referrer" && !strings.HasPrefix(record[1], "'=") {
+			t.Errorf("referrer key %q isn't escaped", record[1])
+		}
+	}
+}
+
+func TestCSVText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"Chrome", "Chrome"},
+		{"=1+1", "'=1+1"},
+		{"+49 30", "'+49 30"},
+		{"-2", "'-2"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+		{"\rx", "'\rx"},
+		{"a=b", "a=b"},
+	}
+	for _, tt := range tests {
+		if got := csvText(tt.in); got != tt.want {
+			t.Errorf("csvText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
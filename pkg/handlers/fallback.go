@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"gourl/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unavailableReasons describes links that no longer redirect to their destination
+var unavailableReasons = map[string]struct{ title, message string }{
+	models.URLStatusExpired:   {"Link expired", "This short URL has expired"},
+	models.URLStatusDisabled:  {"Link disabled", "This short URL has been disabled"},
+	models.URLStatusExhausted: {"Link no longer available", "This short URL has reached its click limit"},
+}
+
+// fallbackURL picks where an unavailable link should send visitors: the
+// link's own fallback, then its owner's, then the server-wide default
+func fallbackURL(c *gin.Context, url *models.URL) string {
+	if url.FallbackURL != "" {
+		return url.FallbackURL
+	}
+	if url.UserID != nil {
+		owner, err := getStores(c).Users.GetByID(c.Request.Context(), *url.UserID)
+		if err != nil {
+			log.Printf("Error loading owner of %s: %v", url.Code, err)
+		} else if owner.FallbackURL != "" {
+			return owner.FallbackURL
+		}
+	}
+	return getConfig(c).FallbackURL
+}
+
+// respondUnavailable answers a visit to an expired, disabled or exhausted
+// link: a fallback redirect if one is configured, otherwise 410 Gone as an
+// HTML page for browsers or JSON for API clients
+func respondUnavailable(c *gin.Context, url *models.URL, status string) {
+	// The link may be edited back to life, so never cache this answer
+	c.Header("Cache-Control", "no-store")
+
+	if fallback := fallbackURL(c, url); fallback != "" {
+		c.Redirect(http.StatusFound, fallback)
+		return
+	}
+
+	reason := unavailableReasons[status]
+	if wantsHTML(c) {
+		renderPage(c, http.StatusGone, unavailablePage, gin.H{"Title": reason.title, "Message": reason.message})
+		return
+	}
+	c.JSON(http.StatusGone, gin.H{"error": reason.message})
+}
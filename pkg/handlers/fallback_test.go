@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+// unavailableLinks creates an expired, a disabled and a used-up link with
+// the given owner and fallback, returning their codes
+func unavailableLinks(t *testing.T, s *testServer, prefix string, owner *int, fallback string) map[string]string {
+	t.Helper()
+	past := time.Now().Add(-time.Hour)
+	limit := 1
+	codes := map[string]string{
+		models.URLStatusExpired:   prefix + "-expired",
+		models.URLStatusDisabled:  prefix + "-disabled",
+		models.URLStatusExhausted: prefix + "-exhausted",
+	}
+	s.createURL(t, &models.URL{Code: codes[models.URLStatusExpired], OriginalURL: "https://example.com", UserID: owner, FallbackURL: fallback, ExpiresAt: &past})
+	s.createURL(t, &models.URL{Code: codes[models.URLStatusDisabled], OriginalURL: "https://example.com", UserID: owner, FallbackURL: fallback, Disabled: true})
+	s.createURL(t, &models.URL{Code: codes[models.URLStatusExhausted], OriginalURL: "https://example.com", UserID: owner, FallbackURL: fallback, MaxClicks: &limit})
+	if w := s.do("GET", "/"+codes[models.URLStatusExhausted], nil, ""); w.Code != http.StatusFound {
+		t.Fatalf("first visit of %s = %d", codes[models.URLStatusExhausted], w.Code)
+	}
+	return codes
+}
+
+func TestUnavailableWithoutFallback(t *testing.T) {
+	s := newTestServer(t)
+	codes := unavailableLinks(t, s, "plain", nil, "")
+
+	for status, code := range codes {
+		t.Run(status, func(t *testing.T) {
+			reason := unavailableReasons[status]
+
+			w := s.visitAs("GET", "/"+code, browserUA, http.Header{"Accept": {"application/json"}})
+			if w.Code != http.StatusGone || w.Header().Get("Cache-Control") != "no-store" {
+				t.Fatalf("JSON = %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+			}
+			if got := errorMessage(t, w); got != reason.message {
+				t.Errorf("error = %q, want %q", got, reason.message)
+			}
+
+			w = s.visitAs("GET", "/"+code, browserUA, http.Header{"Accept": {"text/html,application/xhtml+xml"}})
+			if w.Code != http.StatusGone || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+				t.Fatalf("HTML = %d %s", w.Code, w.Header().Get("Content-Type"))
+			}
+			if body := w.Body.String(); !strings.Contains(body, reason.title) || !strings.Contains(body, reason.message) {
+				t.Errorf("page doesn't explain %s: %s", status, body)
+			}
+		})
+	}
+}
+
+func TestUnavailableFallbackOrder(t *testing.T) {
+	s := newTestServer(t)
+	withFallback := s.register(t, "ann")
+	without := s.register(t, "bob")
+	if err := s.stores.Users.SetFallbackURL(context.Background(), withFallback.User.ID, "https://ann.example.com/gone"); err != nil {
+		t.Fatalf("SetFallbackURL: %v", err)
+	}
+	s.cfg.FallbackURL = "https://server.example.com/gone"
+
+	tests := []struct {
+		name     string
+		owner    *int
+		fallback string
+		location string
+	}{
+		{"link", &withFallback.User.ID, "https://link.example.com/gone", "https://link.example.com/gone"},
+		{"owner", &withFallback.User.ID, "", "https://ann.example.com/gone"},
+		{"server", &without.User.ID, "", "https://server.example.com/gone"},
+		{"anonymous", nil, "", "https://server.example.com/gone"},
+	}
+	for _, tt := range tests {
+		codes := unavailableLinks(t, s, tt.name, tt.owner, tt.fallback)
+		for status, code := range codes {
+			t.Run(tt.name+"/"+status, func(t *testing.T) {
+				w := s.do("GET", "/"+code, nil, "")
+				if w.Code != http.StatusFound || w.Header().Get("Location") != tt.location {
+					t.Errorf("redirect = %d to %q, want %q", w.Code, w.Header().Get("Location"), tt.location)
+				}
+				if w.Header().Get("Cache-Control") != "no-store" {
+					t.Errorf("Cache-Control = %q", w.Header().Get("Cache-Control"))
+				}
+			})
+		}
+	}
+
+	// Links that still work ignore every fallback
+	s.createURL(t, &models.URL{Code: "working", OriginalURL: "https://example.com/live", UserID: &withFallback.User.ID, FallbackURL: "https://link.example.com/gone"})
+	if w := s.do("GET", "/working", nil, ""); w.Header().Get("Location") != "https://example.com/live" {
+		t.Errorf("working link went to %q", w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gourl/pkg/config"
+	"gourl/pkg/ingest"
+	"gourl/pkg/live"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testServer is the router of the server on in-memory stores
+type testServer struct {
+	router  *gin.Engine
+	stores  *store.Stores // What handlers use, with the URL cache
+	backend *store.Stores // The same stores without the cache, as another instance sees them
+	clicks  *ingest.Queue
+	live    *live.Hub // Allows one stream per link
+	cfg     *config.Config
+}
+
+// newTestServer routes requests like cmd/server, minus rate limiting, to
+// handlers on empty in-memory stores with cached redirect lookups
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	backend := store.NewMemory()
+	cached := *backend
+	cached.URLs = store.NewCachedURLStore(backend.URLs, time.Minute)
+
+	s := &testServer{
+		stores:  &cached,
+		backend: backend,
+		cfg: &config.Config{
+			NotActiveStatus:     403,
+			RedirectType:        models.RedirectTemporary,
+			AccessTokenTTL:      900,
+			RefreshTokenTTLDays: 30,
+			LiveHeartbeat:       15,
+		},
+		live: live.NewHub(10, 1),
+	}
+	s.clicks = ingest.New(s.stores.Clicks, nil, ingest.Options{Workers: 1, FlushInterval: 10 * time.Millisecond, Live: s.live})
+	t.Cleanup(func() {
+		s.live.Close()
+		s.clicks.Close(context.Background())
+	})
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("config", s.cfg)
+		c.Next()
+	})
+	r.Use(WithStores(s.stores))
+	r.Use(WithClickQueue(s.clicks))
+	r.Use(WithLiveHub(s.live))
+	r.Use(WithBotClassifier(utils.NewBotClassifier(nil)))
+
+	auth := r.Group("/api/auth")
+	{
+		auth.POST("/register", Register)
+		auth.POST("/login", Login)
+		auth.POST("/refresh", Refresh)
+		auth.POST("/logout", Logout)
+		auth.POST("/logout-all", AuthMiddleware(), RequireSession(), LogoutAll)
+	}
+
+	linksWrite := RequireScope(models.ScopeLinksWrite)
+	statsRead := RequireScope(models.ScopeStatsRead)
+	linksRead := RequireScope(models.ScopeLinksRead)
+
+	api := r.Group("/api")
+	{
+		api.POST("/shorten", OptionalAuthMiddleware(), linksWrite, CreateShortURL)
+		api.POST("/shorten/bulk", OptionalAuthMiddleware(), linksWrite, BulkCreateShortURL)
+		api.GET("/stats/:code", OptionalAuthMiddleware(), statsRead, GetStats)
+		api.GET("/stats/:code/enhanced", OptionalAuthMiddleware(), statsRead, GetEnhancedStats)
+		api.GET("/stats/:code/export", OptionalAuthMiddleware(), statsRead, ExportStats)
+
+		protected := api.Group("")
+		protected.Use(AuthMiddleware())
+		{
+			protected.GET("/my-urls", linksRead, GetMyURLs)
+			protected.GET("/analytics/overview", statsRead, GetAnalyticsOverview)
+			protected.GET("/stats/:code/live", statsRead, StreamLiveStats)
+			protected.GET("/urls/:code", linksRead, GetURLDetails)
+			protected.PATCH("/urls/:code", linksWrite, UpdateURL)
+			protected.GET("/urls/:code/history", linksRead, GetURLHistory)
+			protected.GET("/urls/:code/rules", linksRead, GetURLRules)
+			protected.GET("/urls/:code/variants", linksRead, GetURLVariants)
+			protected.PUT("/urls/:code/variants", linksWrite, SetURLVariants)
+			protected.GET("/urls/:code/share-tokens", linksRead, GetShareTokens)
+			protected.POST("/urls/:code/share-tokens", linksWrite, CreateShareToken)
+			protected.DELETE("/urls/:code/share-tokens/:id", linksWrite, RevokeShareToken)
+			protected.GET("/account", linksRead, GetAccount)
+			protected.GET("/api-keys", RequireSession(), GetAPIKeys)
+			protected.POST("/api-keys", RequireSession(), CreateAPIKey)
+		}
+	}
+
+	r.GET("/internal/clicks", GetClickQueueStats)
+	r.GET("/:code", RedirectURL)
+	r.HEAD("/:code", RedirectURL)
+	r.POST("/:code/unlock", UnlockURL)
+
+	s.router = r
+	return s
+}
+
+// browserUA is sent with every request, so visits aren't counted as bots
+const browserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// do sends a request with an optional JSON body and bearer token
+func (s *testServer) do(method, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("User-Agent", browserUA)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// visitAs sends a request with the given User-Agent (none if empty) and
+// extra headers
+func (s *testServer) visitAs(method, path, userAgent string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// createURL stores a link, failing the test on error
+func (s *testServer) createURL(t *testing.T, url *models.URL) *models.URL {
+	t.Helper()
+	if url.StatsVisibility == "" {
+		url.StatsVisibility = models.StatsPublic
+	}
+	if err := s.stores.URLs.Create(context.Background(), url); err != nil {
+		t.Fatalf("Create(%s): %v", url.Code, err)
+	}
+	return url
+}
+
+// register creates a user and returns the login response
+func (s *testServer) register(t *testing.T, username string) models.LoginResponse {
+	t.Helper()
+	w := s.do("POST", "/api/auth/register", models.RegisterRequest{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "secret123",
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register %s: %d %s", username, w.Code, w.Body)
+	}
+	var resp models.LoginResponse
+	decode(t, w, &resp)
+	return resp
+}
+
+// decode unmarshals a JSON response body
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+}
+
+// errorMessage returns the error of a JSON error response
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp struct {
+		Error string `json:"error"`
+	}
+	decode(t, w, &resp)
+	return resp.Error
+}
+
+func TestRedirect(t *testing.T) {
+	s := newTestServer(t)
+	past := time.Now().Add(-time.Hour)
+	live := s.createURL(t, &models.URL{Code: "live", OriginalURL: "https://example.com/live"})
+	s.createURL(t, &models.URL{Code: "expired", OriginalURL: "https://example.com/expired", ExpiresAt: &past})
+
+	tests := []struct {
+		name     string
+		code     string
+		status   int
+		location string
+		errMsg   string
+	}{
+		{"hit", "live", http.StatusFound, "https://example.com/live", ""},
+		{"miss", "missing", http.StatusNotFound, "", "Short URL not found"},
+		{"expired", "expired", http.StatusGone, "", "This short URL has expired"},
+		{"reserved", "health", http.StatusNotFound, "", "Not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("GET", "/"+tt.code, nil, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+			if tt.errMsg != "" {
+				if got := errorMessage(t, w); got != tt.errMsg {
+					t.Errorf("error = %q, want %q", got, tt.errMsg)
+				}
+			}
+		})
+	}
+
+	// Only the hit is recorded, once the queue is drained
+	if err := s.clicks.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	count, err := s.stores.Clicks.CountClicks(context.Background(), live.ID, store.TrafficAll)
+	if err != nil || count != 1 {
+		t.Errorf("CountClicks = %d, %v, want 1", count, err)
+	}
+}
+
+func TestRedirectType(t *testing.T) {
+	s := newTestServer(t)
+	s.createURL(t, &models.URL{Code: "default", OriginalURL: "https://example.com"})
+	s.createURL(t, &models.URL{Code: "permanent", OriginalURL: "https://example.com", RedirectType: models.RedirectPermanent})
+	limit := 5
+	s.createURL(t, &models.URL{Code: "limited", OriginalURL: "https://example.com", RedirectType: models.RedirectPermanent, MaxClicks: &limit})
+
+	tests := []struct {
+		code   string
+		status int
+	}{
+		{"default", http.StatusFound},
+		{"permanent", http.StatusMovedPermanently},
+		{"limited", http.StatusFound}, // Permanent redirects would bypass the limit
+	}
+	for _, tt := range tests {
+		if w := s.do("GET", "/"+tt.code, nil, ""); w.Code != tt.status {
+			t.Errorf("GET /%s = %d, want %d", tt.code, w.Code, tt.status)
+		}
+	}
+}
+
+func TestClickLimit(t *testing.T) {
+	s := newTestServer(t)
+	once := 1
+	s.createURL(t, &models.URL{Code: "invite", OriginalURL: "https://example.com/invite", MaxClicks: &once})
+
+	// Link previews neither learn the destination nor use up the link
+	preview := s.visitAs("GET", "/invite", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", nil)
+	if preview.Code != http.StatusOK || preview.Header().Get("Location") != "" {
+		t.Errorf("preview = %d, Location %q, want 200 without a Location", preview.Code, preview.Header().Get("Location"))
+	}
+	if w := s.do("GET", "/invite", nil, ""); w.Code != http.StatusFound {
+		t.Errorf("first visit = %d, want 302", w.Code)
+	}
+	if w := s.do("GET", "/invite", nil, ""); w.Code != http.StatusGone {
+		t.Errorf("second visit = %d, want 410", w.Code)
+	}
+	if w := s.visitAs("GET", "/invite", "Slackbot-LinkExpanding 1.0", nil); w.Code != http.StatusGone {
+		t.Errorf("preview of a used link = %d, want 410", w.Code)
+	}
+}
+
+func TestClickLimitBotRequests(t *testing.T) {
+	s := newTestServer(t)
+	once := 1
+	s.createURL(t, &models.URL{Code: "once", OriginalURL: "https://example.com/secret", MaxClicks: &once})
+
+	// Anyone can make their request look automated, so none of these may
+	// see the destination of an unused link
+	tests := []struct {
+		name   string
+		method string
+		ua     string
+		header http.Header
+	}{
+		{"no user agent", "GET", "", nil},
+		{"HEAD", "HEAD", browserUA, nil},
+		{"prefetch", "GET", browserUA, http.Header{"Purpose": {"prefetch"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				w := s.visitAs(tt.method, "/once", tt.ua, tt.header)
+				if location := w.Header().Get("Location"); location != "" || w.Code != http.StatusOK {
+					t.Fatalf("visit = %d, Location %q, want 200 without a Location", w.Code, location)
+				}
+			}
+		})
+	}
+
+	// The link is still unused
+	if w := s.do("GET", "/once", nil, ""); w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/secret" {
+		t.Errorf("browser visit = %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestClickLimitConcurrent(t *testing.T) {
+	s := newTestServer(t)
+	limit := 5
+	s.createURL(t, &models.URL{Code: "rush", OriginalURL: "https://example.com", MaxClicks: &limit})
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- s.do("GET", "/rush", nil, "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	redirected := 0
+	for code := range codes {
+		if code == http.StatusFound {
+			redirected++
+		} else if code != http.StatusGone {
+			t.Errorf("visit = %d, want 302 or 410", code)
+		}
+	}
+	if redirected != limit {
+		t.Errorf("%d visits redirected, want %d", redirected, limit)
+	}
+}
+
+func TestBulkCreatePartialFailure(t *testing.T) {
+	s := newTestServer(t)
+	s.createURL(t, &models.URL{Code: "taken", OriginalURL: "https://example.com"})
+
+	w := s.do("POST", "/api/shorten/bulk", models.BulkCreateURLRequest{URLs: []models.CreateURLRequest{
+		{URL: "https://example.com/ok"},
+		{URL: "not a url"},
+		{URL: "https://example.com/custom", CustomCode: "custom"},
+		{URL: "https://example.com/taken", CustomCode: "taken"},
+	}}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201 (%s)", w.Code, w.Body)
+	}
+
+	var resp models.BulkCreateURLResponse
+	decode(t, w, &resp)
+	if resp.Count != 4 || len(resp.URLs) != 4 {
+		t.Fatalf("got %d results, want 4: %+v", resp.Count, resp.URLs)
+	}
+	for i, created := range []bool{true, false, true, false} {
+		if got := resp.URLs[i].Code != ""; got != created {
+			t.Errorf("URLs[%d] created = %v, want %v (%+v)", i, got, created, resp.URLs[i])
+		}
+	}
+	if resp.URLs[2].Code != "custom" {
+		t.Errorf("custom code = %q", resp.URLs[2].Code)
+	}
+
+	// The failed entries didn't touch the existing link
+	url, err := s.stores.URLs.GetByCode(context.Background(), "taken")
+	if err != nil || url.OriginalURL != "https://example.com" {
+		t.Errorf("taken link = %+v, %v", url, err)
+	}
+
+	for _, body := range []interface{}{models.BulkCreateURLRequest{}, "garbage"} {
+		if w := s.do("POST", "/api/shorten/bulk", body, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bulk %v = %d, want 400", body, w.Code)
+		}
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	s := newTestServer(t)
+
+	registered := s.register(t, "ann")
+	if registered.Token == "" || registered.RefreshToken == "" || registered.User.Username != "ann" {
+		t.Fatalf("register response = %+v", registered)
+	}
+
+	w := s.do("POST", "/api/auth/register", models.RegisterRequest{Username: "ann", Email: "other@example.com", Password: "secret123"}, "")
+	if w.Code != http.StatusConflict {
+		t.Errorf("duplicate register = %d, want 409", w.Code)
+	}
+	w = s.do("POST", "/api/auth/register", models.RegisterRequest{Username: "bob", Email: "bob@example.com", Password: "short"}, "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("register with a short password = %d, want 400", w.Code)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{"valid", "ann", "secret123", http.StatusOK},
+		{"wrong password", "ann", "wrong", http.StatusUnauthorized},
+		{"unknown user", "nobody", "secret123", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("POST", "/api/auth/login", models.LoginRequest{Username: tt.username, Password: tt.password}, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				if got := errorMessage(t, w); got != "Invalid credentials" {
+					t.Errorf("error = %q", got)
+				}
+				return
+			}
+
+			var resp models.LoginResponse
+			decode(t, w, &resp)
+			if w := s.do("GET", "/api/my-urls", nil, resp.Token); w.Code != http.StatusOK {
+				t.Errorf("my-urls with the login token = %d (%s)", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register(t, "ann").Token
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+		errMsg string
+	}{
+		{"valid", "Bearer " + token, http.StatusOK, ""},
+		{"missing", "", http.StatusUnauthorized, "Authorization header required"},
+		{"malformed", "Token " + token, http.StatusUnauthorized, "Invalid authorization header format"},
+		{"invalid", "Bearer not.a.jwt", http.StatusUnauthorized, "Invalid or expired token"},
+		{"tampered", "Bearer " + token + "x", http.StatusUnauthorized, "Invalid or expired token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/my-urls", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.status, w.Body)
+			}
+			if tt.errMsg != "" {
+				if got := errorMessage(t, w); got != tt.errMsg {
+					t.Errorf("error = %q, want %q", got, tt.errMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestRedirectWithoutInjectedServices(t *testing.T) {
+	s := newTestServer(t)
+	s.createURL(t, &models.URL{Code: "bare", OriginalURL: "https://example.com"})
+
+	// Without a click queue or classifier nothing is started per request
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("config", s.cfg)
+		c.Next()
+	})
+	r.Use(WithStores(s.stores))
+	r.GET("/:code", func(c *gin.Context) {
+		if getClickQueue(c) != nil || getBotClassifier(c) != defaultBotClassifier {
+			t.Error("services created for the request")
+		}
+		RedirectURL(c)
+	})
+
+	for _, ua := range []string{browserUA, "Slackbot-LinkExpanding 1.0"} {
+		req := httptest.NewRequest("GET", "/bare", nil)
+		req.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusFound {
+			t.Errorf("%s: redirect = %d", ua, w.Code)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"gourl/pkg/live"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StreamLiveStats handles GET /api/stats/{code}/live: a Server-Sent Events
+// stream of the link's clicks as they are recorded, one "click" event each,
+// for its owner. Idle streams get a comment every LiveHeartbeat seconds so
+// proxies keep them open.
+func StreamLiveStats(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "watch the live stats of")
+	if !ok {
+		return
+	}
+
+	hub := getLiveHub(c)
+	if hub == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Live stats are not available on this server"})
+		return
+	}
+
+	sub, err := hub.Subscribe(url.ID)
+	if err != nil {
+		if err == live.ErrTooManyClients {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many live stats clients, try again later"})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Keep nginx from buffering the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(time.Duration(getConfig(c).LiveHeartbeat) * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, open := <-sub.Events():
+			if !open {
+				return
+			}
+			c.SSEvent("click", event)
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				log.Printf("Error writing live stats heartbeat: %v", err)
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
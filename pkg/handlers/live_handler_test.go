@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gourl/pkg/live"
+	"gourl/pkg/models"
+)
+
+// liveServer serves the router on a real listener, since streams are read
+// while the handler still writes them. Streams opened later are closed
+// first, so closing the server doesn't wait for them.
+func liveServer(t *testing.T, s *testServer) *httptest.Server {
+	srv := httptest.NewServer(s.router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// openStream starts a live stats stream
+func openStream(t *testing.T, srv *httptest.Server, code, token string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("GET", srv.URL+"/api/stats/"+code+"/live", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET live: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// nextEvent reads the stream until the next event and returns its name and
+// data, or empty strings if the stream ends first
+func nextEvent(lines *bufio.Scanner) (name, data string) {
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "" && name != "":
+			return name, data
+		}
+	}
+	return "", ""
+}
+
+func TestStreamLiveStats(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	s.createURL(t, &models.URL{Code: "watched", OriginalURL: "https://example.com", UserID: &owner.User.ID})
+	srv := liveServer(t, s)
+
+	resp := openStream(t, srv, "watched", owner.Token)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream = %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	req := httptest.NewRequest("GET", "/watched", nil)
+	req.Header.Set("User-Agent", browserUA)
+	req.Header.Set("Referer", "https://news.example.com/")
+	s.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	events := make(chan [2]string, 1)
+	go func() {
+		name, data := nextEvent(bufio.NewScanner(resp.Body))
+		events <- [2]string{name, data}
+	}()
+	select {
+	case got := <-events:
+		var event live.Event
+		if err := json.Unmarshal([]byte(got[1]), &event); err != nil {
+			t.Fatalf("event data %q: %v", got[1], err)
+		}
+		if got[0] != "click" || event.Referrer != "https://news.example.com/" || event.Device != "desktop" || event.Time.IsZero() {
+			t.Errorf("event %s = %+v", got[0], event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no click event")
+	}
+}
+
+func TestStreamLiveStatsLimits(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	other := s.register(t, "bob")
+	s.createURL(t, &models.URL{Code: "watched", OriginalURL: "https://example.com", UserID: &owner.User.ID})
+	srv := liveServer(t, s)
+
+	if w := s.do("GET", "/api/stats/watched/live", nil, other.Token); w.Code != http.StatusForbidden {
+		t.Errorf("stream of another user's link = %d, want 403", w.Code)
+	}
+	if w := s.do("GET", "/api/stats/watched/live", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous stream = %d, want 401", w.Code)
+	}
+
+	first := openStream(t, srv, "watched", owner.Token)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("first stream = %d", first.StatusCode)
+	}
+	if w := s.do("GET", "/api/stats/watched/live", nil, owner.Token); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second stream of a link = %d, want 429", w.Code)
+	}
+
+	// Closing the hub on shutdown ends open streams and refuses new ones
+	s.live.Close()
+	ended := make(chan struct{})
+	go func() {
+		bufio.NewReader(first.Body).ReadString(0)
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after the hub closed")
+	}
+	if w := s.do("GET", "/api/stats/watched/live", nil, owner.Token); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("stream after shutdown = %d, want 503", w.Code)
+	}
+	if n := s.live.Clients(); n != 0 {
+		t.Errorf("clients = %d, want 0", n)
+	}
+}
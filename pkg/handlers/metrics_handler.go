@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetClickQueueStats handles GET /internal/clicks: the counters of the
+// click ingestion queue, for operators sending METRICS_TOKEN as a bearer
+// token. They reveal traffic levels, so without a token the route is off.
+func GetClickQueueStats(c *gin.Context) {
+	token := getConfig(c).MetricsToken
+	if token == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+
+	given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+		return
+	}
+
+	queue := getClickQueue(c)
+	if queue == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	c.JSON(http.StatusOK, queue.Stats())
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gourl/pkg/ingest"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClickQueueStatsToken(t *testing.T) {
+	s := newTestServer(t)
+
+	if w := s.do("GET", "/internal/clicks", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("without METRICS_TOKEN = %d, want 404", w.Code)
+	}
+
+	s.cfg.MetricsToken = "metrics-secret"
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "metrics-secre", http.StatusUnauthorized},
+		{"token", "metrics-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.do("GET", "/internal/clicks", nil, tt.token)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var stats ingest.Stats
+			decode(t, w, &stats)
+			if stats.Capacity == 0 {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestSyncClickQueue(t *testing.T) {
+	s := newTestServer(t)
+	url := s.createURL(t, &models.URL{Code: "sync", OriginalURL: "https://example.com"})
+
+	// As on serverless: the click must be stored by the time the redirect is sent
+	queue := ingest.New(s.stores.Clicks, nil, ingest.Options{Sync: true})
+	defer queue.Close(context.Background())
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("config", s.cfg)
+		c.Next()
+	})
+	r.Use(WithStores(s.stores), WithClickQueue(queue), WithBotClassifier(utils.NewBotClassifier(nil)))
+	r.GET("/:code", RedirectURL)
+
+	req := httptest.NewRequest("GET", "/sync", nil)
+	req.Header.Set("User-Agent", browserUA)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("redirect = %d", w.Code)
+	}
+	if count, err := s.stores.Clicks.CountClicks(context.Background(), url.ID, store.TrafficAll); err != nil || count != 1 {
+		t.Errorf("CountClicks right after the redirect = %d, %v, want 1", count, err)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// overviewDays is the length of the default overview period
+const overviewDays = 30
+
+// overviewTop is how many links, referrers and countries an overview lists
+const overviewTop = 10
+
+// GetAnalyticsOverview handles GET /api/analytics/overview: the clicks of
+// all links of the authenticated user over time, with their top links,
+// referrers and countries, each compared with the previous period of the
+// same length. The period is selected like for the enhanced stats and
+// defaults to the last 30 days.
+func GetAnalyticsOverview(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	id, ok := userID.(int)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	period, errMsg := parseStatsPeriod(c, time.Now().AddDate(0, 0, -overviewDays))
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	response, err := buildOverview(c.Request.Context(), getStores(c).Clicks, id, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// buildOverview runs the queries behind GetAnalyticsOverview. Every query
+// aggregates the rollups of all the user's links at once. Failures of the
+// series are returned (after logging them); failed top lists are logged and
+// left empty.
+func buildOverview(ctx context.Context, clicks store.ClickStore, userID int, period statsPeriod) (*models.OverviewResponse, error) {
+	prev := period.previous()
+	current := store.ClickFilter{From: period.from, To: period.to, Traffic: period.query.Traffic}
+	previous := store.ClickFilter{From: prev.from, To: prev.to, Traffic: period.query.Traffic}
+
+	// Get the zero-filled series of the period and the clicks of both periods
+	hourly, err := clicks.UserHourlyClicks(ctx, userID, store.ClickFilter{From: prev.from, To: period.to, Traffic: current.Traffic})
+	if err != nil {
+		log.Printf("Error querying account clicks by hour: %v", err)
+		return nil, err
+	}
+	series, total := period.series(hourly)
+	_, previousTotal := prev.series(hourly)
+
+	// Get top links
+	topLinks := []models.LinkStat{}
+	links, err := clicks.UserTopLinks(ctx, userID, current, overviewTop)
+	if err == nil && len(links) > 0 {
+		var before []store.LinkCount
+		if before, err = clicks.UserTopLinks(ctx, userID, previous, 0); err == nil {
+			previousByLink := make(map[int]int, len(before))
+			for _, link := range before {
+				previousByLink[link.URLID] = link.Clicks
+			}
+			for _, link := range links {
+				topLinks = append(topLinks, models.LinkStat{
+					Code:        link.Code,
+					OriginalURL: link.OriginalURL,
+					Clicks:      periodDelta(link.Clicks, previousByLink[link.URLID]),
+				})
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Error querying account top links: %v", err)
+	}
+
+	return &models.OverviewResponse{
+		Traffic:       string(current.Traffic),
+		Range:         period.statsRange(total),
+		PreviousRange: prev.statsRange(previousTotal),
+		Clicks:        periodDelta(total, previousTotal),
+		Series:        series,
+		TopLinks:      topLinks,
+		TopReferrers:  topValueStats(ctx, clicks, userID, store.DimReferrer, current, previous),
+		TopCountries:  topValueStats(ctx, clicks, userID, store.DimCountry, current, previous),
+	}, nil
+}
+
+// topValueStats returns the most frequent values of a dimension across a
+// user's links in the current period, with their clicks in the previous one
+func topValueStats(ctx context.Context, clicks store.ClickStore, userID int, dim store.Dimension, current, previous store.ClickFilter) []models.ValueStat {
+	stats := []models.ValueStat{}
+	values, err := clicks.UserTopValues(ctx, userID, dim, current, overviewTop)
+	if err == nil && len(values) > 0 {
+		var before []store.ValueCount
+		if before, err = clicks.UserTopValues(ctx, userID, dim, previous, 0); err == nil {
+			previousByValue := make(map[string]int, len(before))
+			for _, v := range before {
+				previousByValue[v.Value] = v.Count
+			}
+			for _, v := range values {
+				stats = append(stats, models.ValueStat{Value: v.Value, Clicks: periodDelta(v.Count, previousByValue[v.Value])})
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Error querying account %s breakdown: %v", dim, err)
+	}
+	return stats
+}
+
+// periodDelta compares the clicks of a period with those of the previous one
+func periodDelta(current, previous int) models.PeriodDelta {
+	delta := models.PeriodDelta{Current: current, Previous: previous, Change: current - previous}
+	if previous > 0 {
+		percent := float64(current-previous) * 100 / float64(previous)
+		delta.ChangePercent = &percent
+	}
+	return delta
+}
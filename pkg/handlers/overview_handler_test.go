@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+// seedOverview gives ann two links and bob one, with clicks in the week of
+// Jan 8-14, 2025 and the week before it
+func seedOverview(t *testing.T, s *testServer) (ann, bob models.LoginResponse) {
+	t.Helper()
+	ann = s.register(t, "ann")
+	bob = s.register(t, "bob")
+	first := s.createURL(t, &models.URL{Code: "first", OriginalURL: "https://example.com/1", UserID: &ann.User.ID})
+	second := s.createURL(t, &models.URL{Code: "second", OriginalURL: "https://example.com/2", UserID: &ann.User.ID})
+	other := s.createURL(t, &models.URL{Code: "other", OriginalURL: "https://example.com/3", UserID: &bob.User.ID})
+
+	click := func(urlID, day int, referrer, country string, isBot bool) models.Click {
+		return models.Click{
+			URLID:     urlID,
+			Referrer:  referrer,
+			Country:   country,
+			IsBot:     isBot,
+			ClickedAt: time.Date(2025, 1, day, 12, 0, 0, 0, time.UTC),
+		}
+	}
+	clicks := []models.Click{
+		// Current week
+		click(first.ID, 10, "https://news.example.com/", "DE", false),
+		click(first.ID, 10, "https://news.example.com/", "DE", false),
+		click(first.ID, 11, "https://news.example.com/", "FR", false),
+		click(first.ID, 11, "", "", true),
+		click(second.ID, 12, "https://mail.example.com/", "FR", false),
+		// Previous week
+		click(first.ID, 3, "https://news.example.com/", "DE", false),
+		click(first.ID, 4, "https://news.example.com/", "DE", false),
+	}
+	for i := 0; i < 5; i++ {
+		clicks = append(clicks, click(other.ID, 10, "https://bob.example.com/", "US", false))
+	}
+	if err := s.stores.Clicks.RecordBatch(context.Background(), clicks); err != nil {
+		t.Fatalf("RecordBatch: %v", err)
+	}
+	return ann, bob
+}
+
+// formatDelta shows a delta with its change percent, if any
+func formatDelta(d models.PeriodDelta) string {
+	if d.ChangePercent == nil {
+		return fmt.Sprintf("%d/%d/%+d/nil", d.Current, d.Previous, d.Change)
+	}
+	return fmt.Sprintf("%d/%d/%+d/%.0f%%", d.Current, d.Previous, d.Change, *d.ChangePercent)
+}
+
+func TestBuildOverview(t *testing.T) {
+	s := newTestServer(t)
+	ann, _ := seedOverview(t, s)
+
+	tests := []struct {
+		traffic   string
+		clicks    string
+		links     []string
+		referrers []string
+		countries []string
+	}{
+		{
+			traffic:   "human",
+			clicks:    "4/2/+2/100%",
+			links:     []string{"first 3/2/+1/50%", "second 1/0/+1/nil"},
+			referrers: []string{"https://news.example.com/ 3/2/+1/50%", "https://mail.example.com/ 1/0/+1/nil"},
+			countries: []string{"DE 2/2/+0/0%", "FR 2/0/+2/nil"},
+		},
+		{
+			traffic: "bot",
+			clicks:  "1/0/+1/nil",
+			links:   []string{"first 1/0/+1/nil"},
+		},
+		{
+			traffic: "all",
+			clicks:  "5/2/+3/150%",
+			links:   []string{"first 4/2/+2/100%", "second 1/0/+1/nil"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.traffic, func(t *testing.T) {
+			period, errMsg := periodFor(t, "from=2025-01-08&to=2025-01-14&traffic="+tt.traffic, time.Time{})
+			if errMsg != "" {
+				t.Fatal(errMsg)
+			}
+			overview, err := buildOverview(context.Background(), s.stores.Clicks, ann.User.ID, period)
+			if err != nil {
+				t.Fatalf("buildOverview: %v", err)
+			}
+
+			if overview.Traffic != tt.traffic {
+				t.Errorf("Traffic = %q", overview.Traffic)
+			}
+			if got := formatDelta(overview.Clicks); got != tt.clicks {
+				t.Errorf("Clicks = %s, want %s", got, tt.clicks)
+			}
+			if !overview.PreviousRange.From.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) || !overview.PreviousRange.To.Equal(overview.Range.From) {
+				t.Errorf("PreviousRange = %v - %v", overview.PreviousRange.From, overview.PreviousRange.To)
+			}
+			if len(overview.Series) != 7 {
+				t.Errorf("Series has %d points, want 7", len(overview.Series))
+			}
+			sum := 0
+			for _, point := range overview.Series {
+				sum += point.Clicks
+			}
+			if sum != overview.Clicks.Current {
+				t.Errorf("Series sums to %d, want %d", sum, overview.Clicks.Current)
+			}
+
+			var links []string
+			for _, link := range overview.TopLinks {
+				links = append(links, link.Code+" "+formatDelta(link.Clicks))
+			}
+			if fmt.Sprint(links) != fmt.Sprint(tt.links) {
+				t.Errorf("TopLinks = %q, want %q", links, tt.links)
+			}
+			if tt.referrers != nil {
+				if got := valueStats(overview.TopReferrers); fmt.Sprint(got) != fmt.Sprint(tt.referrers) {
+					t.Errorf("TopReferrers = %q, want %q", got, tt.referrers)
+				}
+			}
+			if tt.countries != nil {
+				if got := valueStats(overview.TopCountries); fmt.Sprint(got) != fmt.Sprint(tt.countries) {
+					t.Errorf("TopCountries = %q, want %q", got, tt.countries)
+				}
+			}
+		})
+	}
+}
+
+func valueStats(stats []models.ValueStat) []string {
+	var out []string
+	for _, v := range stats {
+		out = append(out, v.Value+" "+formatDelta(v.Clicks))
+	}
+	return out
+}
+
+func TestPeriodDelta(t *testing.T) {
+	tests := []struct {
+		current, previous int
+		want              string
+	}{
+		{0, 0, "0/0/+0/nil"},
+		{3, 0, "3/0/+3/nil"},
+		{3, 2, "3/2/+1/50%"},
+		{1, 4, "1/4/-3/-75%"},
+		{0, 5, "0/5/-5/-100%"},
+	}
+	for _, tt := range tests {
+		if got := formatDelta(periodDelta(tt.current, tt.previous)); got != tt.want {
+			t.Errorf("periodDelta(%d, %d) = %s, want %s", tt.current, tt.previous, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyticsOverviewOwnLinks(t *testing.T) {
+	s := newTestServer(t)
+	ann, bob := seedOverview(t, s)
+
+	if w := s.do("GET", "/api/analytics/overview", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous overview = %d, want 401", w.Code)
+	}
+	if w := s.do("GET", "/api/analytics/overview?traffic=robots", nil, ann.Token); w.Code != http.StatusBadRequest {
+		t.Errorf("bad traffic = %d, want 400", w.Code)
+	}
+
+	for _, tt := range []struct {
+		user  models.LoginResponse
+		total int
+		link  string
+	}{
+		{ann, 4, "first"},
+		{bob, 5, "other"},
+	} {
+		w := s.do("GET", "/api/analytics/overview?from=2025-01-08&to=2025-01-14", nil, tt.user.Token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("overview of %s = %d %s", tt.user.User.Username, w.Code, w.Body)
+		}
+		var overview models.OverviewResponse
+		decode(t, w, &overview)
+		if overview.Clicks.Current != tt.total || len(overview.TopLinks) == 0 || overview.TopLinks[0].Code != tt.link {
+			t.Errorf("overview of %s = %+v", tt.user.User.Username, overview)
+		}
+		for _, link := range overview.TopLinks {
+			if (link.Code == "other") != (tt.user.User.ID == bob.User.ID) {
+				t.Errorf("overview of %s lists %s", tt.user.User.Username, link.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Minimal standalone HTML pages served on the redirect path. They are kept
+// in Go (rather than web/static) so they also work in serverless deployments.
+
+const pageStyle = `
+	body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f5f7fb; color: #1f2937; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+	.card { background: #fff; border-radius: 12px; box-shadow: 0 10px 30px rgba(0,0,0,0.08); padding: 32px; max-width: 380px; width: 100%; }
+	h1 { font-size: 1.3em; margin: 0 0 8px; }
+	p { color: #6b7280; margin: 0 0 20px; }
+	input { width: 100%; box-sizing: border-box; padding: 10px 12px; border: 1px solid #d1d5db; border-radius: 8px; font-size: 1em; margin-bottom: 12px; }
+	button { width: 100%; padding: 10px; border: 0; border-radius: 8px; background: #4f46e5; color: #fff; font-size: 1em; cursor: pointer; }
+	.error { color: #b91c1c; margin-bottom: 12px; }
+`
+
+var unlockPage = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<form class="card" method="POST" action="/{{.Code}}/unlock">
+	<h1>🔒 Password required</h1>
+	<p>This link is protected. Enter the password to continue.</p>
+	{{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+	<input type="password" name="password" placeholder="Password" autofocus required>
+	<button type="submit">Unlock</button>
+</form>
+</body>
+</html>
+`))
+
+var unavailablePage = template.Must(template.New("unavailable").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<div class="card">
+	<h1>{{.Title}}</h1>
+	<p>{{.Message}}</p>
+	<a href="/">Create your own short link</a>
+</div>
+</body>
+</html>
+`))
+
+// limitedPage answers crawlers, link previews and prefetches of
+// click-limited links, which mustn't learn the destination without using
+// up a click
+var limitedPage = template.Must(template.New("limited").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Limited link</title>
+<style>` + pageStyle + `</style>
+</head>
+<body>
+<div class="card">
+	<h1>Limited link</h1>
+	<p>This link can only be opened a limited number of times. Open it in a browser to continue.</p>
+</div>
+</body>
+</html>
+`))
+
+// forwardPage sends browsers on without an HTTP redirect, for destinations
+// that need a real page load (e.g. app deep links) and for tracking pixels
+var forwardPage = template.Must(template.New("forward").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.URL}}">
+<title>Redirecting…</title>
+<style>` + pageStyle + `</style>
+<script>window.location.replace({{.URL}});</script>
+</head>
+<body>
+<div class="card">
+	<h1>Redirecting…</h1>
+	<p>If nothing happens, <a href="{{.URL}}">continue to the destination</a>.</p>
+</div>
+</body>
+</html>
+`))
+
+// renderPage executes an HTML page template and writes it with the given status
+func renderPage(c *gin.Context, status int, page *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, data); err != nil {
+		log.Printf("Error rendering %s page: %v", page.Name(), err)
+		c.String(status, "")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
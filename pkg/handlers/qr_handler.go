@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"net/http"
 
-	"gourl/pkg/database"
-
 	"github.com/gin-gonic/gin"
 	"github.com/skip2/go-qrcode"
 )
@@ -19,12 +17,7 @@ func GenerateQRCode(c *gin.Context) {
 	}
 
 	// Get URL to verify it exists
-	var originalURL string
-	err := database.DB.QueryRow(
-		"SELECT original_url FROM urls WHERE code = ?",
-		code,
-	).Scan(&originalURL)
-
+	_, err := getStores(c).URLs.GetByCode(c.Request.Context(), code)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 		return
@@ -71,4 +64,3 @@ func GenerateQRCode(c *gin.Context) {
 	c.Header("Cache-Control", "public, max-age=3600")
 	c.Data(http.StatusOK, "image/png", png)
 }
-
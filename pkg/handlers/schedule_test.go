@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+)
+
+func TestScheduledActivation(t *testing.T) {
+	s := newTestServer(t)
+	starts := time.Now().Add(500 * time.Millisecond)
+	url := s.createURL(t, &models.URL{Code: "launch", OriginalURL: "https://example.com/live", StartsAt: &starts})
+
+	w := s.do("GET", "/launch", nil, "")
+	if w.Code != http.StatusForbidden || w.Header().Get("Location") != "" {
+		t.Fatalf("before start = %d to %q, want 403", w.Code, w.Header().Get("Location"))
+	}
+	if got := errorMessage(t, w); got != "This short URL is not active yet" {
+		t.Errorf("error = %q", got)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("Cache-Control = %q, Retry-After = %q", w.Header().Get("Cache-Control"), w.Header().Get("Retry-After"))
+	}
+
+	// The cached link starts working on time
+	time.Sleep(time.Until(starts))
+	w = s.do("GET", "/launch", nil, "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/live" {
+		t.Fatalf("after start = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// Only the visit after the start is counted
+	if err := s.clicks.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if count, err := s.stores.Clicks.CountClicks(context.Background(), url.ID, store.TrafficAll); err != nil || count != 1 {
+		t.Errorf("CountClicks = %d, %v, want 1", count, err)
+	}
+}
+
+func TestScheduledPrelaunchAndStatus(t *testing.T) {
+	s := newTestServer(t)
+	starts := time.Now().Add(2 * time.Hour)
+	s.createURL(t, &models.URL{Code: "teaser", OriginalURL: "https://example.com/live", StartsAt: &starts, PrelaunchURL: "https://example.com/soon"})
+	s.createURL(t, &models.URL{Code: "hidden", OriginalURL: "https://example.com/live", StartsAt: &starts})
+
+	w := s.do("GET", "/teaser", nil, "")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/soon" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("pre-launch = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	s.cfg.NotActiveStatus = http.StatusNotFound
+	w = s.do("GET", "/hidden", nil, "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("with NOT_ACTIVE_STATUS=404 = %d", w.Code)
+	}
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 7190 || retry > 7200 {
+		t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		starts  *time.Time
+		expires *time.Time
+		errMsg  string
+	}{
+		{"start only", &later, nil, ""},
+		{"expiry only", nil, &later, ""},
+		{"start before expiry", &now, &later, ""},
+		{"start at expiry", &later, &later, "starts_at must be before expires_at"},
+		{"start after expiry", &later, &now, "starts_at must be before expires_at"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := &models.URL{OriginalURL: "https://example.com", StartsAt: tt.starts, ExpiresAt: tt.expires}
+			if got := validateLinkOptions(url); got != tt.errMsg {
+				t.Errorf("validateLinkOptions = %q, want %q", got, tt.errMsg)
+			}
+		})
+	}
+
+	s := newTestServer(t)
+	w := s.do("POST", "/api/shorten", map[string]interface{}{
+		"url":        "https://example.com",
+		"starts_at":  later,
+		"expires_at": now,
+	}, "")
+	if w.Code != http.StatusBadRequest || errorMessage(t, w) != "starts_at must be before expires_at" {
+		t.Errorf("shorten = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"gourl/pkg/auth"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shareTokenKind starts every stats share token
+const shareTokenKind = "gst_"
+
+// shareTokenHeader carries a share token; the token query parameter works too
+const shareTokenHeader = "X-Stats-Token"
+
+// shareTokenPrefixLen is how much of a share token is kept to tell it apart
+const shareTokenPrefixLen = len(shareTokenKind) + 6
+
+// defaultStatsVisibility is owner-only for links with an owner; anonymous
+// links have nobody who could see owner-only stats
+func defaultStatsVisibility(url *models.URL) string {
+	if url.UserID != nil {
+		return models.StatsOwner
+	}
+	return models.StatsPublic
+}
+
+// canViewStats checks that the request may read the stats of url: anyone
+// for public links, the owner, and holders of a share token (the token
+// query parameter or X-Stats-Token header) for token links. On failure it
+// writes the error response and returns false.
+func canViewStats(c *gin.Context, url *models.URL) bool {
+	visibility := url.StatsVisibility
+	if visibility == "" {
+		visibility = defaultStatsVisibility(url)
+	}
+	if visibility == models.StatsPublic {
+		return true
+	}
+
+	userID := getOptionalUserID(c)
+	if userID != nil && url.UserID != nil && *userID == *url.UserID {
+		return true
+	}
+
+	token := c.Query("token")
+	if token == "" {
+		token = c.GetHeader(shareTokenHeader)
+	}
+	if visibility == models.StatsToken && token != "" {
+		ok, err := getStores(c).URLs.HasShareToken(c.Request.Context(), url.ID, auth.HashOpaqueToken(token))
+		if err != nil {
+			log.Printf("Error checking share token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return false
+		}
+		if ok {
+			return true
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or revoked share token"})
+		return false
+	}
+
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to view these stats"})
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to view the stats of this URL"})
+	return false
+}
+
+// GetShareTokens lists the stats share tokens of a URL (if user owns it)
+func GetShareTokens(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "view")
+	if !ok {
+		return
+	}
+
+	tokens, err := getStores(c).URLs.ShareTokens(c.Request.Context(), url.ID)
+	if err != nil {
+		log.Printf("Error querying share tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens":           tokens,
+		"count":            len(tokens),
+		"stats_visibility": url.StatsVisibility,
+	})
+}
+
+// CreateShareToken issues a read-only token for the stats of a URL with
+// token visibility (if user owns it). The token is only shown once.
+func CreateShareToken(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "share")
+	if !ok {
+		return
+	}
+
+	var req models.CreateShareTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be at most 100 characters"})
+		return
+	}
+
+	// Tokens only grant access while the visibility is token; refusing them
+	// otherwise avoids handing out links that don't work
+	if url.StatsVisibility != models.StatsToken {
+		c.JSON(http.StatusConflict, gin.H{"error": "Set stats_visibility to token before creating share tokens"})
+		return
+	}
+
+	raw, hash, err := auth.GenerateOpaqueToken(shareTokenKind)
+	if err != nil {
+		log.Printf("Error generating share token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share token"})
+		return
+	}
+	token := &models.ShareToken{
+		URLID:     url.ID,
+		Name:      req.Name,
+		Prefix:    raw[:shareTokenPrefixLen],
+		TokenHash: hash,
+	}
+	if err := getStores(c).URLs.CreateShareToken(c.Request.Context(), token); err != nil {
+		log.Printf("Error creating share token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share token"})
+		return
+	}
+	token.Token = raw
+
+	// The token goes in a header rather than the URL, where it would end up
+	// in browser history, proxy logs and Referer headers
+	c.JSON(http.StatusCreated, gin.H{
+		"share_token":  token,
+		"stats_url":    getBaseURL(c) + "/api/stats/" + url.Code + "/enhanced",
+		"stats_header": shareTokenHeader,
+	})
+}
+
+// RevokeShareToken deletes a stats share token of a URL (if user owns it)
+func RevokeShareToken(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "share")
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share token ID"})
+		return
+	}
+
+	if err := getStores(c).URLs.DeleteShareToken(c.Request.Context(), url.ID, id); err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share token not found"})
+			return
+		}
+		log.Printf("Error revoking share token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share token revoked"})
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gourl/pkg/models"
+)
+
+// statsAs reads the enhanced stats of a link with an optional bearer token
+// and share token header
+func (s *testServer) statsAs(path, bearer, shareToken string) int {
+	header := http.Header{}
+	if bearer != "" {
+		header.Set("Authorization", "Bearer "+bearer)
+	}
+	if shareToken != "" {
+		header.Set("X-Stats-Token", shareToken)
+	}
+	return s.visitAs("GET", path, browserUA, header).Code
+}
+
+func TestStatsVisibilityDefaults(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	other := s.register(t, "bob")
+
+	w := s.do("POST", "/api/shorten", map[string]interface{}{"url": "https://example.com", "custom_code": "owned"}, owner.Token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("shorten = %d %s", w.Code, w.Body)
+	}
+	w = s.do("POST", "/api/shorten", map[string]interface{}{"url": "https://example.com", "custom_code": "anon"}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("anonymous shorten = %d %s", w.Code, w.Body)
+	}
+	w = s.do("POST", "/api/shorten", map[string]interface{}{"url": "https://example.com", "custom_code": "hidden", "stats_visibility": "owner"}, "")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("anonymous owner-only link = %d, want 400", w.Code)
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		bearer string
+		status int
+	}{
+		{"owner link anonymous", "owned", "", http.StatusUnauthorized},
+		{"owner link other user", "owned", other.Token, http.StatusForbidden},
+		{"owner link owner", "owned", owner.Token, http.StatusOK},
+		{"anonymous link anonymous", "anon", "", http.StatusOK},
+		{"anonymous link user", "anon", other.Token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, path := range []string{"/api/stats/" + tt.code, "/api/stats/" + tt.code + "/enhanced"} {
+				if got := s.statsAs(path, tt.bearer, ""); got != tt.status {
+					t.Errorf("GET %s = %d, want %d", path, got, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestShareTokens(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	other := s.register(t, "bob")
+	s.createURL(t, &models.URL{Code: "shared", OriginalURL: "https://example.com", UserID: &owner.User.ID, StatsVisibility: models.StatsOwner})
+
+	// Tokens wouldn't grant anything until the visibility is token
+	w := s.do("POST", "/api/urls/shared/share-tokens", map[string]interface{}{"name": "team"}, owner.Token)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("create with owner visibility = %d, want 409 (%s)", w.Code, w.Body)
+	}
+	s.patchURL(t, "shared", owner.Token, map[string]interface{}{"stats_visibility": "token"})
+
+	if w := s.do("POST", "/api/urls/shared/share-tokens", nil, other.Token); w.Code != http.StatusForbidden {
+		t.Errorf("create by another user = %d, want 403", w.Code)
+	}
+	w = s.do("POST", "/api/urls/shared/share-tokens", map[string]interface{}{"name": "team"}, owner.Token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	var created struct {
+		ShareToken  models.ShareToken `json:"share_token"`
+		StatsURL    string            `json:"stats_url"`
+		StatsHeader string            `json:"stats_header"`
+	}
+	decode(t, w, &created)
+	raw := created.ShareToken.Token
+	if !strings.HasPrefix(raw, shareTokenKind) || created.ShareToken.Prefix != raw[:shareTokenPrefixLen] {
+		t.Fatalf("share token = %+v", created.ShareToken)
+	}
+	if strings.Contains(created.StatsURL, raw) || !strings.HasSuffix(created.StatsURL, "/api/stats/shared/enhanced") || created.StatsHeader != "X-Stats-Token" {
+		t.Errorf("stats_url = %q, stats_header = %q", created.StatsURL, created.StatsHeader)
+	}
+
+	// Listing never shows the token again
+	w = s.do("GET", "/api/urls/shared/share-tokens", nil, owner.Token)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), raw) {
+		t.Errorf("list = %d %s", w.Code, w.Body)
+	}
+
+	enhanced := "/api/stats/shared/enhanced"
+	access := []struct {
+		name   string
+		path   string
+		header string
+		status int
+	}{
+		{"no token", enhanced, "", http.StatusUnauthorized},
+		{"query", enhanced + "?token=" + raw, "", http.StatusOK},
+		{"header", enhanced, raw, http.StatusOK},
+		{"basic stats", "/api/stats/shared", raw, http.StatusOK},
+		{"wrong token", enhanced, raw + "x", http.StatusForbidden},
+	}
+	for _, tt := range access {
+		if got := s.statsAs(tt.path, "", tt.header); got != tt.status {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.status)
+		}
+	}
+
+	id := strconv.Itoa(created.ShareToken.ID)
+	if w := s.do("DELETE", "/api/urls/shared/share-tokens/"+id, nil, other.Token); w.Code != http.StatusForbidden {
+		t.Errorf("revoke by another user = %d, want 403", w.Code)
+	}
+	if w := s.do("DELETE", "/api/urls/shared/share-tokens/"+id, nil, owner.Token); w.Code != http.StatusOK {
+		t.Fatalf("revoke = %d %s", w.Code, w.Body)
+	}
+	if w := s.do("DELETE", "/api/urls/shared/share-tokens/"+id, nil, owner.Token); w.Code != http.StatusNotFound {
+		t.Errorf("revoke again = %d, want 404", w.Code)
+	}
+	if got := s.statsAs(enhanced, "", raw); got != http.StatusForbidden {
+		t.Errorf("revoked token in header = %d, want 403", got)
+	}
+	if got := s.statsAs(enhanced+"?token="+raw, "", ""); got != http.StatusForbidden {
+		t.Errorf("revoked token in query = %d, want 403", got)
+	}
+	if got := s.statsAs(enhanced, owner.Token, ""); got != http.StatusOK {
+		t.Errorf("owner after revoking = %d, want 200", got)
+	}
+}
+
+func TestShareTokenOnlyForTokenVisibility(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	s.createURL(t, &models.URL{Code: "shared", OriginalURL: "https://example.com", UserID: &owner.User.ID, StatsVisibility: models.StatsToken})
+
+	w := s.do("POST", "/api/urls/shared/share-tokens", nil, owner.Token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	var created struct {
+		ShareToken models.ShareToken `json:"share_token"`
+	}
+	decode(t, w, &created)
+
+	// Going back to owner-only makes existing tokens useless
+	s.patchURL(t, "shared", owner.Token, map[string]interface{}{"stats_visibility": "owner"})
+	if got := s.statsAs("/api/stats/shared/enhanced", "", created.ShareToken.Token); got != http.StatusUnauthorized {
+		t.Errorf("token with owner visibility = %d, want 401", got)
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // tz names must resolve even where the OS has no zoneinfo
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSeriesPoints bounds the length of a stats time series
+const maxSeriesPoints = 2000
+
+// statsPeriod is the time range and bucketing requested for stats
+type statsPeriod struct {
+	from, to    time.Time // Bounds of the series, hour-aligned
+	granularity string
+	loc         *time.Location
+
+	// query is the filter passed to the store: bounds the caller didn't set
+	// are left open so all-time breakdowns can use the daily rollups
+	query store.ClickFilter
+}
+
+// parseStatsPeriod reads the from, to, granularity, tz and traffic query
+// parameters. from and to accept RFC 3339 timestamps or dates (a date as to
+// includes that whole day); without them the period runs from since until
+// now. Bounds are widened to whole hours, the precision of the rollups.
+func parseStatsPeriod(c *gin.Context, since time.Time) (statsPeriod, string) {
+	p := statsPeriod{granularity: models.GranularityDay, loc: time.UTC}
+
+	traffic, errMsg := parseTraffic(c)
+	if errMsg != "" {
+		return p, errMsg
+	}
+	p.query.Traffic = traffic
+
+	if tz := c.Query("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return p, fmt.Sprintf("Unknown time zone %q", tz)
+		}
+		p.loc = loc
+	}
+
+	if g := strings.ToLower(c.Query("granularity")); g != "" {
+		switch g {
+		case models.GranularityHour, models.GranularityDay, models.GranularityWeek, models.GranularityMonth:
+			p.granularity = g
+		default:
+			return p, "Granularity must be hour, day, week or month"
+		}
+	}
+
+	p.from, p.to = since, time.Now()
+	if value := c.Query("from"); value != "" {
+		from, _, err := parseStatsTime(value, p.loc)
+		if err != nil {
+			return p, "Invalid from (use RFC 3339 or YYYY-MM-DD)"
+		}
+		p.from = from
+		p.query.From = from.Truncate(time.Hour)
+	}
+	if value := c.Query("to"); value != "" {
+		to, dateOnly, err := parseStatsTime(value, p.loc)
+		if err != nil {
+			return p, "Invalid to (use RFC 3339 or YYYY-MM-DD)"
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		p.to = to
+		p.query.To = ceilHour(to)
+	}
+	p.from, p.to = p.from.Truncate(time.Hour), ceilHour(p.to)
+	if !p.from.Before(p.to) {
+		return p, "Invalid range: from must be before to"
+	}
+
+	points := 0
+	for start := p.bucketStart(p.from); start.Before(p.to); start = p.nextBucket(start) {
+		if points++; points > maxSeriesPoints {
+			return p, fmt.Sprintf("Range too long for %s granularity (at most %d points)", p.granularity, maxSeriesPoints)
+		}
+		// Clicks are counted per UTC hour, so a day starting at half past
+		// (e.g. in Asia/Kolkata) would get some of its neighbour's clicks
+		if start.Unix()%3600 != 0 {
+			return p, fmt.Sprintf("Time zone %s is not a whole number of hours from UTC, use granularity=hour", p.loc)
+		}
+	}
+	return p, ""
+}
+
+// parseStatsTime parses a timestamp or a date in loc
+func parseStatsTime(value string, loc *time.Location) (t time.Time, dateOnly bool, err error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, false, nil
+		}
+	}
+	t, err = time.ParseInLocation("2006-01-02", value, loc)
+	return t, true, err
+}
+
+// ceilHour rounds t up to a whole hour
+func ceilHour(t time.Time) time.Time {
+	if truncated := t.Truncate(time.Hour); !truncated.Equal(t) {
+		return truncated.Add(time.Hour)
+	}
+	return t
+}
+
+// bucketStart returns the start of the series bucket containing t
+func (p statsPeriod) bucketStart(t time.Time) time.Time {
+	t = t.In(p.loc)
+	switch p.granularity {
+	case models.GranularityHour:
+		return t.Truncate(time.Hour)
+	case models.GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.loc)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case models.GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, p.loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, p.loc)
+}
+
+// nextBucket returns the start of the bucket following the one at start
+func (p statsPeriod) nextBucket(start time.Time) time.Time {
+	switch p.granularity {
+	case models.GranularityHour:
+		return start.Add(time.Hour)
+	case models.GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case models.GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// series sums hourly click counts into the period's buckets, including
+// empty ones, oldest first. It also returns the clicks in the whole period.
+func (p statsPeriod) series(hourly []store.BucketCount) ([]models.SeriesPoint, int) {
+	counts := make(map[int64]int)
+	total := 0
+	for _, h := range hourly {
+		if h.Start.Before(p.from) || !h.Start.Before(p.to) {
+			continue
+		}
+		counts[p.bucketStart(h.Start).Unix()] += h.Clicks
+		total += h.Clicks
+	}
+
+	series := []models.SeriesPoint{}
+	for start := p.bucketStart(p.from); start.Before(p.to); start = p.nextBucket(start) {
+		series = append(series, models.SeriesPoint{Start: start, Clicks: counts[start.Unix()]})
+	}
+	return series, total
+}
+
+// previous returns the period of the same length ending where p starts
+func (p statsPeriod) previous() statsPeriod {
+	length := p.to.Sub(p.from)
+	prev := p
+	prev.from, prev.to = p.from.Add(-length), p.from
+	prev.query.From, prev.query.To = prev.from, prev.to
+	return prev
+}
+
+// statsRange describes the period in a stats response
+func (p statsPeriod) statsRange(clicks int) models.StatsRange {
+	return models.StatsRange{
+		From:        p.from.In(p.loc),
+		To:          p.to.In(p.loc),
+		Granularity: p.granularity,
+		Timezone:    p.loc.String(),
+		Clicks:      clicks,
+	}
+}
+
+// parseTraffic reads the traffic query parameter: human (the default), bot or all
+func parseTraffic(c *gin.Context) (store.Traffic, string) {
+	value := strings.ToLower(c.Query("traffic"))
+	if value == "" {
+		return store.TrafficHuman, ""
+	}
+	traffic, ok := store.ParseTraffic(value)
+	if !ok {
+		return traffic, "Traffic must be human, bot or all"
+	}
+	return traffic, ""
+}
+
+// trafficCounts holds the clicks of a URL split by bot classification
+type trafficCounts struct {
+	human, bots int
+}
+
+// countTraffic returns the human and bot clicks of a URL
+func countTraffic(ctx context.Context, clicks store.ClickStore, urlID int) (trafficCounts, error) {
+	var counts trafficCounts
+	var err error
+	if counts.human, err = clicks.CountClicks(ctx, urlID, store.TrafficHuman); err != nil {
+		return counts, err
+	}
+	counts.bots, err = clicks.CountClicks(ctx, urlID, store.TrafficBot)
+	return counts, err
+}
+
+// of returns the clicks of the selected traffic
+func (t trafficCounts) of(traffic store.Traffic) int {
+	switch traffic {
+	case store.TrafficHuman:
+		return t.human
+	case store.TrafficBot:
+		return t.bots
+	}
+	return t.human + t.bots
+}
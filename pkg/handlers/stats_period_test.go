@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// periodFor parses the stats period of a request with the given query
+func periodFor(t *testing.T, query string, since time.Time) (statsPeriod, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/stats/abc/enhanced?"+query, nil)
+	return parseStatsPeriod(c, since)
+}
+
+func TestParseStatsPeriod(t *testing.T) {
+	utc := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	since := utc("2024-01-01T00:00:00Z")
+
+	tests := []struct {
+		name        string
+		query       string
+		from, to    time.Time
+		granularity string
+		tz          string
+		errMsg      string // Prefix of the expected error
+	}{
+		{
+			name:  "dates include the whole to day",
+			query: "from=2024-05-01&to=2024-05-03",
+			from:  utc("2024-05-01T00:00:00Z"), to: utc("2024-05-04T00:00:00Z"),
+			granularity: models.GranularityDay, tz: "UTC",
+		},
+		{
+			name:  "timestamps widen to whole hours",
+			query: "from=2024-05-01T10:15:00Z&to=2024-05-01T12:10:00Z&granularity=hour",
+			from:  utc("2024-05-01T10:00:00Z"), to: utc("2024-05-01T13:00:00Z"),
+			granularity: models.GranularityHour, tz: "UTC",
+		},
+		{
+			name:  "dates in the time zone",
+			query: "from=2024-05-01&to=2024-05-01&tz=Europe/Berlin",
+			from:  utc("2024-04-30T22:00:00Z"), to: utc("2024-05-01T22:00:00Z"),
+			granularity: models.GranularityDay, tz: "Europe/Berlin",
+		},
+		{
+			name:  "local timestamps in the time zone",
+			query: "from=2024-05-01T08:00&to=2024-05-01T09:30&tz=America/New_York&granularity=HOUR",
+			from:  utc("2024-05-01T12:00:00Z"), to: utc("2024-05-01T14:00:00Z"),
+			granularity: models.GranularityHour, tz: "America/New_York",
+		},
+		{
+			name:  "half-hour zone by the hour",
+			query: "from=2024-05-01&to=2024-05-01&tz=Asia/Kolkata&granularity=hour",
+			from:  utc("2024-04-30T18:00:00Z"), to: utc("2024-05-01T19:00:00Z"),
+			granularity: models.GranularityHour, tz: "Asia/Kolkata",
+		},
+		{
+			name:  "month",
+			query: "from=2024-01-15&to=2024-03-20&granularity=month",
+			from:  utc("2024-01-15T00:00:00Z"), to: utc("2024-03-21T00:00:00Z"),
+			granularity: models.GranularityMonth, tz: "UTC",
+		},
+		{
+			name:  "hour cap reached exactly",
+			query: "from=2024-01-01T00:00:00Z&to=2024-03-24T08:00:00Z&granularity=hour",
+			from:  utc("2024-01-01T00:00:00Z"), to: utc("2024-03-24T08:00:00Z"),
+			granularity: models.GranularityHour, tz: "UTC",
+		},
+		{name: "hour cap exceeded", query: "from=2024-01-01T00:00:00Z&to=2024-03-24T09:00:00Z&granularity=hour", errMsg: "Range too long for hour granularity"},
+		{name: "day cap exceeded", query: "from=2010-01-01&to=2024-01-01", errMsg: "Range too long for day granularity"},
+		{name: "half-hour zone by the day", query: "from=2024-05-01&to=2024-05-02&tz=Asia/Kolkata", errMsg: "Time zone Asia/Kolkata is not a whole number of hours"},
+		{name: "half-hour zone by the week", query: "from=2024-05-01&to=2024-05-30&tz=Australia/Adelaide&granularity=week", errMsg: "Time zone Australia/Adelaide is not a whole number of hours"},
+		{name: "unknown time zone", query: "tz=Mars/Olympus", errMsg: "Unknown time zone"},
+		{name: "unknown granularity", query: "granularity=year", errMsg: "Granularity must be"},
+		{name: "invalid from", query: "from=yesterday", errMsg: "Invalid from"},
+		{name: "invalid to", query: "to=2024-13-01", errMsg: "Invalid to"},
+		{name: "from after to", query: "from=2024-05-02&to=2024-05-01T00:00:00Z", errMsg: "Invalid range"},
+		{name: "unknown traffic", query: "traffic=robots", errMsg: "Traffic must be"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, errMsg := periodFor(t, tt.query, since)
+			if tt.errMsg != "" {
+				if !strings.HasPrefix(errMsg, tt.errMsg) {
+					t.Fatalf("error = %q, want %q", errMsg, tt.errMsg)
+				}
+				return
+			}
+			if errMsg != "" {
+				t.Fatalf("unexpected error %q", errMsg)
+			}
+			if !p.from.Equal(tt.from) || !p.to.Equal(tt.to) {
+				t.Errorf("range = %s - %s, want %s - %s", p.from, p.to, tt.from, tt.to)
+			}
+			if p.granularity != tt.granularity || p.loc.String() != tt.tz {
+				t.Errorf("granularity %s in %s, want %s in %s", p.granularity, p.loc, tt.granularity, tt.tz)
+			}
+		})
+	}
+}
+
+func TestParseStatsPeriodDefaults(t *testing.T) {
+	since := time.Now().Add(-50 * time.Hour)
+	p, errMsg := periodFor(t, "", since)
+	if errMsg != "" {
+		t.Fatalf("unexpected error %q", errMsg)
+	}
+	if !p.from.Equal(since.Truncate(time.Hour)) || p.to.Before(time.Now()) {
+		t.Errorf("range = %s - %s, want %s until now", p.from, p.to, since)
+	}
+
+	// Open bounds let all-time breakdowns use the daily rollups
+	if !p.query.From.IsZero() || !p.query.To.IsZero() || p.query.Traffic != store.TrafficHuman {
+		t.Errorf("query = %+v, want open bounds and human traffic", p.query)
+	}
+}
+
+func TestStatsPeriodSeries(t *testing.T) {
+	p, errMsg := periodFor(t, "from=2024-05-01&to=2024-05-02&tz=Europe/Berlin", time.Time{})
+	if errMsg != "" {
+		t.Fatalf("unexpected error %q", errMsg)
+	}
+
+	hour := func(value string) time.Time {
+		t, _ := time.Parse(time.RFC3339, value)
+		return t
+	}
+	series, total := p.series([]store.BucketCount{
+		{Start: hour("2024-04-30T21:00:00Z"), Clicks: 100}, // Before the period
+		{Start: hour("2024-04-30T22:00:00Z"), Clicks: 1},   // Midnight in Berlin
+		{Start: hour("2024-05-01T21:00:00Z"), Clicks: 2},   // 23:00 in Berlin
+		{Start: hour("2024-05-01T22:00:00Z"), Clicks: 4},   // The next day
+		{Start: hour("2024-05-02T22:00:00Z"), Clicks: 100}, // After the period
+	})
+
+	if total != 7 {
+		t.Errorf("total = %d, want 7", total)
+	}
+	if len(series) != 2 || series[0].Clicks != 3 || series[1].Clicks != 4 {
+		t.Fatalf("series = %+v, want 3 and 4 clicks", series)
+	}
+	if got := series[1].Start.In(p.loc).Format("2006-01-02 15:04"); got != "2024-05-02 00:00" {
+		t.Errorf("second bucket starts %s, want local midnight", got)
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"gourl/pkg/geoip"
+	"gourl/pkg/models"
+	"gourl/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Values accepted in the device and os conditions of a targeting rule
+var (
+	ruleDevices = []string{utils.DeviceMobile, utils.DeviceTablet, utils.DeviceDesktop}
+	ruleOSes    = []string{utils.OSiOS, utils.OSAndroid, utils.OSWindows, utils.OSMacOS, utils.OSLinux, utils.OSChromeOS}
+)
+
+// visitor holds the request attributes targeting rules are matched against.
+// The location needs a GeoIP lookup, so it is only resolved when a rule
+// asks for it.
+type visitor struct {
+	device   string
+	os       string
+	language string
+	ip       string
+	geo      geoip.GeoResolver
+
+	location *geoip.Location
+}
+
+func newVisitor(c *gin.Context) *visitor {
+	userAgent := c.GetHeader("User-Agent")
+	return &visitor{
+		device:   utils.DetectDevice(userAgent),
+		os:       utils.DetectOS(userAgent),
+		language: utils.PreferredLanguage(c.GetHeader("Accept-Language")),
+		ip:       c.ClientIP(),
+		geo:      getGeoResolver(c),
+	}
+}
+
+// Location returns the visitor's location, looking it up on first use
+func (v *visitor) Location() geoip.Location {
+	if v.location == nil {
+		loc := geoip.Locate(v.geo, v.ip)
+		v.location = &loc
+	}
+	return *v.location
+}
+
+// matchRule returns the first rule whose conditions all match v, or nil
+func matchRule(rules []models.TargetingRule, v *visitor) *models.TargetingRule {
+	for i := range rules {
+		rule := &rules[i]
+		if !matchesAny(rule.Devices, v.device) || !matchesAny(rule.OS, v.os) {
+			continue
+		}
+		if len(rule.Languages) > 0 && !matchesLanguage(rule.Languages, v.language) {
+			continue
+		}
+		if len(rule.Countries) > 0 && !matchesCountry(rule.Countries, v.Location()) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// matchesAny reports whether value is one of values; an empty list matches anything
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesCountry reports whether loc is in one of countries, given as
+// ISO codes ("de") or names ("germany")
+func matchesCountry(countries []string, loc geoip.Location) bool {
+	return matchesAny(countries, strings.ToLower(loc.CountryCode)) || matchesAny(countries, strings.ToLower(loc.Country))
+}
+
+// matchesLanguage reports whether language is one of tags or a regional
+// variant of one (a rule for "en" matches "en-gb")
+func matchesLanguage(tags []string, language string) bool {
+	for _, tag := range tags {
+		if language == tag || strings.HasPrefix(language, tag+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRule lowercases and trims the conditions of a rule and checks them
+func normalizeRule(rule *models.TargetingRule) (errMsg string) {
+	if !utils.ValidateURL(rule.Destination) {
+		return "Rule destination must start with http:// or https://"
+	}
+	rule.Devices = normalizeList(rule.Devices)
+	rule.OS = normalizeList(rule.OS)
+	rule.Languages = normalizeList(rule.Languages)
+	rule.Countries = normalizeList(rule.Countries)
+	for _, device := range rule.Devices {
+		if !matchesAny(ruleDevices, device) {
+			return "Rule devices must be one of " + strings.Join(ruleDevices, ", ")
+		}
+	}
+	for _, os := range rule.OS {
+		if !matchesAny(ruleOSes, os) {
+			return "Rule os must be one of " + strings.Join(ruleOSes, ", ")
+		}
+	}
+	return ""
+}
+
+// normalizeList lowercases and trims values, dropping empty ones. Commas are
+// removed since conditions are stored as comma-separated lists.
+func normalizeList(values []string) []string {
+	var normalized []string
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(value, ",", "")))
+		if value != "" {
+			normalized = append(normalized, value)
+		}
+	}
+	return normalized
+}
+
+// GetURLRules lists the targeting rules of a URL in evaluation order (if user owns it)
+func GetURLRules(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "view")
+	if !ok {
+		return
+	}
+
+	rules := url.Rules
+	if rules == nil {
+		rules = []models.TargetingRule{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"count": len(rules),
+	})
+}
+
+// SetURLRules replaces the ordered targeting rules of a URL (if user owns it).
+// Rules are evaluated top to bottom; visitors matching none of them go to
+// the link's own destination.
+func SetURLRules(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "edit")
+	if !ok {
+		return
+	}
+
+	var req models.SetRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body (at most 50 rules)"})
+		return
+	}
+	for i := range req.Rules {
+		if errMsg := normalizeRule(&req.Rules[i]); errMsg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg, "rule": i + 1})
+			return
+		}
+	}
+
+	if err := getStores(c).URLs.SetRules(c.Request.Context(), url, req.Rules); err != nil {
+		log.Printf("Error saving targeting rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rules"})
+		return
+	}
+
+	rules := url.Rules
+	if rules == nil {
+		rules = []models.TargetingRule{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"count": len(rules),
+	})
+}
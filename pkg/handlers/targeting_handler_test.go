@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gourl/pkg/geoip"
+	"gourl/pkg/models"
+)
+
+// targetingRules are evaluated in order; the first match wins
+var targetingRules = []models.TargetingRule{
+	{ID: 1, Devices: []string{"mobile"}, OS: []string{"ios"}, Destination: "https://example.com/app-store"},
+	{ID: 2, Devices: []string{"mobile", "tablet"}, Destination: "https://example.com/mobile"},
+	{ID: 3, Languages: []string{"de"}, Destination: "https://example.com/de"},
+	{ID: 4, Countries: []string{"fr", "belgium"}, Destination: "https://example.com/fr"},
+	{ID: 5, Devices: []string{"desktop"}, OS: []string{"linux"}, Languages: []string{"en"}, Destination: "https://example.com/linux"},
+}
+
+func TestMatchRuleOrder(t *testing.T) {
+	france := &geoip.Location{Country: "France", CountryCode: "FR"}
+	belgium := &geoip.Location{Country: "Belgium", CountryCode: "BE"}
+
+	tests := []struct {
+		name    string
+		visitor visitor
+		rule    int // 0 for no match
+	}{
+		{"iphone matches the first rule", visitor{device: "mobile", os: "ios", language: "de"}, 1},
+		{"android falls through to the second", visitor{device: "mobile", os: "android", language: "de"}, 2},
+		{"tablet", visitor{device: "tablet", os: "ios"}, 2},
+		{"regional language", visitor{device: "desktop", os: "windows", language: "de-at"}, 3},
+		{"language before country", visitor{device: "desktop", os: "windows", language: "de", location: france}, 3},
+		{"country code", visitor{device: "desktop", os: "windows", language: "fr", location: france}, 4},
+		{"country name", visitor{device: "desktop", os: "macos", location: belgium}, 4},
+		{"all conditions", visitor{device: "desktop", os: "linux", language: "en-us", location: &geoip.Location{Country: "Germany"}}, 5},
+		{"one condition missing", visitor{device: "desktop", os: "linux", language: "es", location: &geoip.Location{Country: "Germany"}}, 0},
+		{"no match", visitor{device: "desktop", os: "windows", language: "en", location: &geoip.Location{Country: "Germany"}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.visitor
+			rule := matchRule(targetingRules, &v)
+			got := 0
+			if rule != nil {
+				got = rule.ID
+			}
+			if got != tt.rule {
+				t.Errorf("matched rule %d, want %d", got, tt.rule)
+			}
+		})
+	}
+}
+
+func TestRedirectFollowsRules(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	url := s.createURL(t, &models.URL{Code: "targeted", OriginalURL: "https://example.com/default"})
+	if err := s.stores.URLs.SetRules(ctx, url, append([]models.TargetingRule(nil), targetingRules...)); err != nil {
+		t.Fatalf("SetRules: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		language  string
+		location  string
+	}{
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "en", "https://example.com/app-store"},
+		{"android", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "en", "https://example.com/mobile"},
+		{"german desktop", browserUA, "de-DE,de;q=0.9", "https://example.com/de"},
+		{"default", browserUA, "en-US", "https://example.com/default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/targeted", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set("Accept-Language", tt.language)
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusFound || w.Header().Get("Location") != tt.location {
+				t.Errorf("redirect = %d to %q, want %q", w.Code, w.Header().Get("Location"), tt.location)
+			}
+		})
+	}
+}
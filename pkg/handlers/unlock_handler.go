@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"gourl/pkg/auth"
+	"gourl/pkg/middleware"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unlockTTL is how long a successful unlock lasts before the password is asked again
+const unlockTTL = 10 * time.Minute
+
+var (
+	// Wrong passwords allowed per link and visitor IP
+	unlockAttempts = middleware.NewAttemptLimiter(5, 15*time.Minute)
+	// Wrong passwords allowed per link across all visitors
+	unlockLinkAttempts = middleware.NewAttemptLimiter(50, 15*time.Minute)
+)
+
+// unlockCookieName returns the cookie holding the unlock token for code
+func unlockCookieName(code string) string {
+	return "gourl_unlock_" + code
+}
+
+// isUnlocked reports whether the visitor holds a valid unlock cookie for url
+func isUnlocked(c *gin.Context, url *models.URL) bool {
+	token, err := c.Cookie(unlockCookieName(url.Code))
+	if err != nil || token == "" {
+		return false
+	}
+	return auth.ValidateUnlockToken(token, url.Code, url.PasswordHash)
+}
+
+// renderUnlockPage serves the password form for a protected link
+func renderUnlockPage(c *gin.Context, status int, code, errMsg string) {
+	renderPage(c, status, unlockPage, gin.H{"Code": code, "Error": errMsg})
+}
+
+// UnlockURL handles POST /{code}/unlock: checks the link password and sets a
+// short-lived signed cookie, then sends the visitor back to the short link
+func UnlockURL(c *gin.Context) {
+	code := c.Param("code")
+
+	url, err := getStores(c).URLs.GetByCode(c.Request.Context(), code)
+	if err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+			return
+		}
+		log.Printf("Error querying URL: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if !url.IsPasswordProtected() {
+		c.Redirect(http.StatusSeeOther, "/"+code)
+		return
+	}
+
+	// Throttle brute-force attempts per visitor and per link
+	visitorKey := code + "|" + c.ClientIP()
+	if unlockAttempts.Blocked(visitorKey) || unlockLinkAttempts.Blocked(code) {
+		renderUnlockPage(c, http.StatusTooManyRequests, code, "Too many attempts. Please try again later.")
+		return
+	}
+
+	var req models.UnlockRequest
+	if err := c.ShouldBind(&req); err != nil || !auth.CheckPassword(req.Password, url.PasswordHash) {
+		unlockAttempts.Fail(visitorKey)
+		unlockLinkAttempts.Fail(code)
+		renderUnlockPage(c, http.StatusUnauthorized, code, "Incorrect password")
+		return
+	}
+	unlockAttempts.Reset(visitorKey)
+
+	token, err := auth.GenerateUnlockToken(code, url.PasswordHash, unlockTTL)
+	if err != nil {
+		log.Printf("Error generating unlock token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock URL"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(unlockCookieName(code), token, int(unlockTTL.Seconds()), "/"+code, "", isSecureRequest(c), true)
+
+	// The redirect handler counts the click now that the visitor is unlocked
+	c.Redirect(http.StatusSeeOther, "/"+code)
+}
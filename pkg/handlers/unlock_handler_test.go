@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gourl/pkg/auth"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+)
+
+// createProtectedURL stores a link behind password
+func (s *testServer) createProtectedURL(t *testing.T, code, password string) *models.URL {
+	t.Helper()
+	hash, errMsg := hashLinkPassword(password)
+	if errMsg != "" {
+		t.Fatalf("hashLinkPassword: %s", errMsg)
+	}
+	limit := 10
+	return s.createURL(t, &models.URL{Code: code, OriginalURL: "https://example.com/" + code, PasswordHash: hash, MaxClicks: &limit})
+}
+
+// unlock submits the password form of code
+func (s *testServer) unlock(code, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/"+code+"/unlock", strings.NewReader(url.Values{"password": {password}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", browserUA)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// visit requests code with an unlock cookie holding token, if any
+func (s *testServer) visit(method, code, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/"+code, nil)
+	req.Header.Set("User-Agent", browserUA)
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: unlockCookieName(code), Value: token})
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// unlockCookie returns the unlock token set by an unlock response
+func unlockCookie(w *httptest.ResponseRecorder, code string) string {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == unlockCookieName(code) {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestUnlock(t *testing.T) {
+	s := newTestServer(t)
+	s.createProtectedURL(t, "locked", "open sesame")
+
+	// Without a cookie the visitor gets the password form
+	w := s.visit("GET", "locked", "")
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" || !strings.Contains(w.Body.String(), "Password required") {
+		t.Fatalf("locked visit = %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	if w := s.unlock("locked", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password = %d, want 401", w.Code)
+	}
+
+	w = s.unlock("locked", "open sesame")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/locked" {
+		t.Fatalf("unlock = %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	token := unlockCookie(w, "locked")
+	if token == "" {
+		t.Fatal("unlock set no cookie")
+	}
+
+	w = s.visit("GET", "locked", token)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/locked" {
+		t.Errorf("unlocked visit = %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+}
+
+func TestUnlockLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.createProtectedURL(t, "guarded", "open sesame")
+	// The limiters outlive the test server, so forget the failures afterwards
+	t.Cleanup(func() {
+		unlockAttempts.Reset("guarded|192.0.2.1")
+		unlockLinkAttempts.Reset("guarded")
+	})
+
+	for i := 1; i <= 5; i++ {
+		if w := s.unlock("guarded", "wrong"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password #%d = %d, want 401", i, w.Code)
+		}
+	}
+
+	// Once locked out even the right password is refused
+	w := s.unlock("guarded", "open sesame")
+	if w.Code != http.StatusTooManyRequests || unlockCookie(w, "guarded") != "" {
+		t.Errorf("unlock after 5 failures = %d, want 429 without a cookie", w.Code)
+	}
+}
+
+func TestUnlockTokenRejected(t *testing.T) {
+	s := newTestServer(t)
+	secret := s.createProtectedURL(t, "secret", "open sesame")
+	other := s.createProtectedURL(t, "other", "open sesame")
+
+	expired, err := auth.GenerateUnlockToken("secret", secret.PasswordHash, -time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateUnlockToken: %v", err)
+	}
+	foreign, err := auth.GenerateUnlockToken("other", other.PasswordHash, time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateUnlockToken: %v", err)
+	}
+	oldPassword, err := auth.GenerateUnlockToken("secret", "$2a$10$previous-password-hash", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateUnlockToken: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", expired},
+		{"other link", foreign},
+		{"changed password", oldPassword},
+		{"garbage", "not-a-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := s.visit("GET", "secret", tt.token)
+			if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+				t.Errorf("visit = %d, Location %q, want the password form", w.Code, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestHeadProtectedURL(t *testing.T) {
+	s := newTestServer(t)
+	locked := s.createProtectedURL(t, "peek", "open sesame")
+
+	// HEAD neither reveals the destination nor uses up the link
+	w := s.visit("HEAD", "peek", "")
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Errorf("HEAD = %d, Location %q, want 200 without a Location", w.Code, w.Header().Get("Location"))
+	}
+
+	// Nor does it once unlocked, since the link is click-limited
+	token := unlockCookie(s.unlock("peek", "open sesame"), "peek")
+	w = s.visit("HEAD", "peek", token)
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Errorf("unlocked HEAD = %d, Location %q, want 200 without a Location", w.Code, w.Header().Get("Location"))
+	}
+	if w := s.visit("GET", "peek", token); w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/peek" {
+		t.Errorf("unlocked GET = %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// HEAD requests count as bot traffic and don't use up clicks
+	url, err := s.stores.URLs.GetByCode(context.Background(), "peek")
+	if err != nil || url.UseCount != 1 {
+		t.Errorf("use count = %d, %v, want 1 for the GET", url.UseCount, err)
+	}
+	s.clicks.Close(context.Background())
+	if n, err := s.stores.Clicks.CountClicks(context.Background(), locked.ID, store.TrafficHuman); err != nil || n != 1 {
+		t.Errorf("human clicks = %d, %v, want 1", n, err)
+	}
+}
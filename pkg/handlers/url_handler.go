@@ -1,13 +1,16 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
-	"gourl/pkg/database"
+	"gourl/pkg/ingest"
 	"gourl/pkg/models"
+	"gourl/pkg/privacy"
+	"gourl/pkg/store"
 	"gourl/pkg/utils"
 
 	"github.com/gin-gonic/gin"
@@ -27,6 +30,33 @@ func CreateShortURL(c *gin.Context) {
 		return
 	}
 
+	// Hash the optional link password
+	passwordHash, errMsg := hashLinkPassword(req.Password)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	url := &models.URL{
+		OriginalURL:     req.URL,
+		UserID:          getOptionalUserID(c),
+		ExpiresAt:       req.ExpiresAt,
+		StartsAt:        req.StartsAt,
+		PrelaunchURL:    req.Prelaunch,
+		FallbackURL:     req.Fallback,
+		RedirectType:    req.Redirect,
+		PasswordHash:    passwordHash,
+		MaxClicks:       req.MaxClicks,
+		StatsVisibility: req.Stats,
+	}
+	if errMsg := validateLinkOptions(url); errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	stores := getStores(c)
+	ctx := c.Request.Context()
+
 	// Handle custom code if provided
 	var code string
 	if req.CustomCode != "" {
@@ -36,10 +66,9 @@ func CreateShortURL(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 			return
 		}
-		
+
 		// Check if custom code already exists
-		var exists bool
-		err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM urls WHERE code = ?)", req.CustomCode).Scan(&exists)
+		exists, err := stores.URLs.CodeExists(ctx, req.CustomCode)
 		if err != nil {
 			log.Printf("Error checking custom code existence: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
@@ -62,9 +91,8 @@ func CreateShortURL(c *gin.Context) {
 
 		// Check if code already exists (very unlikely but handle it)
 		for i := 0; i < 5; i++ {
-			var exists bool
-			err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM urls WHERE code = ?)", code).Scan(&exists)
-			if err != nil && err != sql.ErrNoRows {
+			exists, err := stores.URLs.CodeExists(ctx, code)
+			if err != nil {
 				log.Printf("Error checking code existence: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 				return
@@ -82,40 +110,21 @@ func CreateShortURL(c *gin.Context) {
 		}
 	}
 
-	// Get user ID if authenticated (optional)
-	var userID interface{}
-	if uid, exists := c.Get("userID"); exists {
-		if id, ok := uid.(int); ok {
-			userID = id
-		}
-	} else {
-		userID = nil
-	}
-
 	// Insert into database
 	now := time.Now()
-	// Format time for SQLite compatibility
-	createdAt := now.Format("2006-01-02 15:04:05")
-	
-	var expiresAt interface{}
-	if req.ExpiresAt != nil {
-		expiresAt = req.ExpiresAt.Format("2006-01-02 15:04:05")
-	} else {
-		expiresAt = nil
-	}
-	
-	result, err := database.DB.Exec(
-		"INSERT INTO urls (code, original_url, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
-		code, req.URL, userID, createdAt, expiresAt,
-	)
-	if err != nil {
+	url.Code = code
+	url.CreatedAt = now
+	if err := stores.URLs.Create(ctx, url); err != nil {
+		if err == store.ErrConflict {
+			c.JSON(http.StatusConflict, gin.H{"error": "This custom code is already taken"})
+			return
+		}
 		log.Printf("Error inserting URL: %v", err)
-		log.Printf("Code: %s, URL: %s, UserID: %v, Time: %v", code, req.URL, userID, now)
+		log.Printf("Code: %s, URL: %s, UserID: %v, Time: %v", code, req.URL, url.UserID, now)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create short URL", "details": err.Error()})
 		return
 	}
 
-	id, _ := result.LastInsertId()
 	// Build full short URL using configurable base URL
 	baseURL := getBaseURL(c)
 	shortURL := baseURL + "/" + code
@@ -125,13 +134,15 @@ func CreateShortURL(c *gin.Context) {
 		OriginalURL: req.URL,
 		Code:        code,
 		CreatedAt:   now,
+		StartsAt:    url.StartsAt,
+		ExpiresAt:   url.ExpiresAt,
 	}
 
-	log.Printf("Created short URL: %s -> %s (ID: %d)", code, req.URL, id)
+	log.Printf("Created short URL: %s -> %s (ID: %d)", code, req.URL, url.ID)
 	c.JSON(http.StatusCreated, response)
 }
 
-// RedirectURL handles GET /{code} requests and redirects to original URL
+// RedirectURL handles GET and HEAD /{code} requests and redirects to original URL
 func RedirectURL(c *gin.Context) {
 	code := c.Param("code")
 	if code == "" {
@@ -140,7 +151,7 @@ func RedirectURL(c *gin.Context) {
 	}
 
 	// Exclude reserved paths
-	reservedPaths := []string{"api", "static", "health", "index.html", "favicon.ico"}
+	reservedPaths := []string{"api", "static", "health", "internal", "index.html", "favicon.ico"}
 	for _, reserved := range reservedPaths {
 		if code == reserved {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
@@ -148,16 +159,12 @@ func RedirectURL(c *gin.Context) {
 		}
 	}
 
-	var urlID int
-	var originalURL string
-	var expiresAt sql.NullString
-	err := database.DB.QueryRow(
-		"SELECT id, original_url, expires_at FROM urls WHERE code = ?",
-		code,
-	).Scan(&urlID, &originalURL, &expiresAt)
+	stores := getStores(c)
+	ctx := c.Request.Context()
 
+	url, err := stores.URLs.GetByCode(ctx, code)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 			return
 		}
@@ -166,43 +173,155 @@ func RedirectURL(c *gin.Context) {
 		return
 	}
 
-	// Check if URL has expired
-	if expiresAt.Valid && expiresAt.String != "" {
-		if expTime, err := time.Parse("2006-01-02 15:04:05", expiresAt.String); err == nil {
-			if time.Now().After(expTime) {
-				c.JSON(http.StatusGone, gin.H{"error": "This short URL has expired"})
-				return
-			}
+	// Check if URL is disabled, has expired or hasn't started yet
+	now := time.Now()
+	if url.Disabled {
+		respondUnavailable(c, url, models.URLStatusDisabled)
+		return
+	}
+	if url.IsExpired(now) {
+		respondUnavailable(c, url, models.URLStatusExpired)
+		return
+	}
+	if url.IsScheduled(now) {
+		respondNotYetActive(c, url, now)
+		return
+	}
+
+	// Password-protected links show the unlock form until the visitor has
+	// unlocked them; only unlocked visits are counted as clicks
+	if url.IsPasswordProtected() && !isUnlocked(c, url) {
+		renderUnlockPage(c, http.StatusOK, code, "")
+		return
+	}
+
+	// Bots (crawlers, link previews, HEAD requests) are redirected and
+	// logged as bot traffic
+	isBot := getBotClassifier(c).IsBot(c.Request)
+
+	// Click-limited links claim a use atomically so concurrent visitors
+	// can never exceed the limit. Bots don't use them up, so they get a
+	// page without the destination instead; anyone can pose as a bot.
+	if url.MaxClicks != nil && isBot {
+		if *url.RemainingClicks() == 0 {
+			respondUnavailable(c, url, models.URLStatusExhausted)
+			return
+		}
+		logClick(c, models.Click{URLID: url.ID, IsBot: true})
+		renderPage(c, http.StatusOK, limitedPage, nil)
+		return
+	} else if url.MaxClicks != nil {
+		ok, err := stores.URLs.ConsumeUse(ctx, url)
+		if err != nil {
+			log.Printf("Error consuming URL use: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !ok {
+			respondUnavailable(c, url, models.URLStatusExhausted)
+			return
 		}
 	}
 
-	// Log the click asynchronously (don't block redirect)
-	go logClick(urlID, c)
+	// Targeting rules may send this visitor somewhere else; everyone else
+	// is split between the A/B variants, if any
+	destination := url.OriginalURL
+	click := models.Click{URLID: url.ID, IsBot: isBot}
+	if len(url.Rules) > 0 {
+		v := newVisitor(c)
+		if rule := matchRule(url.Rules, v); rule != nil {
+			destination = rule.Destination
+			click.RuleID = &rule.ID
+		}
+		if v.location != nil {
+			ingest.SetLocation(&click, *v.location)
+		}
+	}
+	if click.RuleID == nil && len(url.Variants) > 0 {
+		if variant := pickVariant(c, url); variant != nil {
+			destination = variant.Destination
+			click.VariantID = &variant.ID
+		}
+	}
 
-	log.Printf("Redirecting %s -> %s", code, originalURL)
-	c.Redirect(http.StatusMovedPermanently, originalURL)
+	// Log the click; it is written off the redirect path except on serverless
+	logClick(c, click)
+
+	log.Printf("Redirecting %s -> %s", code, destination)
+	redirectToDestination(c, url, destination)
 }
 
-// logClick logs a click event to the database
-func logClick(urlID int, c *gin.Context) {
-	// Extract request information
-	ipAddress := c.ClientIP()
-	userAgent := c.GetHeader("User-Agent")
-	referrer := c.GetHeader("Referer")
-	
-	// Get country from IP (in goroutine to not block redirect)
-	go func() {
-		country := utils.GetCountryFromIP(ipAddress)
-		
-		// Insert click into database
-		_, err := database.DB.Exec(
-			"INSERT INTO clicks (url_id, ip_address, user_agent, referrer, country) VALUES (?, ?, ?, ?, ?)",
-			urlID, ipAddress, userAgent, referrer, country,
-		)
-		if err != nil {
-			log.Printf("Error logging click: %v", err)
+// effectiveRedirectType returns the link's redirect type or the server default
+func effectiveRedirectType(c *gin.Context, url *models.URL) string {
+	if url.RedirectType != "" {
+		return url.RedirectType
+	}
+	return getConfig(c).RedirectType
+}
+
+// redirectToDestination sends the visitor to destination using the link's
+// redirect type
+func redirectToDestination(c *gin.Context, url *models.URL, destination string) {
+	redirectType := effectiveRedirectType(c, url)
+
+	// Browsers must never cache the redirect of password-protected,
+	// click-limited, targeted or A/B-tested links, or repeat visits would
+	// bypass the password, the limit, the rules or the split, so permanent
+	// redirects are downgraded to their temporary twins
+	if url.IsPasswordProtected() || url.MaxClicks != nil || len(url.Rules) > 0 || len(url.Variants) > 0 {
+		c.Header("Cache-Control", "no-store")
+		switch redirectType {
+		case models.RedirectPermanent:
+			redirectType = models.RedirectTemporary
+		case models.RedirectPermanentKeepBody:
+			redirectType = models.RedirectTemporaryKeepBody
 		}
-	}()
+	}
+
+	switch redirectType {
+	case models.RedirectHTML:
+		renderPage(c, http.StatusOK, forwardPage, gin.H{"URL": destination})
+	case models.RedirectTemporary:
+		c.Redirect(http.StatusFound, destination)
+	case models.RedirectTemporaryKeepBody:
+		c.Redirect(http.StatusTemporaryRedirect, destination)
+	case models.RedirectPermanentKeepBody:
+		c.Redirect(http.StatusPermanentRedirect, destination)
+	default:
+		c.Redirect(http.StatusMovedPermanently, destination)
+	}
+}
+
+// respondNotYetActive answers visits to a link before its start time, either
+// by sending the visitor to its pre-launch URL or with the configured status
+func respondNotYetActive(c *gin.Context, url *models.URL, now time.Time) {
+	// The response changes once the link starts, so it must never be cached
+	c.Header("Cache-Control", "no-store")
+	if url.PrelaunchURL != "" {
+		c.Redirect(http.StatusFound, url.PrelaunchURL)
+		return
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(url.StartsAt.Sub(now).Seconds()))))
+	c.JSON(getConfig(c).NotActiveStatus, gin.H{
+		"error":     "This short URL is not active yet",
+		"starts_at": url.StartsAt,
+	})
+}
+
+// logClick hands a click event to the ingestion queue. The caller
+// fills in the URL and routing details; request details are copied here
+// since the context must not be used after the handler returns.
+func logClick(c *gin.Context, click models.Click) {
+	click.IPAddress = c.ClientIP()
+	click.UserAgent = c.GetHeader("User-Agent")
+	click.Referrer = c.GetHeader("Referer")
+	click.DoNotTrack = privacy.OptedOut(c.Request.Header)
+	click.ClickedAt = time.Now()
+
+	if queue := getClickQueue(c); queue != nil {
+		queue.Enqueue(click)
+	}
 }
 
 // GetStats handles GET /api/stats/{code} requests and returns analytics
@@ -213,17 +332,19 @@ func GetStats(c *gin.Context) {
 		return
 	}
 
-	// Get URL information
-	var urlID int
-	var originalURL string
-	var createdAt time.Time
-	err := database.DB.QueryRow(
-		"SELECT id, original_url, created_at FROM urls WHERE code = ?",
-		code,
-	).Scan(&urlID, &originalURL, &createdAt)
+	traffic, errMsg := parseTraffic(c)
+	if errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
 
+	stores := getStores(c)
+	ctx := c.Request.Context()
+
+	// Get URL information
+	url, err := stores.URLs.GetByCode(ctx, code)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 			return
 		}
@@ -231,39 +352,38 @@ func GetStats(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+	if !canViewStats(c, url) {
+		return
+	}
 
-	// Get total clicks count
-	var totalClicks int
-	err = database.DB.QueryRow(
-		"SELECT COUNT(*) FROM clicks WHERE url_id = ?",
-		urlID,
-	).Scan(&totalClicks)
+	// Get total clicks count, split into human and bot traffic
+	counts, err := countTraffic(ctx, stores.Clicks, url.ID)
 	if err != nil {
 		log.Printf("Error counting clicks: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	// Get unique IP addresses count
-	var uniqueIPs int
-	err = database.DB.QueryRow(
-		"SELECT COUNT(DISTINCT ip_address) FROM clicks WHERE url_id = ?",
-		urlID,
-	).Scan(&uniqueIPs)
+	// Get unique visitors count
+	uniqueIPs, err := stores.Clicks.CountUniqueVisitors(ctx, url.ID, traffic)
 	if err != nil {
-		log.Printf("Error counting unique IPs: %v", err)
+		log.Printf("Error counting unique visitors: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
 	response := models.StatsResponse{
-		Code:        code,
-		OriginalURL: originalURL,
-		CreatedAt:   createdAt,
-		TotalClicks: totalClicks,
-		UniqueIPs:   uniqueIPs,
+		Code:            code,
+		OriginalURL:     url.OriginalURL,
+		CreatedAt:       url.CreatedAt,
+		Traffic:         string(traffic),
+		TotalClicks:     counts.of(traffic),
+		HumanClicks:     counts.human,
+		BotClicks:       counts.bots,
+		UniqueIPs:       uniqueIPs,
+		MaxClicks:       url.MaxClicks,
+		RemainingClicks: url.RemainingClicks(),
 	}
 
 	c.JSON(http.StatusOK, response)
 }
-
@@ -1,13 +1,13 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"time"
 
-	"gourl/pkg/database"
 	"gourl/pkg/models"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,92 +27,77 @@ func GetMyURLs(c *gin.Context) {
 	}
 
 	// Get URLs for this user
-	rows, err := database.DB.Query(
-		"SELECT id, code, original_url, created_at FROM urls WHERE user_id = ? ORDER BY created_at DESC",
-		id,
-	)
+	urls, err := getStores(c).URLs.ListByUser(c.Request.Context(), id)
 	if err != nil {
 		log.Printf("Error querying user URLs: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
-	defer rows.Close()
 
-	urls := []models.URL{}
-	for rows.Next() {
-		var url models.URL
-		var createdAtStr string
-		err := rows.Scan(&url.ID, &url.Code, &url.OriginalURL, &createdAtStr)
-		if err != nil {
-			log.Printf("Error scanning URL: %v", err)
-			continue
-		}
-		
-		// Parse created_at
-		if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr); err == nil {
-			url.CreatedAt = t
-		} else if t, err := time.Parse(time.RFC3339, createdAtStr); err == nil {
-			url.CreatedAt = t
-		} else {
-			url.CreatedAt = time.Now()
-		}
-		
-		url.UserID = &id
-		urls = append(urls, url)
+	// Include each link's state so scheduled and expired links stand out
+	now := time.Now()
+	userURLs := make([]models.UserURL, len(urls))
+	for i, url := range urls {
+		userURLs[i] = models.UserURL{URL: url, Status: url.Status(now)}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"urls": urls,
-		"count": len(urls),
+		"urls":  userURLs,
+		"count": len(userURLs),
 	})
 }
 
-// DeleteURL deletes a URL (only if user owns it)
-func DeleteURL(c *gin.Context) {
-	userID, exists := c.Get("userID")
+// getOwnedURL loads the URL named by the :code parameter and checks that the
+// authenticated user owns it. On failure it writes the error response and
+// returns ok=false; action is used in the permission error message.
+func getOwnedURL(c *gin.Context, action string) (url *models.URL, userID int, ok bool) {
+	uid, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+		return nil, 0, false
 	}
 
-	id, ok := userID.(int)
+	userID, ok = uid.(int)
 	if !ok {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
-		return
+		return nil, 0, false
 	}
 
 	code := c.Param("code")
 	if code == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
-		return
+		return nil, 0, false
 	}
 
-	// Verify user owns this URL
-	var urlUserID sql.NullInt64
-	err := database.DB.QueryRow(
-		"SELECT user_id FROM urls WHERE code = ?",
-		code,
-	).Scan(&urlUserID)
-
+	url, err := getStores(c).URLs.GetByCode(c.Request.Context(), code)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == store.ErrNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
-			return
+			return nil, 0, false
 		}
 		log.Printf("Error querying URL: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+		return nil, 0, false
 	}
 
 	// Check ownership
-	if !urlUserID.Valid || urlUserID.Int64 != int64(id) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to delete this URL"})
+	if url.UserID == nil || *url.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to " + action + " this URL"})
+		return nil, 0, false
+	}
+
+	return url, userID, true
+}
+
+// DeleteURL deletes a URL (only if user owns it)
+func DeleteURL(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "delete")
+	if !ok {
 		return
 	}
 
 	// Delete URL (cascade will delete clicks)
-	_, err = database.DB.Exec("DELETE FROM urls WHERE code = ?", code)
-	if err != nil {
+	if err := getStores(c).URLs.Delete(c.Request.Context(), url.Code); err != nil {
 		log.Printf("Error deleting URL: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete URL"})
 		return
@@ -123,67 +108,205 @@ func DeleteURL(c *gin.Context) {
 
 // GetURLDetails returns detailed information about a URL (if user owns it)
 func GetURLDetails(c *gin.Context) {
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	url, _, ok := getOwnedURL(c, "view")
+	if !ok {
 		return
 	}
 
-	id, ok := userID.(int)
+	// Get click count (human traffic, like the stats endpoints by default)
+	clickCount, err := getStores(c).Clicks.CountClicks(c.Request.Context(), url.ID, store.TrafficHuman)
+	if err != nil {
+		log.Printf("Error counting clicks: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url":                url,
+		"click_count":        clickCount,
+		"password_protected": url.IsPasswordProtected(),
+		"remaining_clicks":   url.RemainingClicks(),
+		"redirect_type":      effectiveRedirectType(c, url),
+	})
+}
+
+// UpdateURL changes the destination and other mutable attributes of a URL (if user owns it)
+func UpdateURL(c *gin.Context) {
+	url, userID, ok := getOwnedURL(c, "edit")
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	code := c.Param("code")
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+	var req models.UpdateURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.IsEmpty() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No changes provided"})
 		return
 	}
 
-	// Get URL details
-	var url models.URL
-	var urlUserID sql.NullInt64
-	var createdAtStr string
-	err := database.DB.QueryRow(
-		"SELECT id, code, original_url, user_id, created_at FROM urls WHERE code = ?",
-		code,
-	).Scan(&url.ID, &url.Code, &url.OriginalURL, &urlUserID, &createdAtStr)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+	// Only the fields in the request are saved, so edits racing this one
+	// (or made since url was cached) are kept
+	var fields []store.URLField
+	if req.URL != nil {
+		if !utils.ValidateURL(*req.URL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "URL must start with http:// or https://"})
 			return
 		}
-		log.Printf("Error querying URL: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		url.OriginalURL = *req.URL
+		fields = append(fields, store.FieldDestination)
+	}
+	if req.ExpiresAt.Set {
+		url.ExpiresAt = req.ExpiresAt.Value
+		fields = append(fields, store.FieldExpiresAt)
+	}
+	if req.StartsAt.Set {
+		url.StartsAt = req.StartsAt.Value
+		fields = append(fields, store.FieldStartsAt)
+	}
+	if req.Prelaunch.Set {
+		url.PrelaunchURL = req.Prelaunch.ValueOrZero()
+		fields = append(fields, store.FieldPrelaunch)
+	}
+	if req.Fallback.Set {
+		url.FallbackURL = req.Fallback.ValueOrZero()
+		fields = append(fields, store.FieldFallback)
+	}
+	if req.Redirect.Set {
+		url.RedirectType = req.Redirect.ValueOrZero()
+		fields = append(fields, store.FieldRedirectType)
+	}
+	if req.Disabled != nil {
+		url.Disabled = *req.Disabled
+		fields = append(fields, store.FieldDisabled)
+	}
+	if req.Stats != nil {
+		// "" restores the default
+		url.StatsVisibility = *req.Stats
+		fields = append(fields, store.FieldStats)
+	}
+	if req.Password.Set {
+		// null or "" removes the password
+		passwordHash, errMsg := hashLinkPassword(req.Password.ValueOrZero())
+		if errMsg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+		url.PasswordHash = passwordHash
+		fields = append(fields, store.FieldPassword)
+	}
+	if req.MaxClicks.Set {
+		// null removes the limit; a limit at or below the current use count
+		// exhausts the link immediately
+		url.MaxClicks = req.MaxClicks.Value
+		fields = append(fields, store.FieldMaxClicks)
+	}
+	if errMsg := validateLinkOptions(url); errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 		return
 	}
 
-	// Check ownership
-	if !urlUserID.Valid || urlUserID.Int64 != int64(id) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to view this URL"})
+	// Saving through the store also records the previous destination and
+	// invalidates the cached redirect
+	urls := getStores(c).URLs
+	ctx := c.Request.Context()
+	if err := urls.Update(ctx, url, fields, &userID); err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "URL not found"})
+			return
+		}
+		log.Printf("Error updating URL: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update URL"})
 		return
 	}
 
-	// Parse created_at
-	if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr); err == nil {
-		url.CreatedAt = t
-	} else if t, err := time.Parse(time.RFC3339, createdAtStr); err == nil {
-		url.CreatedAt = t
+	// Respond with the stored link, including fields changed by others
+	if updated, err := urls.GetByCode(ctx, url.Code); err != nil {
+		log.Printf("Error querying URL: %v", err)
 	} else {
-		url.CreatedAt = time.Now()
+		url = updated
 	}
 
-	url.UserID = &id
+	log.Printf("Updated short URL: %s -> %s", url.Code, url.OriginalURL)
+	c.JSON(http.StatusOK, gin.H{"url": url})
+}
 
-	// Get click count
-	var clickCount int
-	database.DB.QueryRow("SELECT COUNT(*) FROM clicks WHERE url_id = ?", url.ID).Scan(&clickCount)
+// GetURLHistory returns the previous destinations of a URL (if user owns it)
+func GetURLHistory(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "view")
+	if !ok {
+		return
+	}
+
+	history, err := getStores(c).URLs.History(c.Request.Context(), url.ID)
+	if err != nil {
+		log.Printf("Error querying URL history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"url": url,
-		"click_count": clickCount,
+		"history": history,
+		"count":   len(history),
 	})
 }
 
+// GetAccount returns the authenticated user's account and settings
+func GetAccount(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	user, err := getStores(c).Users.GetByID(c.Request.Context(), userID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error querying user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// UpdateAccount changes account-wide settings such as the fallback URL
+// used by the user's expired, disabled or exhausted links
+func UpdateAccount(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req models.UpdateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !req.FallbackURL.Set {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No changes provided"})
+		return
+	}
+
+	fallbackURL := req.FallbackURL.ValueOrZero()
+	if fallbackURL != "" && !utils.ValidateURL(fallbackURL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fallback_url must start with http:// or https://"})
+		return
+	}
+
+	stores := getStores(c)
+	ctx := c.Request.Context()
+	if err := stores.Users.SetFallbackURL(ctx, userID, fallbackURL); err != nil {
+		if err == store.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Printf("Error updating account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account"})
+		return
+	}
+
+	user, err := stores.Users.GetByID(ctx, userID)
+	if err != nil {
+		log.Printf("Error querying user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+)
+
+// patchURL sends a PATCH and returns the link in the response
+func (s *testServer) patchURL(t *testing.T, code, token string, body map[string]interface{}) *models.URL {
+	t.Helper()
+	w := s.do("PATCH", "/api/urls/"+code, body, token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH %v = %d (%s)", body, w.Code, w.Body)
+	}
+	var resp struct {
+		URL models.URL `json:"url"`
+	}
+	decode(t, w, &resp)
+	return &resp.URL
+}
+
+func TestUpdateURLOmittedAndNull(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	limit := 10
+	s.createURL(t, &models.URL{
+		Code:        "edit",
+		OriginalURL: "https://example.com/old",
+		UserID:      &owner.User.ID,
+		ExpiresAt:   &expires,
+		MaxClicks:   &limit,
+		FallbackURL: "https://example.com/fallback",
+	})
+
+	// Omitted fields are left alone
+	url := s.patchURL(t, "edit", owner.Token, map[string]interface{}{"url": "https://example.com/new"})
+	if url.OriginalURL != "https://example.com/new" {
+		t.Errorf("destination = %q", url.OriginalURL)
+	}
+	if url.ExpiresAt == nil || !url.ExpiresAt.Equal(expires) || url.MaxClicks == nil || *url.MaxClicks != 10 || url.FallbackURL == "" {
+		t.Errorf("omitted fields changed: %+v", url)
+	}
+
+	// Explicit nulls clear only their own field
+	url = s.patchURL(t, "edit", owner.Token, map[string]interface{}{"expires_at": nil, "fallback_url": nil})
+	if url.ExpiresAt != nil || url.FallbackURL != "" {
+		t.Errorf("null fields kept: expires %v, fallback %q", url.ExpiresAt, url.FallbackURL)
+	}
+	if url.MaxClicks == nil || url.OriginalURL != "https://example.com/new" {
+		t.Errorf("other fields changed: %+v", url)
+	}
+
+	url = s.patchURL(t, "edit", owner.Token, map[string]interface{}{"max_clicks": nil})
+	if url.MaxClicks != nil {
+		t.Errorf("max_clicks = %v, want none", *url.MaxClicks)
+	}
+
+	if w := s.do("PATCH", "/api/urls/edit", map[string]interface{}{}, owner.Token); w.Code != http.StatusBadRequest {
+		t.Errorf("empty PATCH = %d, want 400", w.Code)
+	}
+	other := s.register(t, "bob")
+	if w := s.do("PATCH", "/api/urls/edit", map[string]interface{}{"disabled": true}, other.Token); w.Code != http.StatusForbidden {
+		t.Errorf("PATCH by another user = %d, want 403", w.Code)
+	}
+}
+
+func TestUpdateURLKeepsConcurrentChanges(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	ctx := context.Background()
+	limit := 10
+	s.createURL(t, &models.URL{Code: "busy", OriginalURL: "https://example.com", UserID: &owner.User.ID, MaxClicks: &limit})
+
+	// Visits use up clicks and cache the link
+	for i := 0; i < 2; i++ {
+		if w := s.do("GET", "/busy", nil, ""); w.Code != http.StatusFound {
+			t.Fatalf("visit = %d", w.Code)
+		}
+	}
+	if _, err := s.stores.URLs.GetByCode(ctx, "busy"); err != nil {
+		t.Fatalf("GetByCode: %v", err)
+	}
+
+	// Another instance edits a different field; this one's cache is stale
+	url, err := s.backend.URLs.GetByCode(ctx, "busy")
+	if err != nil {
+		t.Fatalf("GetByCode: %v", err)
+	}
+	url.FallbackURL = "https://example.com/elsewhere"
+	if err := s.backend.URLs.Update(ctx, url, []store.URLField{store.FieldFallback}, nil); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if _, err := s.backend.URLs.ConsumeUse(ctx, url); err != nil {
+		t.Fatalf("ConsumeUse: %v", err)
+	}
+
+	s.patchURL(t, "busy", owner.Token, map[string]interface{}{"disabled": true})
+
+	stored, err := s.backend.URLs.GetByCode(ctx, "busy")
+	if err != nil {
+		t.Fatalf("GetByCode: %v", err)
+	}
+	if !stored.Disabled {
+		t.Error("PATCH wasn't saved")
+	}
+	if stored.FallbackURL != "https://example.com/elsewhere" {
+		t.Errorf("fallback = %q, the other instance's edit was lost", stored.FallbackURL)
+	}
+	if stored.UseCount != 3 {
+		t.Errorf("use count = %d, want 3", stored.UseCount)
+	}
+}
@@ -1,29 +1,141 @@
 package handlers
 
 import (
+	"log"
 	"strings"
 
+	"gourl/pkg/auth"
 	"gourl/pkg/config"
+	"gourl/pkg/database"
+	"gourl/pkg/geoip"
+	"gourl/pkg/ingest"
+	"gourl/pkg/live"
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
+// WithStores makes the given repositories available to handlers
+func WithStores(stores *store.Stores) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("stores", stores)
+		c.Next()
+	}
+}
+
+// getStores returns the repositories injected by WithStores
+// Falls back to SQL stores on the global database connection
+func getStores(c *gin.Context) *store.Stores {
+	if s, exists := c.Get("stores"); exists {
+		if stores, ok := s.(*store.Stores); ok {
+			return stores
+		}
+	}
+	return store.NewSQL(database.DB, database.Current())
+}
+
+// getConfig returns the configuration stored in the context
+// Falls back to loading it from the environment
+func getConfig(c *gin.Context) *config.Config {
+	if cfgInterface, exists := c.Get("config"); exists {
+		if cfg, ok := cfgInterface.(*config.Config); ok {
+			return cfg
+		}
+	}
+	return config.LoadConfig()
+}
+
+// WithGeoResolver makes the given GeoIP resolver available to handlers
+func WithGeoResolver(resolver geoip.GeoResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("geo", resolver)
+		c.Next()
+	}
+}
+
+// getGeoResolver returns the resolver injected by WithGeoResolver
+// Falls back to ip-api.com
+func getGeoResolver(c *gin.Context) geoip.GeoResolver {
+	if r, exists := c.Get("geo"); exists {
+		if resolver, ok := r.(geoip.GeoResolver); ok {
+			return resolver
+		}
+	}
+	return defaultGeoResolver
+}
+
+var defaultGeoResolver = geoip.NewIPAPIResolver()
+
+// WithBotClassifier makes the given bot classifier available to handlers
+func WithBotClassifier(classifier *utils.BotClassifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("bots", classifier)
+		c.Next()
+	}
+}
+
+// getBotClassifier returns the classifier injected by WithBotClassifier
+// Falls back to the built-in patterns
+func getBotClassifier(c *gin.Context) *utils.BotClassifier {
+	if b, exists := c.Get("bots"); exists {
+		if classifier, ok := b.(*utils.BotClassifier); ok {
+			return classifier
+		}
+	}
+	return defaultBotClassifier
+}
+
+var defaultBotClassifier = utils.NewBotClassifier(nil)
+
+// WithClickQueue makes the click ingestion queue available to handlers
+func WithClickQueue(queue *ingest.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("clicks", queue)
+		c.Next()
+	}
+}
+
+// getClickQueue returns the queue injected by WithClickQueue, or nil when
+// clicks aren't recorded
+func getClickQueue(c *gin.Context) *ingest.Queue {
+	if q, exists := c.Get("clicks"); exists {
+		if queue, ok := q.(*ingest.Queue); ok {
+			return queue
+		}
+	}
+	return nil
+}
+
+// WithLiveHub makes the hub publishing recorded clicks available to handlers
+func WithLiveHub(hub *live.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("live", hub)
+		c.Next()
+	}
+}
+
+// getLiveHub returns the hub injected by WithLiveHub, or nil when live
+// stats aren't served
+func getLiveHub(c *gin.Context) *live.Hub {
+	if h, exists := c.Get("live"); exists {
+		if hub, ok := h.(*live.Hub); ok {
+			return hub
+		}
+	}
+	return nil
+}
+
 // getBaseURL returns the base URL for short links
 // Uses BASE_URL from config if set, otherwise detects from request
 func getBaseURL(c *gin.Context) string {
-	// Get config from context
-	cfgInterface, exists := c.Get("config")
-	if !exists {
-		// Fallback if config not in context
-		return getBaseURLFromRequest(c)
-	}
-	
-	cfg, ok := cfgInterface.(*config.Config)
-	if !ok || cfg.BaseURL == "" {
+	cfg := getConfig(c)
+	if cfg.BaseURL == "" {
 		// Use request-based detection
 		return getBaseURLFromRequest(c)
 	}
-	
+
 	// Use configured BASE_URL
 	return strings.TrimSuffix(cfg.BaseURL, "/")
 }
@@ -31,14 +143,10 @@ func getBaseURL(c *gin.Context) string {
 // getBaseURLFromRequest detects base URL from the HTTP request
 func getBaseURLFromRequest(c *gin.Context) string {
 	scheme := "http"
-	if c.Request.TLS != nil {
-		scheme = "https"
-	}
-	// Also check X-Forwarded-Proto header (for proxies/load balancers)
-	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "https" {
+	if isSecureRequest(c) {
 		scheme = "https"
 	}
-	
+
 	host := c.Request.Host
 	// Remove port if it's the default port
 	if strings.HasSuffix(host, ":80") && scheme == "http" {
@@ -47,7 +155,82 @@ func getBaseURLFromRequest(c *gin.Context) string {
 	if strings.HasSuffix(host, ":443") && scheme == "https" {
 		host = strings.TrimSuffix(host, ":443")
 	}
-	
+
 	return scheme + "://" + host
 }
 
+// isSecureRequest reports whether the client connected over HTTPS
+// Also checks X-Forwarded-Proto header (for proxies/load balancers)
+func isSecureRequest(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// hashLinkPassword validates and hashes an optional link password
+// Returns an empty hash when no password is given
+func hashLinkPassword(password string) (hash string, errMsg string) {
+	if password == "" {
+		return "", ""
+	}
+	if len(password) < 4 {
+		return "", "Password must be at least 4 characters"
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing link password: %v", err)
+		return "", "Failed to set password"
+	}
+	return hash, ""
+}
+
+// validateLinkOptions checks the optional settings of a new or edited link
+// and normalizes its redirect type and stats visibility
+func validateLinkOptions(url *models.URL) (errMsg string) {
+	if url.StatsVisibility == "" {
+		url.StatsVisibility = defaultStatsVisibility(url)
+	}
+	visibility, ok := models.ParseStatsVisibility(url.StatsVisibility)
+	if !ok {
+		return "stats_visibility must be public, owner or token"
+	}
+	if visibility != models.StatsPublic && url.UserID == nil {
+		return "stats_visibility must be public for links created without an account"
+	}
+	url.StatsVisibility = visibility
+
+	if url.RedirectType != "" {
+		redirectType, ok := models.ParseRedirectType(url.RedirectType)
+		if !ok {
+			return "redirect_type must be one of 301, 302, 307, 308 or html"
+		}
+		url.RedirectType = redirectType
+	}
+	if url.MaxClicks != nil && *url.MaxClicks < 1 {
+		return "max_clicks must be at least 1"
+	}
+	if url.StartsAt != nil && url.ExpiresAt != nil && !url.StartsAt.Before(*url.ExpiresAt) {
+		return "starts_at must be before expires_at"
+	}
+	if url.PrelaunchURL != "" && !utils.ValidateURL(url.PrelaunchURL) {
+		return "prelaunch_url must start with http:// or https://"
+	}
+	if url.FallbackURL != "" && !utils.ValidateURL(url.FallbackURL) {
+		return "fallback_url must start with http:// or https://"
+	}
+	return ""
+}
+
+// wantsHTML reports whether the client prefers an HTML page over JSON,
+// i.e. it is a browser rather than an API client
+func wantsHTML(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML
+}
+
+// getOptionalUserID returns the authenticated user's ID, or nil for anonymous requests
+func getOptionalUserID(c *gin.Context) *int {
+	if uid, exists := c.Get("userID"); exists {
+		if id, ok := uid.(int); ok {
+			return &id
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// stickyVariantTTL is how long a visitor keeps seeing the same variant
+	stickyVariantTTL = 30 * 24 * time.Hour
+	// maxVariantWeight bounds the weight of a single variant
+	maxVariantWeight = 1000
+)
+
+// variantIntn picks the random number behind a variant; tests seed it
+var variantIntn = rand.Intn
+
+// variantCookieName returns the cookie remembering a visitor's variant of code
+func variantCookieName(code string) string {
+	return "gourl_variant_" + code
+}
+
+// pickVariant chooses a variant of url for this visit: the one remembered in
+// the visitor's cookie for sticky links, otherwise a weighted random pick
+func pickVariant(c *gin.Context, url *models.URL) *models.Variant {
+	if url.StickyVariants {
+		if value, err := c.Cookie(variantCookieName(url.Code)); err == nil {
+			if id, err := strconv.Atoi(value); err == nil {
+				for i := range url.Variants {
+					if url.Variants[i].ID == id && url.Variants[i].Weight > 0 {
+						return &url.Variants[i]
+					}
+				}
+			}
+		}
+	}
+
+	total := 0
+	for _, v := range url.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	var picked *models.Variant
+	n := variantIntn(total)
+	for i := range url.Variants {
+		n -= url.Variants[i].Weight
+		if n < 0 {
+			picked = &url.Variants[i]
+			break
+		}
+	}
+
+	if url.StickyVariants {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(variantCookieName(url.Code), strconv.Itoa(picked.ID), int(stickyVariantTTL.Seconds()),
+			"/"+url.Code, "", isSecureRequest(c), true)
+	}
+	return picked
+}
+
+// normalizeVariants checks the variants of a link, naming unnamed ones
+// A, B, C, ... by position
+func normalizeVariants(variants []models.Variant) (errMsg string) {
+	total := 0
+	for i := range variants {
+		v := &variants[i]
+		v.Name = strings.TrimSpace(v.Name)
+		if v.Name == "" {
+			v.Name = string(rune('A' + i))
+		}
+		if !utils.ValidateURL(v.Destination) {
+			return "Variant destination must start with http:// or https://"
+		}
+		if v.Weight < 0 || v.Weight > maxVariantWeight {
+			return "Variant weight must be between 0 and " + strconv.Itoa(maxVariantWeight)
+		}
+		total += v.Weight
+	}
+	if len(variants) > 0 && total == 0 {
+		return "At least one variant must have a positive weight"
+	}
+	return ""
+}
+
+// GetURLVariants lists the A/B destinations of a URL (if user owns it)
+func GetURLVariants(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "view")
+	if !ok {
+		return
+	}
+	respondVariants(c, url)
+}
+
+// SetURLVariants replaces the weighted A/B destinations of a URL (if user
+// owns it). Visitors not routed by a targeting rule are split between the
+// variants by weight; an empty list sends everyone to the link's own URL.
+func SetURLVariants(c *gin.Context) {
+	url, _, ok := getOwnedURL(c, "edit")
+	if !ok {
+		return
+	}
+
+	var req models.SetVariantsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body (at most 20 variants)"})
+		return
+	}
+	if errMsg := normalizeVariants(req.Variants); errMsg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	if err := getStores(c).URLs.SetVariants(c.Request.Context(), url, req.Variants, req.Sticky); err != nil {
+		log.Printf("Error saving variants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save variants"})
+		return
+	}
+	respondVariants(c, url)
+}
+
+func respondVariants(c *gin.Context, url *models.URL) {
+	variants := url.Variants
+	if variants == nil {
+		variants = []models.Variant{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"variants": variants,
+		"sticky":   url.StickyVariants,
+		"count":    len(variants),
+	})
+}
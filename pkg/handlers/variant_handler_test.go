@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"gourl/pkg/models"
+)
+
+// seedVariants makes variant picks repeatable for the rest of the test
+func seedVariants(t *testing.T, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	variantIntn = rng.Intn
+	t.Cleanup(func() { variantIntn = rand.Intn })
+}
+
+// variantLink creates a link split 3:1 between two destinations
+func variantLink(t *testing.T, s *testServer, code, redirectType string, sticky bool) *models.URL {
+	t.Helper()
+	url := s.createURL(t, &models.URL{Code: code, OriginalURL: "https://example.com/default", RedirectType: redirectType})
+	variants := []models.Variant{
+		{Name: "A", Destination: "https://example.com/a", Weight: 3},
+		{Name: "B", Destination: "https://example.com/b", Weight: 1},
+	}
+	if err := s.stores.URLs.SetVariants(context.Background(), url, variants, sticky); err != nil {
+		t.Fatalf("SetVariants: %v", err)
+	}
+	return url
+}
+
+func TestNormalizeVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []models.Variant
+		errMsg   string
+	}{
+		{"none", nil, ""},
+		{"weighted", []models.Variant{{Destination: "https://a.example", Weight: 1}, {Destination: "https://b.example", Weight: 0}}, ""},
+		{"all zero", []models.Variant{{Destination: "https://a.example"}, {Destination: "https://b.example"}}, "At least one variant must have a positive weight"},
+		{"negative", []models.Variant{{Destination: "https://a.example", Weight: 2}, {Destination: "https://b.example", Weight: -1}}, "Variant weight must be between 0 and 1000"},
+		{"too heavy", []models.Variant{{Destination: "https://a.example", Weight: 1001}}, "Variant weight must be between 0 and 1000"},
+		{"bad destination", []models.Variant{{Destination: "ftp://a.example", Weight: 1}}, "Variant destination must start with http:// or https://"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeVariants(tt.variants); got != tt.errMsg {
+				t.Errorf("normalizeVariants = %q, want %q", got, tt.errMsg)
+			}
+		})
+	}
+
+	variants := []models.Variant{{Name: "  Blue ", Destination: "https://a.example", Weight: 1}, {Destination: "https://b.example", Weight: 1}}
+	if errMsg := normalizeVariants(variants); errMsg != "" || variants[0].Name != "Blue" || variants[1].Name != "B" {
+		t.Errorf("names = %q, %q (%s)", variants[0].Name, variants[1].Name, errMsg)
+	}
+}
+
+func TestSetURLVariantsValidation(t *testing.T) {
+	s := newTestServer(t)
+	owner := s.register(t, "ann")
+	s.createURL(t, &models.URL{Code: "split", OriginalURL: "https://example.com", UserID: &owner.User.ID})
+
+	body := map[string]interface{}{"variants": []map[string]interface{}{{"destination": "https://a.example", "weight": 0}}}
+	if w := s.do("PUT", "/api/urls/split/variants", body, owner.Token); w.Code != http.StatusBadRequest {
+		t.Errorf("zero weights = %d, want 400", w.Code)
+	}
+	body = map[string]interface{}{"variants": []map[string]interface{}{{"destination": "https://a.example", "weight": 1}}, "sticky": true}
+	w := s.do("PUT", "/api/urls/split/variants", body, owner.Token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set = %d %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Variants []models.Variant `json:"variants"`
+		Sticky   bool             `json:"sticky"`
+	}
+	decode(t, w, &resp)
+	if len(resp.Variants) != 1 || resp.Variants[0].Name != "A" || !resp.Sticky {
+		t.Errorf("variants = %+v", resp)
+	}
+
+	// An empty list removes the split
+	w = s.do("PUT", "/api/urls/split/variants", map[string]interface{}{"variants": []interface{}{}}, owner.Token)
+	decode(t, w, &resp)
+	if w.Code != http.StatusOK || len(resp.Variants) != 0 {
+		t.Errorf("clear = %d %s", w.Code, w.Body)
+	}
+	if w := s.do("GET", "/split", nil, ""); w.Header().Get("Location") != "https://example.com" {
+		t.Errorf("redirect without variants = %q", w.Header().Get("Location"))
+	}
+}
+
+func TestVariantDistribution(t *testing.T) {
+	s := newTestServer(t)
+	seedVariants(t, 1)
+	variantLink(t, s, "split", models.RedirectPermanent, false)
+
+	counts := map[string]int{}
+	const visits = 4000
+	for i := 0; i < visits; i++ {
+		w := s.do("GET", "/split", nil, "")
+		// A/B redirects must not be cached, so the permanent type is downgraded
+		if w.Code != http.StatusFound || w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("redirect = %d, Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+		}
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Fatal("non-sticky split set a cookie")
+		}
+		counts[w.Header().Get("Location")]++
+	}
+
+	// 3:1 split, within a few percent
+	a := counts["https://example.com/a"]
+	if len(counts) != 2 || a < visits*70/100 || a > visits*80/100 {
+		t.Errorf("counts = %v, want about 75%% to a", counts)
+	}
+}
+
+func TestVariantKeepBodyRedirect(t *testing.T) {
+	s := newTestServer(t)
+	variantLink(t, s, "split", models.RedirectPermanentKeepBody, false)
+
+	w := s.do("GET", "/split", nil, "")
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("redirect = %d, Cache-Control %q, want 307 no-store", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestStickyVariants(t *testing.T) {
+	s := newTestServer(t)
+	seedVariants(t, 1)
+	url := variantLink(t, s, "sticky", models.RedirectPermanent, true)
+	a, b := url.Variants[0], url.Variants[1]
+	cookie := variantCookieName("sticky")
+
+	visit := func(cookieValue string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/sticky", nil)
+		req.Header.Set("User-Agent", browserUA)
+		if cookieValue != "" {
+			req.AddCookie(&http.Cookie{Name: cookie, Value: cookieValue})
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A remembered variant is honoured however unlikely it is
+	for i := 0; i < 20; i++ {
+		w := visit(strconv.Itoa(b.ID))
+		if w.Header().Get("Location") != b.Destination {
+			t.Fatalf("visit with cookie %d went to %q", b.ID, w.Header().Get("Location"))
+		}
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Errorf("cookie set again: %q", w.Header().Get("Set-Cookie"))
+		}
+	}
+
+	// A first visit picks one and remembers it for this link
+	w := visit("")
+	picked := w.Result().Cookies()
+	if len(picked) != 1 || picked[0].Name != cookie || picked[0].Path != "/sticky" || !picked[0].HttpOnly {
+		t.Fatalf("cookies = %+v", picked)
+	}
+	if id := strconv.Itoa(map[string]int{a.Destination: a.ID, b.Destination: b.ID}[w.Header().Get("Location")]); picked[0].Value != id {
+		t.Errorf("cookie = %q for %q", picked[0].Value, w.Header().Get("Location"))
+	}
+
+	// Variants that are gone or garbage are picked again
+	for _, stale := range []string{"999", "junk"} {
+		w := visit(stale)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || (cookies[0].Value != strconv.Itoa(a.ID) && cookies[0].Value != strconv.Itoa(b.ID)) {
+			t.Errorf("cookie %q: new cookies = %+v", stale, cookies)
+		}
+	}
+
+	// A variant whose weight dropped to 0 no longer gets sticky visitors
+	variants := []models.Variant{
+		{Name: "A", Destination: "https://example.com/a", Weight: 1},
+		{Name: "B", Destination: "https://example.com/b", Weight: 0},
+	}
+	if err := s.stores.URLs.SetVariants(context.Background(), url, variants, true); err != nil {
+		t.Fatalf("SetVariants: %v", err)
+	}
+	for _, old := range []int{a.ID, b.ID, url.Variants[1].ID} {
+		if w := visit(strconv.Itoa(old)); w.Header().Get("Location") != "https://example.com/a" {
+			t.Errorf("cookie %d went to %q", old, w.Header().Get("Location"))
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package ingest records click events off the redirect path. Handlers hand
+// clicks to a bounded Queue; a pool of workers resolves their location,
+// parses their user agent, anonymizes them in privacy mode, writes them to
+// the ClickStore in batched transactions and publishes them to live viewers.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gourl/pkg/geoip"
+	"gourl/pkg/live"
+	"gourl/pkg/models"
+	"gourl/pkg/privacy"
+	"gourl/pkg/store"
+	"gourl/pkg/utils"
+)
+
+// DropPolicy decides what Enqueue does when the queue is full
+type DropPolicy string
+
+const (
+	// DropBlock waits up to Options.BlockTimeout for room, then drops the new click
+	DropBlock DropPolicy = "block"
+	// DropNewest drops the new click immediately
+	DropNewest DropPolicy = "drop_newest"
+	// DropOldest discards the oldest queued click to make room for the new one
+	DropOldest DropPolicy = "drop_oldest"
+)
+
+// ParseDropPolicy returns the policy called name, or false if there is none
+func ParseDropPolicy(name string) (DropPolicy, bool) {
+	switch policy := DropPolicy(name); policy {
+	case DropBlock, DropNewest, DropOldest:
+		return policy, true
+	}
+	return "", false
+}
+
+// Options configures a Queue. Zero values are replaced by the defaults.
+type Options struct {
+	Size          int           // Clicks that can wait to be written (default 10000)
+	Workers       int           // Concurrent writers (default 4)
+	BatchSize     int           // Clicks per transaction (default 100)
+	FlushInterval time.Duration // Longest a partial batch waits (default 500ms)
+	Policy        DropPolicy    // Behaviour when full (default DropBlock)
+	BlockTimeout  time.Duration // How long DropBlock waits (default 50ms)
+
+	// Sync writes each click in Enqueue instead of queueing it, for
+	// serverless functions that may be frozen as soon as they respond. No
+	// workers are started and the other settings are ignored.
+	Sync bool
+
+	// Anonymizer, if set, strips identifying data from clicks once they are
+	// located and parsed (privacy mode)
+	Anonymizer *privacy.Anonymizer
+
+	// Live, if set, receives every click once it is recorded
+	Live *live.Hub
+}
+
+func (o *Options) setDefaults() {
+	if o.Size <= 0 {
+		o.Size = 10000
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = 500 * time.Millisecond
+	}
+	if o.Policy == "" {
+		o.Policy = DropBlock
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = 50 * time.Millisecond
+	}
+}
+
+// Stats are the counters of a Queue since it was created
+type Stats struct {
+	Queued   int    `json:"queued"`   // Waiting to be written
+	Capacity int    `json:"capacity"` // Queue size
+	Accepted uint64 `json:"accepted"` // Clicks passed to Enqueue and queued
+	Dropped  uint64 `json:"dropped"`  // Clicks lost because the queue was full or closed
+	Recorded uint64 `json:"recorded"` // Clicks written to the store
+	Failed   uint64 `json:"failed"`   // Clicks lost because the store returned an error
+	Batches  uint64 `json:"batches"`  // Transactions committed
+}
+
+// ErrClosed is returned by Close when called more than once
+var ErrClosed = errors.New("ingest: queue closed")
+
+// Queue is a bounded buffer of clicks drained by a pool of workers
+type Queue struct {
+	clicks store.ClickStore
+	geo    geoip.GeoResolver
+	opts   Options
+	ch     chan models.Click
+
+	mu     sync.RWMutex // guards closed; held for reading while sending on ch
+	closed bool
+	done   sync.WaitGroup
+
+	accepted, dropped, recorded, failed, batches atomic.Uint64
+}
+
+// New starts a queue writing to clicks. Clicks without a country are
+// located with geo (which may be nil) before they are stored.
+func New(clicks store.ClickStore, geo geoip.GeoResolver, opts Options) *Queue {
+	opts.setDefaults()
+	q := &Queue{
+		clicks: clicks,
+		geo:    geo,
+		opts:   opts,
+		ch:     make(chan models.Click, opts.Size),
+	}
+	if opts.Sync {
+		return q
+	}
+	q.done.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue hands a click to the workers. It never blocks for longer than
+// the configured BlockTimeout and reports whether the click was queued.
+// With Sync it writes the click before returning instead.
+func (q *Queue) Enqueue(click models.Click) bool {
+	if click.ClickedAt.IsZero() {
+		click.ClickedAt = time.Now()
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.dropped.Add(1)
+		return false
+	}
+
+	if q.opts.Sync {
+		q.accepted.Add(1)
+		q.flush([]models.Click{click})
+		return true
+	}
+
+	select {
+	case q.ch <- click:
+		q.accepted.Add(1)
+		return true
+	default:
+	}
+
+	switch q.opts.Policy {
+	case DropBlock:
+		timer := time.NewTimer(q.opts.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- click:
+			q.accepted.Add(1)
+			return true
+		case <-timer.C:
+		}
+	case DropOldest:
+		// Workers may empty the slot first, so try a few times
+		for i := 0; i < 3; i++ {
+			select {
+			case <-q.ch:
+				q.dropped.Add(1)
+			default:
+			}
+			select {
+			case q.ch <- click:
+				q.accepted.Add(1)
+				return true
+			default:
+			}
+		}
+	}
+	q.dropped.Add(1)
+	return false
+}
+
+// Stats returns the current counters
+func (q *Queue) Stats() Stats {
+	return Stats{
+		Queued:   len(q.ch),
+		Capacity: cap(q.ch),
+		Accepted: q.accepted.Load(),
+		Dropped:  q.dropped.Load(),
+		Recorded: q.recorded.Load(),
+		Failed:   q.failed.Load(),
+		Batches:  q.batches.Load(),
+	}
+}
+
+// Close stops accepting clicks and waits until the queued ones are written
+// or ctx is done, in which case the clicks still queued are lost
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrClosed
+	}
+	q.closed = true
+	close(q.ch)
+	q.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		q.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ingest: %d clicks not written: %w", len(q.ch), ctx.Err())
+	}
+}
+
+// work collects clicks into batches until the queue is closed and drained
+func (q *Queue) work() {
+	defer q.done.Done()
+
+	batch := make([]models.Click, 0, q.opts.BatchSize)
+	ticker := time.NewTicker(q.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case click, ok := <-q.ch:
+			if !ok {
+				q.flush(batch)
+				return
+			}
+			batch = append(batch, click)
+			if len(batch) >= q.opts.BatchSize {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush locates, parses, anonymizes, stores and publishes one batch. A failed batch is retried
+// click by click so a single bad row doesn't lose the others.
+func (q *Queue) flush(batch []models.Click) {
+	if len(batch) == 0 {
+		return
+	}
+	for i := range batch {
+		if batch[i].Country == "" {
+			SetLocation(&batch[i], geoip.Locate(q.geo, batch[i].IPAddress))
+		}
+		if batch[i].Device == "" {
+			SetUserAgent(&batch[i], utils.ParseUserAgent(batch[i].UserAgent))
+		}
+	}
+
+	ctx := context.Background()
+	if q.opts.Anonymizer != nil {
+		for i := range batch {
+			if err := q.opts.Anonymizer.Anonymize(ctx, &batch[i]); err != nil {
+				log.Printf("Error loading visitor salt: %v", err)
+			}
+		}
+	}
+
+	err := q.clicks.RecordBatch(ctx, batch)
+	if err == nil {
+		q.recorded.Add(uint64(len(batch)))
+		q.batches.Add(1)
+		for i := range batch {
+			q.publish(&batch[i])
+		}
+		return
+	}
+
+	log.Printf("Error logging %d clicks, retrying one by one: %v", len(batch), err)
+	for i := range batch {
+		if err := q.clicks.Record(ctx, &batch[i]); err != nil {
+			log.Printf("Error logging click: %v", err)
+			q.failed.Add(1)
+			continue
+		}
+		q.recorded.Add(1)
+		q.publish(&batch[i])
+	}
+}
+
+// publish hands a recorded click to the live hub, if there is one
+func (q *Queue) publish(click *models.Click) {
+	if q.opts.Live != nil {
+		q.opts.Live.Publish(click)
+	}
+}
+
+// SetLocation copies the GeoIP details stored with a click
+func SetLocation(click *models.Click, loc geoip.Location) {
+	click.Country = loc.Country
+	click.Region = loc.Region
+	click.City = loc.City
+	click.ASN = int(loc.ASN)
+}
+
+// SetUserAgent copies the parsed user agent details stored with a click
+func SetUserAgent(click *models.Click, ua utils.UserAgent) {
+	click.Browser = ua.Browser
+	click.BrowserVersion = ua.BrowserVersion
+	click.OS = ua.OS
+	click.OSVersion = ua.OSVersion
+	click.Device = ua.Device
+}
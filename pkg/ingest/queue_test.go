@@ -0,0 +1,198 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/store"
+)
+
+// fakeClicks records the URL IDs of the clicks written to it. When release
+// is set, writes wait for it to be closed.
+type fakeClicks struct {
+	store.ClickStore
+
+	started chan struct{} // Receives a value whenever a write starts
+	release chan struct{}
+
+	mu       sync.Mutex
+	recorded []int
+}
+
+func newFakeClicks(blocking bool) *fakeClicks {
+	f := &fakeClicks{started: make(chan struct{}, 100)}
+	if blocking {
+		f.release = make(chan struct{})
+	}
+	return f
+}
+
+func (f *fakeClicks) RecordBatch(ctx context.Context, clicks []models.Click) error {
+	f.started <- struct{}{}
+	if f.release != nil {
+		<-f.release
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, click := range clicks {
+		f.recorded = append(f.recorded, click.URLID)
+	}
+	return nil
+}
+
+func (f *fakeClicks) Record(ctx context.Context, click *models.Click) error {
+	return f.RecordBatch(ctx, []models.Click{*click})
+}
+
+func (f *fakeClicks) urlIDs() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.recorded...)
+}
+
+// fullQueue returns a queue of two clicks whose only worker is stuck
+// writing click 1 while clicks 2 and 3 wait
+func fullQueue(t *testing.T, policy DropPolicy, blockTimeout time.Duration) (*Queue, *fakeClicks) {
+	t.Helper()
+	clicks := newFakeClicks(true)
+	q := New(clicks, nil, Options{Size: 2, Workers: 1, BatchSize: 1, Policy: policy, BlockTimeout: blockTimeout})
+
+	if !q.Enqueue(models.Click{URLID: 1}) {
+		t.Fatal("click 1 dropped")
+	}
+	<-clicks.started
+	for id := 2; id <= 3; id++ {
+		if !q.Enqueue(models.Click{URLID: id}) {
+			t.Fatalf("click %d dropped", id)
+		}
+	}
+	return q, clicks
+}
+
+// drain lets the writes through and closes q
+func drain(t *testing.T, q *Queue, clicks *fakeClicks) {
+	t.Helper()
+	close(clicks.release)
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestDropPolicies(t *testing.T) {
+	tests := []struct {
+		policy   DropPolicy
+		queued   bool
+		recorded []int
+	}{
+		{DropNewest, false, []int{1, 2, 3}},
+		{DropOldest, true, []int{1, 3, 4}},
+		{DropBlock, false, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			q, clicks := fullQueue(t, tt.policy, 20*time.Millisecond)
+
+			start := time.Now()
+			if got := q.Enqueue(models.Click{URLID: 4}); got != tt.queued {
+				t.Errorf("Enqueue on a full queue = %v, want %v", got, tt.queued)
+			}
+			if waited := time.Since(start); tt.policy == DropBlock && waited < 20*time.Millisecond {
+				t.Errorf("block policy gave up after %s", waited)
+			}
+
+			drain(t, q, clicks)
+			if got := clicks.urlIDs(); !reflect.DeepEqual(got, tt.recorded) {
+				t.Errorf("recorded %v, want %v", got, tt.recorded)
+			}
+			if stats := q.Stats(); stats.Dropped != 1 || stats.Recorded != 3 {
+				t.Errorf("stats = %+v, want 1 dropped and 3 recorded", stats)
+			}
+		})
+	}
+}
+
+func TestDropBlockWaitsForRoom(t *testing.T) {
+	q, clicks := fullQueue(t, DropBlock, time.Minute)
+
+	// Finishing click 1 frees a slot while Enqueue waits
+	time.AfterFunc(10*time.Millisecond, func() { close(clicks.release) })
+	if !q.Enqueue(models.Click{URLID: 4}) {
+		t.Error("click dropped although room was made")
+	}
+
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := clicks.urlIDs(); !reflect.DeepEqual(got, []int{1, 2, 3, 4}) {
+		t.Errorf("recorded %v, want all four clicks", got)
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	clicks := newFakeClicks(false)
+	q := New(clicks, nil, Options{Workers: 1, FlushInterval: time.Hour})
+
+	for id := 1; id <= 5; id++ {
+		q.Enqueue(models.Click{URLID: id})
+	}
+	if got := clicks.urlIDs(); len(got) != 0 {
+		t.Fatalf("recorded %v before the batch was full", got)
+	}
+
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := clicks.urlIDs(); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("recorded %v, want the five queued clicks", got)
+	}
+	if stats := q.Stats(); stats.Batches != 1 || stats.Queued != 0 {
+		t.Errorf("stats = %+v, want one batch and nothing queued", stats)
+	}
+
+	if q.Enqueue(models.Click{URLID: 6}) {
+		t.Error("click queued after Close")
+	}
+	if err := q.Close(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestCloseGivesUp(t *testing.T) {
+	q, clicks := fullQueue(t, DropNewest, 0)
+	defer close(clicks.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close with a stuck store = %v, want a deadline error", err)
+	}
+}
+
+func TestSyncWritesBeforeReturning(t *testing.T) {
+	clicks := newFakeClicks(false)
+	q := New(clicks, nil, Options{Sync: true, FlushInterval: time.Hour})
+
+	for id := 1; id <= 3; id++ {
+		if !q.Enqueue(models.Click{URLID: id}) {
+			t.Fatalf("click %d not accepted", id)
+		}
+		if got := clicks.urlIDs(); len(got) != id || got[id-1] != id {
+			t.Fatalf("after Enqueue(%d) recorded %v", id, got)
+		}
+	}
+	if stats := q.Stats(); stats.Accepted != 3 || stats.Recorded != 3 || stats.Batches != 3 || stats.Queued != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if q.Enqueue(models.Click{URLID: 4}) || len(clicks.urlIDs()) != 3 {
+		t.Error("click written after Close")
+	}
+}
@@ -0,0 +1,150 @@
+// Package live fans recorded clicks out to the clients watching a link in
+// real time. The hub is in-process: clients only see the clicks recorded by
+// the instance they are connected to.
+package live
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+// Event is what a live client learns about a click
+type Event struct {
+	Time     time.Time `json:"time"`
+	Country  string    `json:"country,omitempty"`
+	Referrer string    `json:"referrer,omitempty"`
+	Device   string    `json:"device,omitempty"` // mobile, tablet, desktop or bot
+}
+
+// subscriptionBuffer is how many events a slow client can fall behind
+// before further events are dropped for it
+const subscriptionBuffer = 64
+
+var (
+	// ErrTooManyClients is returned by Subscribe when a client limit is reached
+	ErrTooManyClients = errors.New("live: too many clients")
+	// ErrClosed is returned by Subscribe once the hub is closed
+	ErrClosed = errors.New("live: hub closed")
+)
+
+// Hub delivers the clicks of each link to its subscribers
+type Hub struct {
+	maxClients, maxPerLink int
+
+	mu     sync.RWMutex
+	subs   map[int]map[*Subscription]struct{} // by URL ID
+	count  int
+	closed bool
+}
+
+// NewHub returns a hub accepting at most maxClients subscriptions, and at
+// most maxPerLink for a single link. Limits of 0 or less are unlimited.
+func NewHub(maxClients, maxPerLink int) *Hub {
+	return &Hub{
+		maxClients: maxClients,
+		maxPerLink: maxPerLink,
+		subs:       make(map[int]map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives the events of one link until it is closed
+type Subscription struct {
+	hub    *Hub
+	urlID  int
+	events chan Event
+	once   sync.Once
+}
+
+// Events returns the channel of the subscription's events. It is closed
+// when the subscription or the hub is.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unsubscribes. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// Subscribe starts receiving the clicks of a link
+func (h *Hub) Subscribe(urlID int) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if (h.maxClients > 0 && h.count >= h.maxClients) || (h.maxPerLink > 0 && len(h.subs[urlID]) >= h.maxPerLink) {
+		return nil, ErrTooManyClients
+	}
+
+	s := &Subscription{hub: h, urlID: urlID, events: make(chan Event, subscriptionBuffer)}
+	if h.subs[urlID] == nil {
+		h.subs[urlID] = make(map[*Subscription]struct{})
+	}
+	h.subs[urlID][s] = struct{}{}
+	h.count++
+	return s, nil
+}
+
+// remove drops a subscription and closes its channel. h.mu must be held.
+func (h *Hub) remove(s *Subscription) {
+	s.once.Do(func() {
+		delete(h.subs[s.urlID], s)
+		if len(h.subs[s.urlID]) == 0 {
+			delete(h.subs, s.urlID)
+		}
+		h.count--
+		close(s.events)
+	})
+}
+
+// Publish sends a click to the subscribers of its link. It never blocks:
+// subscribers that fell behind miss the event.
+func (h *Hub) Publish(click *models.Click) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	subs := h.subs[click.URLID]
+	if len(subs) == 0 {
+		return
+	}
+	event := Event{
+		Time:     click.ClickedAt.UTC(),
+		Country:  click.Country,
+		Referrer: click.Referrer,
+		Device:   click.Device,
+	}
+	for s := range subs {
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// Clients returns the number of open subscriptions
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.count
+}
+
+// Close ends every subscription and refuses new ones, so streaming
+// responses finish before the server shuts down
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subs {
+		for s := range subs {
+			h.remove(s)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package live
+
+import (
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+func TestHubClientLimits(t *testing.T) {
+	h := NewHub(3, 2)
+
+	a1, err := h.Subscribe(1)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := h.Subscribe(1); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := h.Subscribe(1); err != ErrTooManyClients {
+		t.Errorf("third client of a link = %v, want ErrTooManyClients", err)
+	}
+	if _, err := h.Subscribe(2); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := h.Subscribe(3); err != ErrTooManyClients {
+		t.Errorf("fourth client = %v, want ErrTooManyClients", err)
+	}
+
+	// Unsubscribing frees the slot
+	a1.Close()
+	a1.Close()
+	if n := h.Clients(); n != 2 {
+		t.Errorf("clients = %d, want 2", n)
+	}
+	if _, open := <-a1.Events(); open {
+		t.Error("events of a closed subscription still open")
+	}
+	if _, err := h.Subscribe(1); err != nil {
+		t.Errorf("Subscribe after a client left: %v", err)
+	}
+
+	unlimited := NewHub(0, 0)
+	for i := 0; i < 50; i++ {
+		if _, err := unlimited.Subscribe(1); err != nil {
+			t.Fatalf("Subscribe #%d without limits: %v", i, err)
+		}
+	}
+}
+
+func TestHubPublish(t *testing.T) {
+	h := NewHub(0, 0)
+	watcher, _ := h.Subscribe(1)
+	other, _ := h.Subscribe(2)
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	h.Publish(&models.Click{URLID: 1, ClickedAt: at, Country: "Germany", Referrer: "https://example.com", Device: "mobile", IPAddress: "192.0.2.1"})
+
+	select {
+	case event := <-watcher.Events():
+		want := Event{Time: at.UTC(), Country: "Germany", Referrer: "https://example.com", Device: "mobile"}
+		if event != want {
+			t.Errorf("event = %+v, want %+v", event, want)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+	select {
+	case event := <-other.Events():
+		t.Errorf("subscriber of another link got %+v", event)
+	default:
+	}
+}
+
+func TestHubPublishSlowSubscriber(t *testing.T) {
+	h := NewHub(0, 0)
+	slow, _ := h.Subscribe(1)
+
+	// Nobody reads the events; Publish must drop them rather than wait
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriptionBuffer*3; i++ {
+			h.Publish(&models.Click{URLID: 1})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow subscriber")
+	}
+
+	if n := len(slow.Events()); n != subscriptionBuffer {
+		t.Errorf("buffered events = %d, want %d", n, subscriptionBuffer)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub(0, 0)
+	a, _ := h.Subscribe(1)
+	b, _ := h.Subscribe(2)
+
+	h.Close()
+	for _, s := range []*Subscription{a, b} {
+		if _, open := <-s.Events(); open {
+			t.Error("subscription still open after Close")
+		}
+	}
+	if n := h.Clients(); n != 0 {
+		t.Errorf("clients = %d, want 0", n)
+	}
+	if _, err := h.Subscribe(1); err != ErrClosed {
+		t.Errorf("Subscribe after Close = %v, want ErrClosed", err)
+	}
+
+	// Clients leaving after Close and late clicks are harmless
+	a.Close()
+	h.Publish(&models.Click{URLID: 1})
+}
@@ -13,7 +13,7 @@ import (
 func CORS(allowedOrigins []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		origin := c.GetHeader("Origin")
-		
+
 		// Check if origin is allowed
 		allowed := false
 		for _, allowedOrigin := range allowedOrigins {
@@ -28,7 +28,7 @@ func CORS(allowedOrigins []string) gin.HandlerFunc {
 		}
 
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, X-Stats-Token, accept, origin, Cache-Control, X-Requested-With")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
 
 		if c.Request.Method == "OPTIONS" {
@@ -115,11 +115,83 @@ func (rl *RateLimiter) Allow(ip string) bool {
 	return false
 }
 
+// AttemptLimiter counts failed attempts per key in a fixed time window,
+// e.g. wrong passwords per link, and blocks the key once the limit is reached
+type AttemptLimiter struct {
+	attempts map[string]*attemptWindow
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+}
+
+type attemptWindow struct {
+	count int
+	start time.Time
+}
+
+// NewAttemptLimiter allows max failures per key within window
+func NewAttemptLimiter(max int, window time.Duration) *AttemptLimiter {
+	al := &AttemptLimiter{
+		attempts: make(map[string]*attemptWindow),
+		max:      max,
+		window:   window,
+	}
+
+	// Periodically drop windows that have ended
+	go func() {
+		for range time.Tick(window) {
+			al.mu.Lock()
+			now := time.Now()
+			for key, w := range al.attempts {
+				if now.Sub(w.start) > al.window {
+					delete(al.attempts, key)
+				}
+			}
+			al.mu.Unlock()
+		}
+	}()
+
+	return al
+}
+
+// Blocked reports whether key has used up its attempts in the current window
+func (al *AttemptLimiter) Blocked(key string) bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	w, exists := al.attempts[key]
+	if !exists || time.Since(w.start) > al.window {
+		return false
+	}
+	return w.count >= al.max
+}
+
+// Fail records a failed attempt for key
+func (al *AttemptLimiter) Fail(key string) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	w, exists := al.attempts[key]
+	if !exists || time.Since(w.start) > al.window {
+		al.attempts[key] = &attemptWindow{count: 1, start: time.Now()}
+		return
+	}
+	w.count++
+}
+
+// Reset clears the failures recorded for key
+func (al *AttemptLimiter) Reset(key string) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	delete(al.attempts, key)
+}
+
 // RateLimit middleware limits requests per IP
 func RateLimit(limiter *RateLimiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		ip := getClientIP(c)
-		
+
 		if !limiter.Allow(ip) {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error": "Rate limit exceeded. Please try again later.",
@@ -160,10 +232,10 @@ func ErrorHandler() gin.HandlerFunc {
 		// Check if there are any errors
 		if len(c.Errors) > 0 {
 			err := c.Errors.Last()
-			
+
 			// Log the error
 			gin.DefaultErrorWriter.Write([]byte(err.Error() + "\n"))
-			
+
 			// Return appropriate error response
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Internal server error",
@@ -179,4 +251,3 @@ func min(a, b int) int {
 	}
 	return b
 }
-
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttemptLimiter(t *testing.T) {
+	al := NewAttemptLimiter(3, time.Hour)
+
+	for i := 1; i <= 3; i++ {
+		if al.Blocked("link|10.0.0.1") {
+			t.Fatalf("blocked after %d failures, want 3", i-1)
+		}
+		al.Fail("link|10.0.0.1")
+	}
+	if !al.Blocked("link|10.0.0.1") {
+		t.Error("not blocked after 3 failures")
+	}
+	if al.Blocked("link|10.0.0.2") {
+		t.Error("another visitor is blocked")
+	}
+
+	al.Reset("link|10.0.0.1")
+	if al.Blocked("link|10.0.0.1") {
+		t.Error("blocked after Reset")
+	}
+}
+
+func TestAttemptLimiterWindow(t *testing.T) {
+	al := NewAttemptLimiter(2, 50*time.Millisecond)
+	al.Fail("key")
+	al.Fail("key")
+	if !al.Blocked("key") {
+		t.Fatal("not blocked after 2 failures")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if al.Blocked("key") {
+		t.Error("still blocked once the window ended")
+	}
+
+	// A failure after the window starts a new one
+	al.Fail("key")
+	if al.Blocked("key") {
+		t.Error("blocked after 1 failure in the new window")
+	}
+}
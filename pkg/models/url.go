@@ -1,40 +1,354 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 // URL represents a shortened URL in the database
 type URL struct {
-	ID         int       `json:"id" db:"id"`
-	Code       string    `json:"code" db:"code"`
-	OriginalURL string   `json:"original_url" db:"original_url"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
-	UserID     *int      `json:"user_id,omitempty" db:"user_id"` // Optional: for authenticated users
+	ID              int             `json:"id" db:"id"`
+	Code            string          `json:"code" db:"code"`
+	OriginalURL     string          `json:"original_url" db:"original_url"`
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	UserID          *int            `json:"user_id,omitempty" db:"user_id"` // Optional: for authenticated users
+	ExpiresAt       *time.Time      `json:"expires_at,omitempty" db:"expires_at"`
+	StartsAt        *time.Time      `json:"starts_at,omitempty" db:"starts_at"`         // Optional: not active before this time
+	PrelaunchURL    string          `json:"prelaunch_url,omitempty" db:"prelaunch_url"` // Optional: served until StartsAt
+	FallbackURL     string          `json:"fallback_url,omitempty" db:"fallback_url"`   // Optional: served once the link is unavailable
+	Disabled        bool            `json:"disabled" db:"disabled"`                     // Switched off by the owner
+	RedirectType    string          `json:"redirect_type,omitempty" db:"redirect_type"` // Empty uses the server default
+	UpdatedAt       *time.Time      `json:"updated_at,omitempty" db:"updated_at"`
+	PasswordHash    string          `json:"-" db:"password_hash"`                           // bcrypt hash; empty means no password
+	MaxClicks       *int            `json:"max_clicks,omitempty" db:"max_clicks"`           // Optional: stop redirecting after N uses
+	UseCount        int             `json:"use_count,omitempty" db:"use_count"`             // Redirects counted against MaxClicks
+	Rules           []TargetingRule `json:"rules,omitempty" db:"-"`                         // Loaded by GetByCode only
+	Variants        []Variant       `json:"variants,omitempty" db:"-"`                      // Loaded by GetByCode only
+	StickyVariants  bool            `json:"sticky_variants,omitempty" db:"sticky_variants"` // Returning visitors keep their variant
+	StatsVisibility string          `json:"stats_visibility" db:"stats_visibility"`         // Who can read the stats: public, owner or token
+}
+
+// URL lifecycle states reported by Status
+const (
+	URLStatusScheduled = "scheduled"
+	URLStatusActive    = "active"
+	URLStatusExpired   = "expired"
+	URLStatusExhausted = "exhausted"
+	URLStatusDisabled  = "disabled"
+)
+
+// Redirect types a link can use. The HTTP ones name their status code; the
+// HTML type serves a page that forwards with meta refresh and JavaScript.
+const (
+	RedirectPermanent         = "301"
+	RedirectTemporary         = "302"
+	RedirectTemporaryKeepBody = "307"
+	RedirectPermanentKeepBody = "308"
+	RedirectHTML              = "html"
+)
+
+// redirectTypeAliases maps accepted spellings to redirect types
+var redirectTypeAliases = map[string]string{
+	"301": RedirectPermanent, "permanent": RedirectPermanent,
+	"302": RedirectTemporary, "temporary": RedirectTemporary,
+	"307":  RedirectTemporaryKeepBody,
+	"308":  RedirectPermanentKeepBody,
+	"html": RedirectHTML, "meta": RedirectHTML, "meta-refresh": RedirectHTML, "js": RedirectHTML,
+}
+
+// ParseRedirectType normalizes a redirect type name such as "permanent" or "307"
+func ParseRedirectType(name string) (string, bool) {
+	redirectType, ok := redirectTypeAliases[strings.ToLower(strings.TrimSpace(name))]
+	return redirectType, ok
+}
+
+// Stats visibilities of a link
+const (
+	StatsPublic = "public" // Anyone who knows the code
+	StatsOwner  = "owner"  // The link's owner only
+	StatsToken  = "token"  // The owner and holders of a share token
+)
+
+// ParseStatsVisibility returns the visibility called name, or false if there is none
+func ParseStatsVisibility(name string) (string, bool) {
+	switch visibility := strings.ToLower(strings.TrimSpace(name)); visibility {
+	case StatsPublic, StatsOwner, StatsToken:
+		return visibility, true
+	}
+	return "", false
+}
+
+// IsScheduled reports whether the URL has a start time that is still in the future
+func (u *URL) IsScheduled(now time.Time) bool {
+	return u.StartsAt != nil && now.Before(*u.StartsAt)
+}
+
+// IsExpired reports whether the URL's expiration time has passed
+func (u *URL) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && now.After(*u.ExpiresAt)
+}
+
+// Status returns the lifecycle state of the URL at the given time
+func (u *URL) Status(now time.Time) string {
+	switch {
+	case u.Disabled:
+		return URLStatusDisabled
+	case u.IsExpired(now):
+		return URLStatusExpired
+	case u.IsScheduled(now):
+		return URLStatusScheduled
+	case u.MaxClicks != nil && u.UseCount >= *u.MaxClicks:
+		return URLStatusExhausted
+	}
+	return URLStatusActive
+}
+
+// IsPasswordProtected reports whether visitors must unlock the URL first
+func (u *URL) IsPasswordProtected() bool {
+	return u.PasswordHash != ""
+}
+
+// RemainingClicks returns how many redirects are left, or nil if the URL is unlimited
+func (u *URL) RemainingClicks() *int {
+	if u.MaxClicks == nil {
+		return nil
+	}
+	remaining := *u.MaxClicks - u.UseCount
+	if remaining < 0 {
+		remaining = 0
+	}
+	return &remaining
+}
+
+// URLHistoryEntry records a change of a URL's destination
+type URLHistoryEntry struct {
+	ID          int       `json:"id" db:"id"`
+	URLID       int       `json:"url_id" db:"url_id"`
+	PreviousURL string    `json:"previous_url" db:"previous_url"`
+	NewURL      string    `json:"new_url" db:"new_url"`
+	ChangedBy   *int      `json:"changed_by,omitempty" db:"changed_by"`
+	ChangedAt   time.Time `json:"changed_at" db:"changed_at"`
+}
+
+// ShareToken grants read-only access to the stats of a link with token
+// visibility. Token is only set in the response creating it; afterwards
+// the token is known by its prefix.
+type ShareToken struct {
+	ID        int       `json:"id" db:"id"`
+	URLID     int       `json:"url_id" db:"url_id"`
+	Name      string    `json:"name,omitempty" db:"name"`
+	Prefix    string    `json:"prefix" db:"prefix"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	Token     string    `json:"token,omitempty" db:"-"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CreateShareTokenRequest names a new share token
+type CreateShareTokenRequest struct {
+	Name string `json:"name"` // Optional, e.g. the client it was given to
+}
+
+// TargetingRule sends visitors matching all of its conditions to
+// Destination. An empty condition matches everyone; a condition with several
+// values matches any of them.
+type TargetingRule struct {
+	ID          int      `json:"id" db:"id"`
+	URLID       int      `json:"-" db:"url_id"`
+	Position    int      `json:"position" db:"position"`
+	Devices     []string `json:"devices,omitempty" db:"devices"`     // mobile, tablet, desktop
+	OS          []string `json:"os,omitempty" db:"os"`               // ios, android, windows, macos, linux, chromeos
+	Languages   []string `json:"languages,omitempty" db:"languages"` // Accept-Language tags, e.g. "en" or "pt-br"
+	Countries   []string `json:"countries,omitempty" db:"countries"` // ISO codes ("de") or names as shown in stats
+	Destination string   `json:"destination" db:"destination"`
+}
+
+// SetRulesRequest replaces the ordered rule set of a short URL
+type SetRulesRequest struct {
+	Rules []TargetingRule `json:"rules" binding:"max=50"`
+}
+
+// Variant is one of several weighted destinations of an A/B-tested link
+type Variant struct {
+	ID          int    `json:"id" db:"id"`
+	URLID       int    `json:"-" db:"url_id"`
+	Position    int    `json:"position" db:"position"`
+	Name        string `json:"name" db:"name"`
+	Destination string `json:"destination" db:"destination"`
+	Weight      int    `json:"weight" db:"weight"` // Share of traffic relative to the other variants
+}
+
+// SetVariantsRequest replaces the weighted destinations of a short URL
+type SetVariantsRequest struct {
+	Variants []Variant `json:"variants" binding:"max=20"`
+	Sticky   bool      `json:"sticky"` // Keep returning visitors on the same variant
 }
 
 // Click represents a click/access event on a shortened URL
 type Click struct {
-	ID         int       `json:"id" db:"id"`
-	URLID      int       `json:"url_id" db:"url_id"`
-	IPAddress  string    `json:"ip_address" db:"ip_address"`
-	UserAgent  string    `json:"user_agent" db:"user_agent"`
-	Referrer   string    `json:"referrer" db:"referrer"`
-	ClickedAt  time.Time `json:"clicked_at" db:"clicked_at"`
+	ID             int       `json:"id" db:"id"`
+	URLID          int       `json:"url_id" db:"url_id"`
+	IPAddress      string    `json:"ip_address" db:"ip_address"`           // Truncated in privacy mode
+	VisitorID      string    `json:"visitor_id,omitempty" db:"visitor_id"` // Daily-rotating hash counting unique visitors in privacy mode
+	UserAgent      string    `json:"user_agent" db:"user_agent"`
+	Referrer       string    `json:"referrer" db:"referrer"`
+	Country        string    `json:"country" db:"country"`
+	Region         string    `json:"region,omitempty" db:"region"`
+	City           string    `json:"city,omitempty" db:"city"`
+	ASN            int       `json:"asn,omitempty" db:"asn"` // Autonomous system number of the visitor's network
+	Browser        string    `json:"browser,omitempty" db:"browser"`
+	BrowserVersion string    `json:"browser_version,omitempty" db:"browser_version"` // Major version
+	OS             string    `json:"os,omitempty" db:"os"`
+	OSVersion      string    `json:"os_version,omitempty" db:"os_version"`
+	Device         string    `json:"device,omitempty" db:"device"`         // mobile, tablet, desktop or bot
+	RuleID         *int      `json:"rule_id,omitempty" db:"rule_id"`       // Targeting rule that picked the destination
+	VariantID      *int      `json:"variant_id,omitempty" db:"variant_id"` // A/B variant that served the click
+	IsBot          bool      `json:"is_bot" db:"is_bot"`                   // Crawler, link preview or HEAD request
+	DoNotTrack     bool      `json:"-" db:"-"`                             // Sent DNT or Sec-GPC; not stored
+	ClickedAt      time.Time `json:"clicked_at" db:"clicked_at"`
 }
 
 // User represents an API user
 type User struct {
-	ID        int       `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Email     string    `json:"email" db:"email"`
-	PasswordHash string `json:"-" db:"password_hash"` // Never expose in JSON
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID           int       `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	Email        string    `json:"email" db:"email"`
+	PasswordHash string    `json:"-" db:"password_hash"` // Never expose in JSON
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	FallbackURL  string    `json:"fallback_url,omitempty" db:"fallback_url"` // Default for the user's unavailable links
+	TokenVersion int       `json:"-" db:"token_version"`                     // Bumped to revoke every access token
+}
+
+// RefreshToken is one link in the chain of rotating refresh tokens of a
+// login session. Each token can be exchanged once; UsedAt is set when it is.
+type RefreshToken struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	SessionID string     `json:"session_id" db:"session_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+}
+
+// API key scopes. A key can only use the routes of its scopes; routes
+// without a scope accept any key.
+const (
+	ScopeLinksRead  = "links:read"  // Read links, their settings and the account
+	ScopeLinksWrite = "links:write" // Create, edit and delete links; implies links:read
+	ScopeStatsRead  = "stats:read"  // Read stats, exports and the live stream
+)
+
+// Scopes lists every API key scope
+var Scopes = []string{ScopeLinksRead, ScopeLinksWrite, ScopeStatsRead}
+
+// APIKey is a long-lived credential of a user. Key is only set in the
+// response creating it; afterwards the key is known by its prefix.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Key        string     `json:"key,omitempty" db:"-"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// HasScope reports whether the key may use routes requiring scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || (s == ScopeLinksWrite && scope == ScopeLinksRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the key's expiration time has passed
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && now.After(*k.ExpiresAt)
+}
+
+// CreateAPIKeyRequest describes a new API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes,omitempty"`     // Optional: defaults to every scope
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Optional: the key stops working after this time
 }
 
 // CreateURLRequest represents the request body for creating a short URL
 type CreateURLRequest struct {
 	URL        string     `json:"url" binding:"required"`
-	CustomCode string     `json:"custom_code,omitempty"` // Optional custom alias
-	ExpiresAt  *time.Time `json:"expires_at,omitempty"`  // Optional expiration date
+	CustomCode string     `json:"custom_code,omitempty"`      // Optional custom alias
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`       // Optional expiration date
+	StartsAt   *time.Time `json:"starts_at,omitempty"`        // Optional activation date
+	Prelaunch  string     `json:"prelaunch_url,omitempty"`    // Optional URL served before StartsAt
+	Fallback   string     `json:"fallback_url,omitempty"`     // Optional URL served once the link is unavailable
+	Redirect   string     `json:"redirect_type,omitempty"`    // Optional: 301, 302, 307, 308 or html
+	Password   string     `json:"password,omitempty"`         // Optional password visitors must enter
+	MaxClicks  *int       `json:"max_clicks,omitempty"`       // Optional: link stops working after N uses
+	Stats      string     `json:"stats_visibility,omitempty"` // Optional: public, owner or token (default owner for accounts)
+}
+
+// UpdateURLRequest represents a partial update of a short URL.
+// Omitted fields are left unchanged; null clears an optional field.
+type UpdateURLRequest struct {
+	URL       *string             `json:"url,omitempty"`
+	ExpiresAt Nullable[time.Time] `json:"expires_at"`
+	StartsAt  Nullable[time.Time] `json:"starts_at"`
+	Prelaunch Nullable[string]    `json:"prelaunch_url"`
+	Fallback  Nullable[string]    `json:"fallback_url"`
+	Disabled  *bool               `json:"disabled,omitempty"`
+	Redirect  Nullable[string]    `json:"redirect_type"` // null restores the server default
+	Password  Nullable[string]    `json:"password"`      // null or "" removes the password
+	MaxClicks Nullable[int]       `json:"max_clicks"`    // null removes the limit
+	Stats     *string             `json:"stats_visibility,omitempty"`
+}
+
+// IsEmpty reports whether the request changes nothing
+func (r UpdateURLRequest) IsEmpty() bool {
+	return r.URL == nil && r.Disabled == nil && !r.ExpiresAt.Set && !r.StartsAt.Set &&
+		!r.Prelaunch.Set && !r.Fallback.Set && !r.Redirect.Set && !r.Password.Set && !r.MaxClicks.Set && r.Stats == nil
+}
+
+// Nullable distinguishes a JSON field that was omitted (Set is false) from
+// one that was explicitly null (Set is true, Value is nil)
+type Nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+// ValueOrZero returns the value, or the zero value of T when it is null
+func (n Nullable[T]) ValueOrZero() T {
+	var zero T
+	if n.Value == nil {
+		return zero
+	}
+	return *n.Value
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	n.Value = &v
+	return nil
+}
+
+// UserURL is a URL as listed to its owner, with its current lifecycle state
+type UserURL struct {
+	URL
+	Status string `json:"status"`
 }
 
 // BulkCreateURLRequest represents bulk URL creation
@@ -50,32 +364,94 @@ type BulkCreateURLResponse struct {
 
 // CreateURLResponse represents the response after creating a short URL
 type CreateURLResponse struct {
-	ShortURL   string    `json:"short_url"`
-	OriginalURL string   `json:"original_url"`
-	Code       string    `json:"code"`
-	CreatedAt  time.Time `json:"created_at"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Code        string     `json:"code"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartsAt    *time.Time `json:"starts_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
 }
 
 // StatsResponse represents analytics data for a short URL
 type StatsResponse struct {
-	Code        string    `json:"code"`
-	OriginalURL string    `json:"original_url"`
-	CreatedAt   time.Time `json:"created_at"`
-	TotalClicks int       `json:"total_clicks"`
-	UniqueIPs   int       `json:"unique_ips"`
+	Code            string    `json:"code"`
+	OriginalURL     string    `json:"original_url"`
+	CreatedAt       time.Time `json:"created_at"`
+	Traffic         string    `json:"traffic"`      // Traffic the other counts cover: human, bot or all
+	TotalClicks     int       `json:"total_clicks"` // Clicks of the selected traffic
+	HumanClicks     int       `json:"human_clicks"`
+	BotClicks       int       `json:"bot_clicks"` // Crawlers, link previews and HEAD requests
+	UniqueIPs       int       `json:"unique_ips"` // Distinct visitors (by hashed visitor ID in privacy mode)
+	MaxClicks       *int      `json:"max_clicks,omitempty"`
+	RemainingClicks *int      `json:"remaining_clicks,omitempty"`
 }
 
 // EnhancedStatsResponse includes time-based analytics
 type EnhancedStatsResponse struct {
-	Code            string            `json:"code"`
-	OriginalURL     string            `json:"original_url"`
-	CreatedAt       time.Time         `json:"created_at"`
-	TotalClicks     int               `json:"total_clicks"`
-	UniqueIPs       int               `json:"unique_ips"`
-	ClicksByDay     map[string]int    `json:"clicks_by_day"`     // Date -> count
-	TopReferrers     []ReferrerStat    `json:"top_referrers"`      // Top 10 referrers
-	UserAgents       map[string]int    `json:"user_agents"`        // Browser/device breakdown
-	Countries        map[string]int    `json:"countries"`          // Country -> count
+	Code             string         `json:"code"`
+	OriginalURL      string         `json:"original_url"`
+	CreatedAt        time.Time      `json:"created_at"`
+	Traffic          string         `json:"traffic"`      // Traffic the other counts cover: human, bot or all
+	TotalClicks      int            `json:"total_clicks"` // Clicks of the selected traffic
+	HumanClicks      int            `json:"human_clicks"`
+	BotClicks        int            `json:"bot_clicks"`         // Crawlers, link previews and HEAD requests
+	UniqueIPs        int            `json:"unique_ips"`         // Distinct visitors (by hashed visitor ID in privacy mode)
+	ClicksByDay      map[string]int `json:"clicks_by_day"`      // Date -> count for the last 30 days (UTC); see Series
+	Range            StatsRange     `json:"range"`              // Period the series and breakdowns cover
+	Series           []SeriesPoint  `json:"series"`             // Clicks per period bucket, oldest first
+	TopReferrers     []ReferrerStat `json:"top_referrers"`      // Top 10 referrers
+	UserAgents       map[string]int `json:"user_agents"`        // Same as Browsers (kept for older clients)
+	Browsers         map[string]int `json:"browsers"`           // Browser -> count
+	OperatingSystems map[string]int `json:"operating_systems"`  // OS -> count
+	Devices          map[string]int `json:"devices"`            // Device class -> count
+	Countries        map[string]int `json:"countries"`          // Country -> count
+	Rules            []RuleStat     `json:"rules,omitempty"`    // Clicks per targeting rule
+	Variants         []VariantStat  `json:"variants,omitempty"` // Clicks per A/B variant
+	MaxClicks        *int           `json:"max_clicks,omitempty"`
+	RemainingClicks  *int           `json:"remaining_clicks,omitempty"`
+}
+
+// Granularities of a stats time series
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week" // ISO weeks, starting Monday
+	GranularityMonth = "month"
+)
+
+// StatsRange describes the period an enhanced stats response covers
+type StatsRange struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"` // Exclusive
+	Granularity string    `json:"granularity"`
+	Timezone    string    `json:"tz"`
+	Clicks      int       `json:"clicks"` // Clicks within the range
+}
+
+// SeriesPoint is one bucket of a stats time series
+type SeriesPoint struct {
+	Start  time.Time `json:"start"` // In the requested time zone
+	Clicks int       `json:"clicks"`
+}
+
+// RuleStat counts the clicks routed by one targeting rule. RuleID is nil
+// for clicks that matched no rule and went to the link's own destination.
+type RuleStat struct {
+	RuleID      *int   `json:"rule_id"`
+	Position    *int   `json:"position,omitempty"` // nil if the rule was removed since
+	Destination string `json:"destination,omitempty"`
+	Clicks      int    `json:"clicks"`
+}
+
+// VariantStat reports the traffic of one A/B variant. Name and Destination
+// are empty if the variant was removed since.
+type VariantStat struct {
+	VariantID      int    `json:"variant_id"`
+	Name           string `json:"name,omitempty"`
+	Destination    string `json:"destination,omitempty"`
+	Weight         int    `json:"weight,omitempty"`
+	Clicks         int    `json:"clicks"`
+	UniqueVisitors int    `json:"unique_visitors"`
 }
 
 // ReferrerStat represents referrer statistics
@@ -84,16 +460,74 @@ type ReferrerStat struct {
 	Count    int    `json:"count"`
 }
 
+// OverviewResponse summarizes the traffic of all links of an account in a
+// period, compared with the period of the same length before it
+type OverviewResponse struct {
+	Traffic       string        `json:"traffic"` // human, bot or all
+	Range         StatsRange    `json:"range"`
+	PreviousRange StatsRange    `json:"previous_range"`
+	Clicks        PeriodDelta   `json:"clicks"`
+	Series        []SeriesPoint `json:"series"`
+	TopLinks      []LinkStat    `json:"top_links"`
+	TopReferrers  []ValueStat   `json:"top_referrers"`
+	TopCountries  []ValueStat   `json:"top_countries"`
+}
+
+// PeriodDelta compares a click count with the one of the previous period
+type PeriodDelta struct {
+	Current       int      `json:"current"`
+	Previous      int      `json:"previous"`
+	Change        int      `json:"change"`
+	ChangePercent *float64 `json:"change_percent"` // nil when the previous period had no clicks
+}
+
+// LinkStat reports the clicks of one link in an account overview
+type LinkStat struct {
+	Code        string      `json:"code"`
+	OriginalURL string      `json:"original_url"`
+	Clicks      PeriodDelta `json:"clicks"`
+}
+
+// ValueStat reports the clicks with one value of a breakdown, such as a
+// referrer or country
+type ValueStat struct {
+	Value  string      `json:"value"`
+	Clicks PeriodDelta `json:"clicks"`
+}
+
+// UnlockRequest carries the password for a protected short URL (form or JSON)
+type UnlockRequest struct {
+	Password string `json:"password" form:"password" binding:"required"`
+}
+
+// UpdateAccountRequest changes account-wide settings of the authenticated user
+type UpdateAccountRequest struct {
+	FallbackURL Nullable[string] `json:"fallback_url"` // null or "" removes it
+}
+
 // LoginRequest represents login credentials
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 }
 
+// TokenResponse carries a short-lived access token and the refresh token
+// that gets the next one
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until Token expires
+}
+
 // LoginResponse represents the response after successful login
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	TokenResponse
+	User User `json:"user"`
+}
+
+// RefreshRequest carries the refresh token to exchange or revoke
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
 // RegisterRequest represents registration data
@@ -0,0 +1,112 @@
+// Package privacy reduces the personal data kept about visitors: it
+// truncates IP addresses, replaces them with daily-rotating visitor hashes
+// for unique counts, honours opt-out headers and expires raw clicks.
+package privacy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+// TruncateIP zeroes the host part of an address: IPv4 addresses are cut to
+// their /24 network and IPv6 addresses to their /48. Values that aren't IP
+// addresses are dropped.
+func TruncateIP(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// OptedOut reports whether the request carries a Do Not Track or Global
+// Privacy Control signal
+func OptedOut(h http.Header) bool {
+	return strings.TrimSpace(h.Get("DNT")) == "1" || strings.TrimSpace(h.Get("Sec-GPC")) == "1"
+}
+
+// SaltStore hands out the random salt of a UTC day, creating it on first
+// use. Salts of past days are deleted so visitor hashes can't be reversed.
+type SaltStore interface {
+	VisitorSalt(ctx context.Context, day time.Time) ([]byte, error)
+}
+
+// Anonymizer strips identifying data from clicks before they are stored
+type Anonymizer struct {
+	salts SaltStore
+
+	mu   sync.Mutex
+	day  time.Time // Day of salt
+	salt []byte
+}
+
+// NewAnonymizer returns an anonymizer taking its daily salts from salts
+func NewAnonymizer(salts SaltStore) *Anonymizer {
+	return &Anonymizer{salts: salts}
+}
+
+// Anonymize sets the click's visitor ID from its full IP address and user
+// agent, then truncates the address (even when the day's salt can't be
+// loaded). Clicks of visitors who opted out keep no address, visitor ID,
+// raw user agent or location finer than the region.
+func (a *Anonymizer) Anonymize(ctx context.Context, click *models.Click) error {
+	if click.DoNotTrack {
+		click.IPAddress = ""
+		click.UserAgent = ""
+		click.City = ""
+		click.ASN = 0
+		click.VisitorID = ""
+		return nil
+	}
+
+	salt, err := a.saltFor(ctx, click.ClickedAt)
+	if err == nil {
+		click.VisitorID = VisitorID(salt, click.IPAddress, click.UserAgent)
+	}
+	click.IPAddress = TruncateIP(click.IPAddress)
+	return err
+}
+
+// saltFor returns the salt of the UTC day containing t
+func (a *Anonymizer) saltFor(ctx context.Context, t time.Time) ([]byte, error) {
+	y, m, d := t.UTC().Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.salt != nil && a.day.Equal(day) {
+		return a.salt, nil
+	}
+	salt, err := a.salts.VisitorSalt(ctx, day)
+	if err != nil {
+		return nil, err
+	}
+	// Clicks queued just before midnight may arrive after today's salt was
+	// loaded; only cache the newest day
+	if day.After(a.day) {
+		a.day, a.salt = day, salt
+	}
+	return salt, nil
+}
+
+// VisitorID hashes an address and user agent with a daily salt. The same
+// visitor gets the same ID all day and a different one the next.
+func VisitorID(salt []byte, ip, userAgent string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ip))
+	h.Write([]byte{0})
+	h.Write([]byte(userAgent))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
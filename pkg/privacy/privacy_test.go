@@ -0,0 +1,103 @@
+package privacy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		ip, want string
+	}{
+		{"203.0.113.77", "203.0.113.0"},
+		{" 10.1.2.3 ", "10.1.2.0"},
+		{"::ffff:198.51.100.9", "198.51.100.0"},
+		{"2001:db8:abcd:12:34:56:78:9a", "2001:db8:abcd::"},
+		{"2001:db8:abcd:ffff::1", "2001:db8:abcd::"},
+		{"not-an-ip", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TruncateIP(tt.ip); got != tt.want {
+			t.Errorf("TruncateIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// fakeSalts hands out a distinct salt per day and counts the lookups
+type fakeSalts struct {
+	calls int
+}
+
+func (s *fakeSalts) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	s.calls++
+	return []byte("salt-" + day.Format("2006-01-02")), nil
+}
+
+func TestAnonymizerSaltRotation(t *testing.T) {
+	salts := &fakeSalts{}
+	a := NewAnonymizer(salts)
+	ctx := context.Background()
+
+	visit := func(at string) *models.Click {
+		t.Helper()
+		clickedAt, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		click := &models.Click{IPAddress: "203.0.113.77", UserAgent: "Mozilla/5.0", ClickedAt: clickedAt}
+		if err := a.Anonymize(ctx, click); err != nil {
+			t.Fatalf("Anonymize: %v", err)
+		}
+		return click
+	}
+
+	morning := visit("2024-05-01T08:00:00Z")
+	evening := visit("2024-05-01T23:59:59Z")
+	nextDay := visit("2024-05-02T00:00:00Z")
+	late := visit("2024-05-01T23:59:00Z") // Queued before midnight
+
+	if morning.IPAddress != "203.0.113.0" {
+		t.Errorf("address = %q, want it truncated", morning.IPAddress)
+	}
+	if morning.VisitorID == "" || morning.VisitorID != evening.VisitorID {
+		t.Errorf("visitor IDs on one day = %q and %q, want equal", morning.VisitorID, evening.VisitorID)
+	}
+	if nextDay.VisitorID == morning.VisitorID {
+		t.Error("visitor ID didn't change the next day")
+	}
+	if late.VisitorID != morning.VisitorID {
+		t.Errorf("late click visitor ID = %q, want the previous day's %q", late.VisitorID, morning.VisitorID)
+	}
+
+	// The newest day stays cached after a late click
+	visit("2024-05-02T12:00:00Z")
+	if salts.calls != 3 {
+		t.Errorf("salt lookups = %d, want 3", salts.calls)
+	}
+}
+
+func TestAnonymizeOptedOut(t *testing.T) {
+	a := NewAnonymizer(&fakeSalts{})
+	click := &models.Click{
+		IPAddress:  "203.0.113.77",
+		UserAgent:  "Mozilla/5.0",
+		City:       "Berlin",
+		Country:    "Germany",
+		ASN:        64500,
+		DoNotTrack: true,
+		ClickedAt:  time.Now(),
+	}
+	if err := a.Anonymize(context.Background(), click); err != nil {
+		t.Fatalf("Anonymize: %v", err)
+	}
+	if click.IPAddress != "" || click.UserAgent != "" || click.City != "" || click.ASN != 0 || click.VisitorID != "" {
+		t.Errorf("opted-out click kept personal data: %+v", click)
+	}
+	if click.Country != "Germany" {
+		t.Errorf("country = %q, want it kept", click.Country)
+	}
+}
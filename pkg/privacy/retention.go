@@ -0,0 +1,96 @@
+package privacy
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RetentionMode is what happens to raw clicks older than the retention period
+type RetentionMode string
+
+const (
+	// RetainDelete deletes expired clicks
+	RetainDelete RetentionMode = "delete"
+	// RetainAnonymize truncates the IP address of expired clicks and clears
+	// their raw user agent, city and network
+	RetainAnonymize RetentionMode = "anonymize"
+)
+
+// ParseRetentionMode returns the mode called name, or false if there is none
+func ParseRetentionMode(name string) (RetentionMode, bool) {
+	switch mode := RetentionMode(name); mode {
+	case RetainDelete, RetainAnonymize:
+		return mode, true
+	}
+	return "", false
+}
+
+// ClickExpirer removes personal data from old raw clicks. The rollups are
+// left alone, so aggregate stats outlive the clicks they were built from.
+type ClickExpirer interface {
+	// DeleteClicksBefore deletes the clicks recorded before t
+	DeleteClicksBefore(ctx context.Context, t time.Time) (int64, error)
+	// AnonymizeClicksBefore anonymizes the clicks recorded before t that
+	// weren't anonymized yet
+	AnonymizeClicksBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// Retention limits how long raw clicks are kept
+type Retention struct {
+	Days int // Age at which clicks expire (0 keeps them forever)
+	Mode RetentionMode
+}
+
+// Cutoff returns the time before which clicks have expired. It is a UTC
+// midnight so whole days of clicks expire at once; rebuilding the rollups
+// relies on the days still holding raw clicks being complete.
+func (r Retention) Cutoff(now time.Time) time.Time {
+	y, m, d := now.UTC().AddDate(0, 0, -r.Days).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Apply expires the clicks past the retention period once and returns how
+// many were deleted or anonymized
+func (r Retention) Apply(ctx context.Context, clicks ClickExpirer) (int64, error) {
+	if r.Days <= 0 {
+		return 0, nil
+	}
+	cutoff := r.Cutoff(time.Now())
+	if r.Mode == RetainDelete {
+		return clicks.DeleteClicksBefore(ctx, cutoff)
+	}
+	return clicks.AnonymizeClicksBefore(ctx, cutoff)
+}
+
+// Run applies the retention policy now and then every interval until ctx
+// is done
+func (r Retention) Run(ctx context.Context, clicks ClickExpirer, interval time.Duration) {
+	if r.Days <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.Apply(ctx, clicks)
+		if err != nil {
+			log.Printf("Error applying click retention: %v", err)
+		} else if n > 0 {
+			log.Printf("Click retention: %s %d clicks older than %d days", r.Mode.pastTense(), n, r.Days)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pastTense describes what the mode did, for log messages
+func (m RetentionMode) pastTense() string {
+	if m == RetainDelete {
+		return "deleted"
+	}
+	return "anonymized"
+}
@@ -0,0 +1,68 @@
+package privacy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeExpirer records which expiry ran and with what cutoff
+type fakeExpirer struct {
+	deleted, anonymized time.Time
+}
+
+func (e *fakeExpirer) DeleteClicksBefore(ctx context.Context, t time.Time) (int64, error) {
+	e.deleted = t
+	return 2, nil
+}
+
+func (e *fakeExpirer) AnonymizeClicksBefore(ctx context.Context, t time.Time) (int64, error) {
+	e.anonymized = t
+	return 3, nil
+}
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2024, 5, 10, 1, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	got := Retention{Days: 30}.Cutoff(now)
+	if want := time.Date(2024, 4, 9, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("cutoff = %s, want %s", got, want)
+	}
+}
+
+func TestRetentionApply(t *testing.T) {
+	ctx := context.Background()
+	cutoff := Retention{Days: 30}.Cutoff(time.Now())
+
+	e := &fakeExpirer{}
+	if n, err := (Retention{Days: 30, Mode: RetainDelete}).Apply(ctx, e); err != nil || n != 2 {
+		t.Errorf("delete = %d, %v, want 2", n, err)
+	}
+	if !e.deleted.Equal(cutoff) || !e.anonymized.IsZero() {
+		t.Errorf("delete mode deleted before %s, anonymized before %s", e.deleted, e.anonymized)
+	}
+
+	e = &fakeExpirer{}
+	if n, err := (Retention{Days: 30, Mode: RetainAnonymize}).Apply(ctx, e); err != nil || n != 3 {
+		t.Errorf("anonymize = %d, %v, want 3", n, err)
+	}
+	if !e.anonymized.Equal(cutoff) || !e.deleted.IsZero() {
+		t.Errorf("anonymize mode deleted before %s, anonymized before %s", e.deleted, e.anonymized)
+	}
+
+	// Without a retention period clicks are kept
+	e = &fakeExpirer{}
+	if n, err := (Retention{Mode: RetainDelete}).Apply(ctx, e); err != nil || n != 0 || !e.deleted.IsZero() {
+		t.Errorf("no retention = %d, %v, deleted before %s", n, err, e.deleted)
+	}
+}
+
+func TestParseRetentionMode(t *testing.T) {
+	for _, name := range []string{"delete", "anonymize"} {
+		if mode, ok := ParseRetentionMode(name); !ok || string(mode) != name {
+			t.Errorf("ParseRetentionMode(%q) = %q, %v", name, mode, ok)
+		}
+	}
+	if _, ok := ParseRetentionMode("shred"); ok {
+		t.Error("ParseRetentionMode accepted shred")
+	}
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+// maxCachedURLs bounds the memory used by the URL cache
+const maxCachedURLs = 10000
+
+// cachedURLStore caches GetByCode lookups for the redirect path. Every write
+// made through it (Update, SetRules, SetVariants, Delete, Create) invalidates the cached entry, so
+// edits are visible immediately on this instance and within ttl on others.
+type cachedURLStore struct {
+	URLStore
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[string]cachedURL
+}
+
+type cachedURL struct {
+	url       models.URL
+	expiresAt time.Time
+}
+
+// NewCachedURLStore wraps inner with an in-process lookup cache.
+// A ttl of zero or less disables caching and returns inner unchanged.
+func NewCachedURLStore(inner URLStore, ttl time.Duration) URLStore {
+	if ttl <= 0 {
+		return inner
+	}
+	return &cachedURLStore{
+		URLStore: inner,
+		ttl:      ttl,
+		entries:  make(map[string]cachedURL),
+	}
+}
+
+func (s *cachedURLStore) GetByCode(ctx context.Context, code string) (*models.URL, error) {
+	s.mu.RLock()
+	entry, ok := s.entries[code]
+	s.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		url := entry.url
+		return &url, nil
+	}
+
+	url, err := s.URLStore.GetByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if len(s.entries) >= maxCachedURLs {
+		s.evictLocked()
+	}
+	s.entries[code] = cachedURL{url: *url, expiresAt: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+
+	return url, nil
+}
+
+func (s *cachedURLStore) Create(ctx context.Context, url *models.URL) error {
+	s.Invalidate(url.Code)
+	return s.URLStore.Create(ctx, url)
+}
+
+func (s *cachedURLStore) Update(ctx context.Context, url *models.URL, fields []URLField, editorID *int) error {
+	defer s.Invalidate(url.Code)
+	return s.URLStore.Update(ctx, url, fields, editorID)
+}
+
+func (s *cachedURLStore) ConsumeUse(ctx context.Context, url *models.URL) (bool, error) {
+	// The use count changes on every call, so don't serve it from cache
+	defer s.Invalidate(url.Code)
+	return s.URLStore.ConsumeUse(ctx, url)
+}
+
+func (s *cachedURLStore) SetRules(ctx context.Context, url *models.URL, rules []models.TargetingRule) error {
+	defer s.Invalidate(url.Code)
+	return s.URLStore.SetRules(ctx, url, rules)
+}
+
+func (s *cachedURLStore) SetVariants(ctx context.Context, url *models.URL, variants []models.Variant, sticky bool) error {
+	defer s.Invalidate(url.Code)
+	return s.URLStore.SetVariants(ctx, url, variants, sticky)
+}
+
+func (s *cachedURLStore) Delete(ctx context.Context, code string) error {
+	defer s.Invalidate(code)
+	return s.URLStore.Delete(ctx, code)
+}
+
+// Invalidate drops the cached entry for code
+func (s *cachedURLStore) Invalidate(code string) {
+	s.mu.Lock()
+	delete(s.entries, code)
+	s.mu.Unlock()
+}
+
+// evictLocked removes expired entries, or everything if none had expired
+func (s *cachedURLStore) evictLocked() {
+	now := time.Now()
+	for code, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, code)
+		}
+	}
+	if len(s.entries) >= maxCachedURLs {
+		s.entries = make(map[string]cachedURL)
+	}
+}
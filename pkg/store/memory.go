@@ -0,0 +1,913 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/privacy"
+)
+
+// NewMemory returns stores that keep everything in process memory.
+// Intended for tests and throwaway instances; nothing is persisted.
+func NewMemory() *Stores {
+	m := &memoryDB{
+		urls:     make(map[string]*models.URL),
+		rules:    make(map[int][]models.TargetingRule),
+		variants: make(map[int][]models.Variant),
+		users:    make(map[int]*models.User),
+		salts:    make(map[string][]byte),
+		shares:   make(map[int][]models.ShareToken),
+		apiKeys:  make(map[int]*models.APIKey),
+		refresh:  make(map[int]*models.RefreshToken),
+	}
+	return &Stores{
+		URLs:   &memoryURLStore{m},
+		Clicks: &memoryClickStore{m},
+		Users:  &memoryUserStore{m},
+	}
+}
+
+// memoryDB is the shared state behind the in-memory stores, so that deleting
+// a URL cascades to its clicks like the SQL schema does
+type memoryDB struct {
+	mu         sync.RWMutex
+	urls       map[string]*models.URL // by code
+	clicks     []models.Click
+	history    []models.URLHistoryEntry
+	rules      map[int][]models.TargetingRule // by URL ID
+	variants   map[int][]models.Variant       // by URL ID
+	users      map[int]*models.User
+	salts      map[string][]byte            // Visitor salts by UTC day
+	shares     map[int][]models.ShareToken  // by URL ID
+	apiKeys    map[int]*models.APIKey       // by ID
+	refresh    map[int]*models.RefreshToken // by ID
+	nextURLID  int
+	nextEdit   int
+	nextClick  int
+	nextRule   int
+	nextVar    int
+	nextShare  int
+	nextUserID int
+	nextAPIKey int
+	nextToken  int
+}
+
+// memoryURLStore implements URLStore
+type memoryURLStore struct {
+	m *memoryDB
+}
+
+func (s *memoryURLStore) Create(ctx context.Context, url *models.URL) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if _, exists := s.m.urls[url.Code]; exists {
+		return ErrConflict
+	}
+	if url.CreatedAt.IsZero() {
+		url.CreatedAt = time.Now()
+	}
+	s.m.nextURLID++
+	url.ID = s.m.nextURLID
+	stored := *url
+	s.m.urls[url.Code] = &stored
+	return nil
+}
+
+func (s *memoryURLStore) GetByCode(ctx context.Context, code string) (*models.URL, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	url, ok := s.m.urls[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *url
+	copied.Rules = append([]models.TargetingRule(nil), s.m.rules[url.ID]...)
+	copied.Variants = append([]models.Variant(nil), s.m.variants[url.ID]...)
+	return &copied, nil
+}
+
+func (s *memoryURLStore) CodeExists(ctx context.Context, code string) (bool, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	_, ok := s.m.urls[code]
+	return ok, nil
+}
+
+func (s *memoryURLStore) ListByUser(ctx context.Context, userID int) ([]models.URL, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	urls := []models.URL{}
+	for _, url := range s.m.urls {
+		if url.UserID != nil && *url.UserID == userID {
+			urls = append(urls, *url)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].CreatedAt.After(urls[j].CreatedAt) })
+	return urls, nil
+}
+
+func (s *memoryURLStore) Update(ctx context.Context, url *models.URL, fields []URLField, editorID *int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	stored, ok := s.m.urls[url.Code]
+	if !ok || stored.ID != url.ID {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	updated := *stored
+	for _, field := range fields {
+		switch field {
+		case FieldDestination:
+			updated.OriginalURL = url.OriginalURL
+		case FieldExpiresAt:
+			updated.ExpiresAt = url.ExpiresAt
+		case FieldStartsAt:
+			updated.StartsAt = url.StartsAt
+		case FieldPrelaunch:
+			updated.PrelaunchURL = url.PrelaunchURL
+		case FieldFallback:
+			updated.FallbackURL = url.FallbackURL
+		case FieldDisabled:
+			updated.Disabled = url.Disabled
+		case FieldRedirectType:
+			updated.RedirectType = url.RedirectType
+		case FieldPassword:
+			updated.PasswordHash = url.PasswordHash
+		case FieldMaxClicks:
+			updated.MaxClicks = url.MaxClicks
+		case FieldStats:
+			updated.StatsVisibility = url.StatsVisibility
+		default:
+			return fmt.Errorf("store: unknown URL field %q", field)
+		}
+	}
+
+	if stored.OriginalURL != updated.OriginalURL {
+		s.m.nextEdit++
+		s.m.history = append(s.m.history, models.URLHistoryEntry{
+			ID:          s.m.nextEdit,
+			URLID:       url.ID,
+			PreviousURL: stored.OriginalURL,
+			NewURL:      updated.OriginalURL,
+			ChangedBy:   editorID,
+			ChangedAt:   now,
+		})
+	}
+
+	updated.UpdatedAt = &now
+	s.m.urls[url.Code] = &updated
+	url.UpdatedAt = &now
+	return nil
+}
+
+func (s *memoryURLStore) ConsumeUse(ctx context.Context, url *models.URL) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	stored, ok := s.m.urls[url.Code]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if stored.MaxClicks != nil && stored.UseCount >= *stored.MaxClicks {
+		return false, nil
+	}
+	stored.UseCount++
+	url.UseCount = stored.UseCount
+	return true, nil
+}
+
+func (s *memoryURLStore) History(ctx context.Context, urlID int) ([]models.URLHistoryEntry, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	history := []models.URLHistoryEntry{}
+	for i := len(s.m.history) - 1; i >= 0; i-- {
+		if s.m.history[i].URLID == urlID {
+			history = append(history, s.m.history[i])
+		}
+	}
+	return history, nil
+}
+
+func (s *memoryURLStore) SetRules(ctx context.Context, url *models.URL, rules []models.TargetingRule) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if stored, ok := s.m.urls[url.Code]; !ok || stored.ID != url.ID {
+		return ErrNotFound
+	}
+	saved := make([]models.TargetingRule, len(rules))
+	for i, rule := range rules {
+		s.m.nextRule++
+		rule.ID = s.m.nextRule
+		rule.URLID = url.ID
+		rule.Position = i + 1
+		saved[i] = rule
+	}
+	s.m.rules[url.ID] = saved
+	url.Rules = append([]models.TargetingRule(nil), saved...)
+	return nil
+}
+
+func (s *memoryURLStore) SetVariants(ctx context.Context, url *models.URL, variants []models.Variant, sticky bool) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	stored, ok := s.m.urls[url.Code]
+	if !ok || stored.ID != url.ID {
+		return ErrNotFound
+	}
+	saved := make([]models.Variant, len(variants))
+	for i, v := range variants {
+		s.m.nextVar++
+		v.ID = s.m.nextVar
+		v.URLID = url.ID
+		v.Position = i + 1
+		saved[i] = v
+	}
+	s.m.variants[url.ID] = saved
+	stored.StickyVariants = sticky
+	url.Variants = append([]models.Variant(nil), saved...)
+	url.StickyVariants = sticky
+	return nil
+}
+
+func (s *memoryURLStore) Delete(ctx context.Context, code string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	url, ok := s.m.urls[code]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.m.urls, code)
+	delete(s.m.rules, url.ID)
+	delete(s.m.variants, url.ID)
+	delete(s.m.shares, url.ID)
+
+	keptHistory := s.m.history[:0]
+	for _, entry := range s.m.history {
+		if entry.URLID != url.ID {
+			keptHistory = append(keptHistory, entry)
+		}
+	}
+	s.m.history = keptHistory
+
+	kept := s.m.clicks[:0]
+	for _, click := range s.m.clicks {
+		if click.URLID != url.ID {
+			kept = append(kept, click)
+		}
+	}
+	s.m.clicks = kept
+	return nil
+}
+
+func (s *memoryURLStore) CreateShareToken(ctx context.Context, token *models.ShareToken) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, tokens := range s.m.shares {
+		for _, existing := range tokens {
+			if existing.TokenHash == token.TokenHash {
+				return ErrConflict
+			}
+		}
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	s.m.nextShare++
+	token.ID = s.m.nextShare
+	stored := *token
+	stored.Token = ""
+	s.m.shares[token.URLID] = append(s.m.shares[token.URLID], stored)
+	return nil
+}
+
+func (s *memoryURLStore) ShareTokens(ctx context.Context, urlID int) ([]models.ShareToken, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	return append([]models.ShareToken{}, s.m.shares[urlID]...), nil
+}
+
+func (s *memoryURLStore) DeleteShareToken(ctx context.Context, urlID, id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	tokens := s.m.shares[urlID]
+	for i := range tokens {
+		if tokens[i].ID == id {
+			s.m.shares[urlID] = append(tokens[:i:i], tokens[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryURLStore) HasShareToken(ctx context.Context, urlID int, tokenHash string) (bool, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, token := range s.m.shares[urlID] {
+		if token.TokenHash == tokenHash {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memoryClickStore implements ClickStore
+type memoryClickStore struct {
+	m *memoryDB
+}
+
+func (s *memoryClickStore) Record(ctx context.Context, click *models.Click) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if click.ClickedAt.IsZero() {
+		click.ClickedAt = time.Now()
+	}
+	s.m.nextClick++
+	click.ID = s.m.nextClick
+	s.m.clicks = append(s.m.clicks, *click)
+	return nil
+}
+
+func (s *memoryClickStore) RecordBatch(ctx context.Context, clicks []models.Click) error {
+	for i := range clicks {
+		if err := s.Record(ctx, &clicks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildRollups is a no-op; breakdowns are computed from the clicks directly
+func (s *memoryClickStore) RebuildRollups(ctx context.Context, urlID int) error {
+	return nil
+}
+
+// LinksMissingRollups returns nothing; there are no rollups to fill
+func (s *memoryClickStore) LinksMissingRollups(ctx context.Context) ([]int, error) {
+	return nil, nil
+}
+
+// forURL calls fn for every click of a URL while holding the read lock
+func (s *memoryClickStore) forURL(urlID int, fn func(click *models.Click)) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for i := range s.m.clicks {
+		if s.m.clicks[i].URLID == urlID {
+			fn(&s.m.clicks[i])
+		}
+	}
+}
+
+func (s *memoryClickStore) CountClicks(ctx context.Context, urlID int, traffic Traffic) (int, error) {
+	count := 0
+	s.forURL(urlID, func(click *models.Click) {
+		if traffic.matches(click.IsBot) {
+			count++
+		}
+	})
+	return count, nil
+}
+
+func (s *memoryClickStore) CountUniqueVisitors(ctx context.Context, urlID int, traffic Traffic) (int, error) {
+	visitors := make(map[string]bool)
+	s.forURL(urlID, func(click *models.Click) {
+		if key := visitorKeyOf(click); key != "" && traffic.matches(click.IsBot) {
+			visitors[key] = true
+		}
+	})
+	return len(visitors), nil
+}
+
+// visitorKeyOf identifies the visitor of a click like the SQL stores do:
+// by visitor ID where one was recorded, else by IP address
+func visitorKeyOf(click *models.Click) string {
+	if click.VisitorID != "" {
+		return click.VisitorID
+	}
+	return click.IPAddress
+}
+
+func (s *memoryClickStore) ClicksByDay(ctx context.Context, urlID int, days int, traffic Traffic) (map[string]int, error) {
+	since := time.Now().AddDate(0, 0, -days)
+	clicksByDay := make(map[string]int)
+	s.forURL(urlID, func(click *models.Click) {
+		if !click.ClickedAt.Before(since) && traffic.matches(click.IsBot) {
+			clicksByDay[click.ClickedAt.UTC().Format("2006-01-02")]++
+		}
+	})
+	return clicksByDay, nil
+}
+
+func (s *memoryClickStore) HourlyClicks(ctx context.Context, urlID int, f ClickFilter) ([]BucketCount, error) {
+	counts := make(map[time.Time]int)
+	s.forURL(urlID, func(click *models.Click) {
+		hour := click.ClickedAt.UTC().Truncate(time.Hour)
+		if inRange(hour, f.From.Truncate(time.Hour), f.To) && f.Traffic.matches(click.IsBot) {
+			counts[hour]++
+		}
+	})
+
+	buckets := make([]BucketCount, 0, len(counts))
+	for hour, n := range counts {
+		buckets = append(buckets, BucketCount{Start: hour, Clicks: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+// inRange reports whether t is in [from, to), treating zero bounds as open
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
+
+// matches reports whether click is selected by f
+func (f ClickFilter) matches(click *models.Click) bool {
+	return inRange(click.ClickedAt, f.From, f.To) && f.Traffic.matches(click.IsBot)
+}
+
+func (s *memoryClickStore) ClicksByRule(ctx context.Context, urlID int, f ClickFilter) (map[int]int, int, error) {
+	clicksByRule := make(map[int]int)
+	unmatched := 0
+	s.forURL(urlID, func(click *models.Click) {
+		switch {
+		case !f.matches(click):
+		case click.RuleID != nil:
+			clicksByRule[*click.RuleID]++
+		default:
+			unmatched++
+		}
+	})
+	return clicksByRule, unmatched, nil
+}
+
+func (s *memoryClickStore) ClicksByVariant(ctx context.Context, urlID int, f ClickFilter) (map[int]GroupCount, error) {
+	clicksByVariant := make(map[int]GroupCount)
+	visitors := make(map[int]map[string]bool)
+	s.forURL(urlID, func(click *models.Click) {
+		if click.VariantID == nil || !f.matches(click) {
+			return
+		}
+		id := *click.VariantID
+		if visitors[id] == nil {
+			visitors[id] = make(map[string]bool)
+		}
+		if key := visitorKeyOf(click); key != "" {
+			visitors[id][key] = true
+		}
+		counts := clicksByVariant[id]
+		counts.Clicks++
+		counts.Unique = len(visitors[id])
+		clicksByVariant[id] = counts
+	})
+	return clicksByVariant, nil
+}
+
+func (s *memoryClickStore) TopValues(ctx context.Context, urlID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error) {
+	counts := make(map[string]int)
+	s.forURL(urlID, func(click *models.Click) {
+		if !f.matches(click) {
+			return
+		}
+		var value string
+		switch dim {
+		case DimReferrer:
+			value = click.Referrer
+		case DimUserAgent:
+			value = click.UserAgent
+		case DimCountry:
+			value = click.Country
+		case DimBrowser, DimOS, DimDevice:
+			value = rollupValue(click, dim)
+		}
+		if value != "" {
+			counts[value]++
+		}
+	})
+	return topN(counts, limit), nil
+}
+
+// forUser calls fn for every click of the links owned by a user, along with
+// its link, while holding the read lock
+func (s *memoryClickStore) forUser(userID int, fn func(url *models.URL, click *models.Click)) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	owned := make(map[int]*models.URL)
+	for _, url := range s.m.urls {
+		if url.UserID != nil && *url.UserID == userID {
+			owned[url.ID] = url
+		}
+	}
+	for i := range s.m.clicks {
+		if url, ok := owned[s.m.clicks[i].URLID]; ok {
+			fn(url, &s.m.clicks[i])
+		}
+	}
+}
+
+func (s *memoryClickStore) UserHourlyClicks(ctx context.Context, userID int, f ClickFilter) ([]BucketCount, error) {
+	counts := make(map[time.Time]int)
+	s.forUser(userID, func(url *models.URL, click *models.Click) {
+		hour := click.ClickedAt.UTC().Truncate(time.Hour)
+		if inRange(hour, f.From.Truncate(time.Hour), f.To) && f.Traffic.matches(click.IsBot) {
+			counts[hour]++
+		}
+	})
+
+	buckets := make([]BucketCount, 0, len(counts))
+	for hour, n := range counts {
+		buckets = append(buckets, BucketCount{Start: hour, Clicks: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Start.Before(buckets[j].Start) })
+	return buckets, nil
+}
+
+func (s *memoryClickStore) UserTopLinks(ctx context.Context, userID int, f ClickFilter, limit int) ([]LinkCount, error) {
+	counts := make(map[int]*LinkCount)
+	s.forUser(userID, func(url *models.URL, click *models.Click) {
+		if !f.matches(click) {
+			return
+		}
+		if counts[url.ID] == nil {
+			counts[url.ID] = &LinkCount{URLID: url.ID, Code: url.Code, OriginalURL: url.OriginalURL}
+		}
+		counts[url.ID].Clicks++
+	})
+
+	links := make([]LinkCount, 0, len(counts))
+	for _, link := range counts {
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Clicks != links[j].Clicks {
+			return links[i].Clicks > links[j].Clicks
+		}
+		return links[i].Code < links[j].Code
+	})
+	if limit > 0 && len(links) > limit {
+		links = links[:limit]
+	}
+	return links, nil
+}
+
+func (s *memoryClickStore) UserTopValues(ctx context.Context, userID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error) {
+	if !dim.rolledUp() {
+		return nil, fmt.Errorf("dimension %q is not rolled up", dim)
+	}
+	counts := make(map[string]int)
+	s.forUser(userID, func(url *models.URL, click *models.Click) {
+		if value := rollupValue(click, dim); value != "" && f.matches(click) {
+			counts[value]++
+		}
+	})
+	return topN(counts, limit), nil
+}
+
+// EachClick copies the matching clicks first so fn runs without the lock held
+func (s *memoryClickStore) EachClick(ctx context.Context, urlID int, f ClickFilter, fn func(click *models.Click) error) error {
+	var clicks []models.Click
+	s.forURL(urlID, func(click *models.Click) {
+		if f.matches(click) {
+			clicks = append(clicks, *click)
+		}
+	})
+	sort.SliceStable(clicks, func(i, j int) bool { return clicks[i].ClickedAt.Before(clicks[j].ClickedAt) })
+	for i := range clicks {
+		if err := fn(&clicks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryClickStore) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	key := day.UTC().Format("2006-01-02")
+	salt, ok := s.m.salts[key]
+	if !ok {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		s.m.salts[key] = salt
+	}
+	expired := day.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	for d := range s.m.salts {
+		if d < expired {
+			delete(s.m.salts, d)
+		}
+	}
+	return salt, nil
+}
+
+func (s *memoryClickStore) DeleteClicksBefore(ctx context.Context, t time.Time) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	kept := s.m.clicks[:0]
+	for _, click := range s.m.clicks {
+		if !click.ClickedAt.Before(t) {
+			kept = append(kept, click)
+		}
+	}
+	deleted := int64(len(s.m.clicks) - len(kept))
+	s.m.clicks = kept
+	return deleted, nil
+}
+
+// AnonymizeClicksBefore anonymizes every click before t; doing it again is harmless
+func (s *memoryClickStore) AnonymizeClicksBefore(ctx context.Context, t time.Time) (int64, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	var n int64
+	for i := range s.m.clicks {
+		click := &s.m.clicks[i]
+		if click.ClickedAt.Before(t) {
+			click.IPAddress = privacy.TruncateIP(click.IPAddress)
+			click.UserAgent = ""
+			click.City = ""
+			click.ASN = 0
+			n++
+		}
+	}
+	return n, nil
+}
+
+// topN sorts counts descending (ties by value) and keeps the first limit entries
+func topN(counts map[string]int, limit int) []ValueCount {
+	values := make([]ValueCount, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	if limit > 0 && len(values) > limit {
+		values = values[:limit]
+	}
+	return values
+}
+
+// memoryUserStore implements UserStore
+type memoryUserStore struct {
+	m *memoryDB
+}
+
+func (s *memoryUserStore) Create(ctx context.Context, user *models.User) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, existing := range s.m.users {
+		if existing.Username == user.Username || existing.Email == user.Email {
+			return ErrConflict
+		}
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	s.m.nextUserID++
+	user.ID = s.m.nextUserID
+	stored := *user
+	s.m.users[user.ID] = &stored
+	return nil
+}
+
+func (s *memoryUserStore) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, user := range s.m.users {
+		if user.Username == username {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryUserStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	user, ok := s.m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryUserStore) SetFallbackURL(ctx context.Context, id int, fallbackURL string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, ok := s.m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.FallbackURL = fallbackURL
+	return nil
+}
+
+func (s *memoryUserStore) Exists(ctx context.Context, username, email string) (bool, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, user := range s.m.users {
+		if user.Username == username || user.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryUserStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, existing := range s.m.apiKeys {
+		if existing.KeyHash == key.KeyHash {
+			return ErrConflict
+		}
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	s.m.nextAPIKey++
+	key.ID = s.m.nextAPIKey
+	stored := *key
+	stored.Key = ""
+	s.m.apiKeys[key.ID] = &stored
+	return nil
+}
+
+func (s *memoryUserStore) APIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	keys := []models.APIKey{}
+	for _, key := range s.m.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *memoryUserStore) APIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, key := range s.m.apiKeys {
+		if key.KeyHash == keyHash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryUserStore) TouchAPIKey(ctx context.Context, id int, t time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	if key, ok := s.m.apiKeys[id]; ok {
+		key.LastUsedAt = &t
+	}
+	return nil
+}
+
+func (s *memoryUserStore) DeleteAPIKey(ctx context.Context, userID, id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	key, ok := s.m.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.m.apiKeys, id)
+	return nil
+}
+
+func (s *memoryUserStore) TokenVersion(ctx context.Context, id int) (int, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	user, ok := s.m.users[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return user.TokenVersion, nil
+}
+
+func (s *memoryUserStore) RevokeSessions(ctx context.Context, id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	user, ok := s.m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.TokenVersion++
+	for tokenID, token := range s.m.refresh {
+		if token.UserID == id {
+			delete(s.m.refresh, tokenID)
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for _, existing := range s.m.refresh {
+		if existing.TokenHash == token.TokenHash {
+			return ErrConflict
+		}
+	}
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	s.m.nextToken++
+	token.ID = s.m.nextToken
+	stored := *token
+	s.m.refresh[token.ID] = &stored
+	return nil
+}
+
+func (s *memoryUserStore) RefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, token := range s.m.refresh {
+		if token.TokenHash == tokenHash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryUserStore) UseRefreshToken(ctx context.Context, id int, t time.Time) (bool, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	token, ok := s.m.refresh[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &t
+	return true, nil
+}
+
+func (s *memoryUserStore) DeleteSession(ctx context.Context, userID int, sessionID string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for id, token := range s.m.refresh {
+		if token.UserID == userID && token.SessionID == sessionID {
+			delete(s.m.refresh, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryUserStore) DeleteExpiredRefreshTokens(ctx context.Context, userID int, t time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	for id, token := range s.m.refresh {
+		if token.UserID == userID && token.ExpiresAt.Before(t) {
+			delete(s.m.refresh, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gourl/pkg/models"
+	"gourl/pkg/utils"
+)
+
+// Dimensions parsed from the user agent. They are answered from the rollup
+// tables only.
+const (
+	DimBrowser Dimension = "browser"
+	DimOS      Dimension = "os"
+	DimDevice  Dimension = "device"
+)
+
+// dimTotal is the rollup dimension counting all clicks (with an empty value)
+const dimTotal Dimension = "total"
+
+// rolledUpDimensions are kept in the rollup tables, in the order rollup rows are written
+var rolledUpDimensions = []Dimension{DimBrowser, DimCountry, DimDevice, DimOS, DimReferrer}
+
+// rolledUp reports whether d is pre-aggregated in the rollup tables
+func (d Dimension) rolledUp() bool {
+	switch d {
+	case DimReferrer, DimCountry, DimBrowser, DimOS, DimDevice:
+		return true
+	}
+	return false
+}
+
+// rollupValue returns the value a click counts towards in a rolled-up
+// dimension. Clicks stored before user agents were parsed at ingest time
+// are parsed here.
+func rollupValue(click *models.Click, dim Dimension) string {
+	switch dim {
+	case DimReferrer:
+		return click.Referrer
+	case DimCountry:
+		return click.Country
+	}
+
+	ua := utils.UserAgent{Browser: click.Browser, OS: click.OS, Device: click.Device}
+	if ua.Device == "" {
+		ua = utils.ParseUserAgent(click.UserAgent)
+	}
+	switch dim {
+	case DimBrowser:
+		return ua.Browser
+	case DimOS:
+		return ua.OS
+	case DimDevice:
+		return ua.Device
+	}
+	return ""
+}
+
+// rollupKey identifies a row of the hourly rollup table
+type rollupKey struct {
+	urlID int
+	hour  time.Time
+	dim   Dimension
+	value string
+	bot   bool
+}
+
+// day returns the start of the UTC day containing the key's hour
+func (k rollupKey) day() time.Time {
+	y, m, d := k.hour.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// rollupCount is the number of clicks to add to one rollup row
+type rollupCount struct {
+	rollupKey
+	clicks int
+}
+
+// aggregateClicks counts clicks per hourly rollup row. Rows are sorted so
+// concurrent writers lock them in the same order.
+func aggregateClicks(clicks []models.Click) []rollupCount {
+	counts := make(map[rollupKey]int)
+	for i := range clicks {
+		click := &clicks[i]
+		hour := click.ClickedAt.UTC().Truncate(time.Hour)
+		counts[rollupKey{click.URLID, hour, dimTotal, "", click.IsBot}]++
+		for _, dim := range rolledUpDimensions {
+			counts[rollupKey{click.URLID, hour, dim, rollupValue(click, dim), click.IsBot}]++
+		}
+	}
+
+	rows := make([]rollupCount, 0, len(counts))
+	for key, n := range counts {
+		rows = append(rows, rollupCount{key, n})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case a.urlID != b.urlID:
+			return a.urlID < b.urlID
+		case a.dim != b.dim:
+			return a.dim < b.dim
+		case !a.hour.Equal(b.hour):
+			return a.hour.Before(b.hour)
+		case a.value != b.value:
+			return a.value < b.value
+		}
+		return !a.bot && b.bot
+	})
+	return rows
+}
+
+// BackfillRollups fills empty rollup tables from the recorded clicks, so
+// links clicked before the rollups existed don't show zero clicks after an
+// upgrade. It does nothing once any rollup exists and returns the number of
+// links rebuilt.
+func BackfillRollups(ctx context.Context, clicks ClickStore) (int, error) {
+	urlIDs, err := clicks.LinksMissingRollups(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, urlID := range urlIDs {
+		if err := clicks.RebuildRollups(ctx, urlID); err != nil {
+			return i, fmt.Errorf("link %d: %w", urlID, err)
+		}
+	}
+	return len(urlIDs), nil
+}
@@ -0,0 +1,1417 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gourl/pkg/database"
+	"gourl/pkg/models"
+	"gourl/pkg/privacy"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// NewSQLite returns stores backed by a SQLite database
+func NewSQLite(db *sql.DB) *Stores {
+	return NewSQL(db, database.SQLite)
+}
+
+// NewPostgres returns stores backed by a PostgreSQL database
+func NewPostgres(db *sql.DB) *Stores {
+	return NewSQL(db, database.Postgres)
+}
+
+// NewSQL returns stores backed by db, writing queries for the given dialect
+func NewSQL(db *sql.DB, dialect database.Dialect) *Stores {
+	base := sqlBase{db: db, dialect: dialect}
+	return &Stores{
+		URLs:   &sqlURLStore{base},
+		Clicks: &sqlClickStore{base},
+		Users:  &sqlUserStore{base},
+	}
+}
+
+// sqlBase holds the connection and dialect shared by the SQL stores.
+// All queries are written with ? placeholders and rebound per dialect.
+type sqlBase struct {
+	db      *sql.DB
+	dialect database.Dialect
+}
+
+func (b sqlBase) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return b.db.ExecContext(ctx, b.dialect.Rebind(query), args...)
+}
+
+func (b sqlBase) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return b.db.QueryContext(ctx, b.dialect.Rebind(query), args...)
+}
+
+func (b sqlBase) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return b.db.QueryRowContext(ctx, b.dialect.Rebind(query), args...)
+}
+
+// nullTime converts an optional timestamp into a bind value
+func (b sqlBase) nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return b.dialect.Time(*t)
+}
+
+// nullInt converts an optional integer into a bind value
+func nullInt(i *int) interface{} {
+	if i == nil {
+		return nil
+	}
+	return *i
+}
+
+// nullPositive stores zero (unknown) as NULL
+func nullPositive(i int) interface{} {
+	if i <= 0 {
+		return nil
+	}
+	return i
+}
+
+// intPtr converts a nullable integer column into an optional int
+func intPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
+
+// timePtr converts a nullable timestamp column into an optional time
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// joinList stores a list of values as comma-separated text, or NULL if empty
+func joinList(values []string) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return strings.Join(values, ",")
+}
+
+// splitList reverses joinList
+func splitList(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	return strings.Split(s.String, ",")
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// isUniqueViolation reports whether err is a unique constraint failure on either backend
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
+
+// sqlURLStore implements URLStore
+type sqlURLStore struct {
+	sqlBase
+}
+
+const urlColumns = "id, code, original_url, user_id, created_at, expires_at, starts_at, prelaunch_url, fallback_url, disabled, redirect_type, updated_at, password_hash, max_clicks, use_count, sticky_variants, stats_visibility"
+
+// scanURL reads a row selected with urlColumns
+func scanURL(row interface{ Scan(...interface{}) error }) (*models.URL, error) {
+	var url models.URL
+	var userID sql.NullInt64
+	var expiresAt, startsAt, updatedAt sql.NullTime
+	var prelaunchURL, fallbackURL, redirectType, passwordHash sql.NullString
+	var maxClicks sql.NullInt64
+	if err := row.Scan(&url.ID, &url.Code, &url.OriginalURL, &userID, &url.CreatedAt, &expiresAt, &startsAt,
+		&prelaunchURL, &fallbackURL, &url.Disabled, &redirectType, &updatedAt, &passwordHash, &maxClicks, &url.UseCount, &url.StickyVariants, &url.StatsVisibility); err != nil {
+		return nil, err
+	}
+	url.UserID = intPtr(userID)
+	url.ExpiresAt = timePtr(expiresAt)
+	url.StartsAt = timePtr(startsAt)
+	url.PrelaunchURL = prelaunchURL.String
+	url.FallbackURL = fallbackURL.String
+	url.RedirectType = redirectType.String
+	url.UpdatedAt = timePtr(updatedAt)
+	url.PasswordHash = passwordHash.String
+	url.MaxClicks = intPtr(maxClicks)
+	return &url, nil
+}
+
+func (s *sqlURLStore) Create(ctx context.Context, url *models.URL) error {
+	if url.CreatedAt.IsZero() {
+		url.CreatedAt = time.Now()
+	}
+
+	id, err := s.dialect.InsertReturningID(ctx, s.db,
+		"INSERT INTO urls (code, original_url, user_id, created_at, expires_at, starts_at, prelaunch_url, fallback_url, disabled, redirect_type, password_hash, max_clicks, stats_visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		url.Code, url.OriginalURL, nullInt(url.UserID), s.dialect.Time(url.CreatedAt), s.nullTime(url.ExpiresAt),
+		s.nullTime(url.StartsAt), nullString(url.PrelaunchURL), nullString(url.FallbackURL), url.Disabled,
+		nullString(url.RedirectType), nullString(url.PasswordHash), nullInt(url.MaxClicks), url.StatsVisibility,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	url.ID = int(id)
+	return nil
+}
+
+func (s *sqlURLStore) GetByCode(ctx context.Context, code string) (*models.URL, error) {
+	url, err := scanURL(s.queryRow(ctx, "SELECT "+urlColumns+" FROM urls WHERE code = ?", code))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	url.Rules, err = s.rules(ctx, url.ID)
+	if err != nil {
+		return nil, err
+	}
+	url.Variants, err = s.variants(ctx, url.ID)
+	if err != nil {
+		return nil, err
+	}
+	return url, nil
+}
+
+// variants loads the A/B destinations of a URL in order
+func (s *sqlURLStore) variants(ctx context.Context, urlID int) ([]models.Variant, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, url_id, position, name, destination, weight
+		FROM url_variants
+		WHERE url_id = ?
+		ORDER BY position
+	`, urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []models.Variant
+	for rows.Next() {
+		var v models.Variant
+		if err := rows.Scan(&v.ID, &v.URLID, &v.Position, &v.Name, &v.Destination, &v.Weight); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+// rules loads the targeting rules of a URL in evaluation order
+func (s *sqlURLStore) rules(ctx context.Context, urlID int) ([]models.TargetingRule, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, url_id, position, devices, os, languages, countries, destination
+		FROM url_rules
+		WHERE url_id = ?
+		ORDER BY position
+	`, urlID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.TargetingRule
+	for rows.Next() {
+		var rule models.TargetingRule
+		var devices, os, languages, countries sql.NullString
+		if err := rows.Scan(&rule.ID, &rule.URLID, &rule.Position, &devices, &os, &languages, &countries, &rule.Destination); err != nil {
+			return nil, err
+		}
+		rule.Devices = splitList(devices)
+		rule.OS = splitList(os)
+		rule.Languages = splitList(languages)
+		rule.Countries = splitList(countries)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *sqlURLStore) CodeExists(ctx context.Context, code string) (bool, error) {
+	var exists bool
+	err := s.queryRow(ctx, "SELECT EXISTS(SELECT 1 FROM urls WHERE code = ?)", code).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlURLStore) ListByUser(ctx context.Context, userID int) ([]models.URL, error) {
+	rows, err := s.query(ctx, "SELECT "+urlColumns+" FROM urls WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []models.URL{}
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, *url)
+	}
+	return urls, rows.Err()
+}
+
+func (s *sqlURLStore) Update(ctx context.Context, url *models.URL, fields []URLField, editorID *int) error {
+	now := time.Now()
+	set := make([]string, 0, len(fields)+1)
+	args := make([]interface{}, 0, len(fields)+2)
+	changesDestination := false
+	for _, field := range fields {
+		value, err := s.urlFieldValue(url, field)
+		if err != nil {
+			return err
+		}
+		set = append(set, string(field)+" = ?")
+		args = append(args, value)
+		changesDestination = changesDestination || field == FieldDestination
+	}
+	set = append(set, "updated_at = ?")
+	args = append(args, s.dialect.Time(now), url.ID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previousURL string
+	err = tx.QueryRowContext(ctx, s.dialect.Rebind("SELECT original_url FROM urls WHERE id = ?"), url.ID).Scan(&previousURL)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, s.dialect.Rebind("UPDATE urls SET "+strings.Join(set, ", ")+" WHERE id = ?"), args...)
+	if err != nil {
+		return err
+	}
+
+	if changesDestination && previousURL != url.OriginalURL {
+		_, err = tx.ExecContext(ctx, s.dialect.Rebind(
+			"INSERT INTO url_history (url_id, previous_url, new_url, changed_by, changed_at) VALUES (?, ?, ?, ?, ?)"),
+			url.ID, previousURL, url.OriginalURL, nullInt(editorID), s.dialect.Time(now),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	url.UpdatedAt = &now
+	return nil
+}
+
+// urlFieldValue returns the bind value of a field of url
+func (s *sqlURLStore) urlFieldValue(url *models.URL, field URLField) (interface{}, error) {
+	switch field {
+	case FieldDestination:
+		return url.OriginalURL, nil
+	case FieldExpiresAt:
+		return s.nullTime(url.ExpiresAt), nil
+	case FieldStartsAt:
+		return s.nullTime(url.StartsAt), nil
+	case FieldPrelaunch:
+		return nullString(url.PrelaunchURL), nil
+	case FieldFallback:
+		return nullString(url.FallbackURL), nil
+	case FieldDisabled:
+		return url.Disabled, nil
+	case FieldRedirectType:
+		return nullString(url.RedirectType), nil
+	case FieldPassword:
+		return nullString(url.PasswordHash), nil
+	case FieldMaxClicks:
+		return nullInt(url.MaxClicks), nil
+	case FieldStats:
+		return url.StatsVisibility, nil
+	}
+	return nil, fmt.Errorf("store: unknown URL field %q", field)
+}
+
+func (s *sqlURLStore) ConsumeUse(ctx context.Context, url *models.URL) (bool, error) {
+	// A single conditional UPDATE keeps concurrent redirects from exceeding
+	// the limit; RETURNING is supported by both PostgreSQL and SQLite 3.35+
+	var useCount int
+	err := s.queryRow(ctx,
+		"UPDATE urls SET use_count = use_count + 1 WHERE id = ? AND (max_clicks IS NULL OR use_count < max_clicks) RETURNING use_count",
+		url.ID,
+	).Scan(&useCount)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	url.UseCount = useCount
+	return true, nil
+}
+
+func (s *sqlURLStore) SetRules(ctx context.Context, url *models.URL, rules []models.TargetingRule) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.dialect.Rebind("DELETE FROM url_rules WHERE url_id = ?"), url.ID); err != nil {
+		return err
+	}
+	saved := make([]models.TargetingRule, len(rules))
+	for i, rule := range rules {
+		rule.URLID = url.ID
+		rule.Position = i + 1
+		id, err := s.dialect.InsertReturningID(ctx, tx,
+			"INSERT INTO url_rules (url_id, position, devices, os, languages, countries, destination) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			rule.URLID, rule.Position, joinList(rule.Devices), joinList(rule.OS), joinList(rule.Languages),
+			joinList(rule.Countries), rule.Destination,
+		)
+		if err != nil {
+			return err
+		}
+		rule.ID = int(id)
+		saved[i] = rule
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	url.Rules = saved
+	return nil
+}
+
+func (s *sqlURLStore) SetVariants(ctx context.Context, url *models.URL, variants []models.Variant, sticky bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.dialect.Rebind("DELETE FROM url_variants WHERE url_id = ?"), url.ID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.Rebind("UPDATE urls SET sticky_variants = ? WHERE id = ?"), sticky, url.ID); err != nil {
+		return err
+	}
+	saved := make([]models.Variant, len(variants))
+	for i, v := range variants {
+		v.URLID = url.ID
+		v.Position = i + 1
+		id, err := s.dialect.InsertReturningID(ctx, tx,
+			"INSERT INTO url_variants (url_id, position, name, destination, weight) VALUES (?, ?, ?, ?, ?)",
+			v.URLID, v.Position, v.Name, v.Destination, v.Weight,
+		)
+		if err != nil {
+			return err
+		}
+		v.ID = int(id)
+		saved[i] = v
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	url.Variants = saved
+	url.StickyVariants = sticky
+	return nil
+}
+
+func (s *sqlURLStore) History(ctx context.Context, urlID int) ([]models.URLHistoryEntry, error) {
+	rows, err := s.query(ctx,
+		"SELECT id, url_id, previous_url, new_url, changed_by, changed_at FROM url_history WHERE url_id = ? ORDER BY changed_at DESC, id DESC",
+		urlID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.URLHistoryEntry{}
+	for rows.Next() {
+		var entry models.URLHistoryEntry
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.URLID, &entry.PreviousURL, &entry.NewURL, &changedBy, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		entry.ChangedBy = intPtr(changedBy)
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+func (s *sqlURLStore) Delete(ctx context.Context, code string) error {
+	result, err := s.exec(ctx, "DELETE FROM urls WHERE code = ?", code)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlURLStore) CreateShareToken(ctx context.Context, token *models.ShareToken) error {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	id, err := s.dialect.InsertReturningID(ctx, s.db,
+		"INSERT INTO stats_share_tokens (url_id, name, prefix, token_hash, created_at) VALUES (?, ?, ?, ?, ?)",
+		token.URLID, token.Name, token.Prefix, token.TokenHash, s.dialect.Time(token.CreatedAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	token.ID = int(id)
+	return nil
+}
+
+func (s *sqlURLStore) ShareTokens(ctx context.Context, urlID int) ([]models.ShareToken, error) {
+	rows, err := s.query(ctx,
+		"SELECT id, url_id, name, prefix, created_at FROM stats_share_tokens WHERE url_id = ? ORDER BY created_at, id",
+		urlID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.ShareToken{}
+	for rows.Next() {
+		var token models.ShareToken
+		if err := rows.Scan(&token.ID, &token.URLID, &token.Name, &token.Prefix, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlURLStore) DeleteShareToken(ctx context.Context, urlID, id int) error {
+	result, err := s.exec(ctx, "DELETE FROM stats_share_tokens WHERE id = ? AND url_id = ?", id, urlID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlURLStore) HasShareToken(ctx context.Context, urlID int, tokenHash string) (bool, error) {
+	var exists bool
+	err := s.queryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM stats_share_tokens WHERE url_id = ? AND token_hash = ?)",
+		urlID, tokenHash,
+	).Scan(&exists)
+	return exists, err
+}
+
+// sqlClickStore implements ClickStore
+type sqlClickStore struct {
+	sqlBase
+}
+
+func (s *sqlClickStore) Record(ctx context.Context, click *models.Click) error {
+	if click.ClickedAt.IsZero() {
+		click.ClickedAt = time.Now()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := s.dialect.InsertReturningID(ctx, tx, insertClick, s.clickArgs(click)...)
+	if err != nil {
+		return err
+	}
+	if err := s.addRollups(ctx, tx, aggregateClicks([]models.Click{*click})); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	click.ID = int(id)
+	return nil
+}
+
+func (s *sqlClickStore) RecordBatch(ctx context.Context, clicks []models.Click) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.Rebind(insertClick))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range clicks {
+		click := &clicks[i]
+		if click.ClickedAt.IsZero() {
+			click.ClickedAt = time.Now()
+		}
+		if _, err := stmt.ExecContext(ctx, s.clickArgs(click)...); err != nil {
+			return err
+		}
+	}
+	if err := s.addRollups(ctx, tx, aggregateClicks(clicks)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const insertClick = "INSERT INTO clicks (url_id, ip_address, visitor_id, user_agent, referrer, country, region, city, asn, " +
+	"browser, browser_version, os, os_version, device, rule_id, variant_id, is_bot, clicked_at) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+// Rollup rows are created on first use and incremented afterwards
+const (
+	upsertHourlyRollup = "INSERT INTO click_rollups_hourly (url_id, bucket, dimension, value, is_bot, clicks) VALUES (?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT (url_id, dimension, bucket, value, is_bot) DO UPDATE SET clicks = click_rollups_hourly.clicks + excluded.clicks"
+	upsertDailyRollup = "INSERT INTO click_rollups_daily (url_id, bucket, dimension, value, is_bot, clicks) VALUES (?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT (url_id, dimension, bucket, value, is_bot) DO UPDATE SET clicks = click_rollups_daily.clicks + excluded.clicks"
+)
+
+// addRollups adds hourly counts to the hourly and daily rollup tables
+func (s *sqlClickStore) addRollups(ctx context.Context, tx *sql.Tx, counts []rollupCount) error {
+	for _, query := range []string{upsertHourlyRollup, upsertDailyRollup} {
+		stmt, err := tx.PrepareContext(ctx, s.dialect.Rebind(query))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		daily := query == upsertDailyRollup
+		for _, row := range counts {
+			bucket := row.hour
+			if daily {
+				bucket = row.day()
+			}
+			if _, err := stmt.ExecContext(ctx, row.urlID, s.dialect.Time(bucket), string(row.dim), row.value, row.bot, row.clicks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sqlClickStore) RebuildRollups(ctx context.Context, urlID int) error {
+	// On PostgreSQL a snapshot keeps clicks recorded during the rebuild from
+	// being counted twice (once here and once by their own upsert)
+	var opts *sql.TxOptions
+	if s.dialect.IsPostgres() {
+		opts = &sql.TxOptions{Isolation: sql.LevelRepeatableRead}
+	}
+	tx, err := s.db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.Rebind(
+		"SELECT COALESCE(user_agent, ''), COALESCE(referrer, ''), COALESCE(country, ''), COALESCE(browser, ''), "+
+			"COALESCE(os, ''), COALESCE(device, ''), is_bot, clicked_at FROM clicks WHERE url_id = ?",
+	), urlID)
+	if err != nil {
+		return err
+	}
+	var clicks []models.Click
+	for rows.Next() {
+		click := models.Click{URLID: urlID}
+		if err := rows.Scan(&click.UserAgent, &click.Referrer, &click.Country, &click.Browser, &click.OS, &click.Device, &click.IsBot, &click.ClickedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		clicks = append(clicks, click)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	// Retention deletes whole days of clicks, so the days from the oldest
+	// remaining click on are complete; earlier rollups are all that's left
+	since := clicks[0].ClickedAt
+	for _, click := range clicks {
+		if click.ClickedAt.Before(since) {
+			since = click.ClickedAt
+		}
+	}
+	y, m, d := since.UTC().Date()
+	since = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	for _, table := range []string{"click_rollups_hourly", "click_rollups_daily"} {
+		query := "DELETE FROM " + table + " WHERE url_id = ? AND bucket >= ?"
+		if _, err := tx.ExecContext(ctx, s.dialect.Rebind(query), urlID, s.dialect.Time(since)); err != nil {
+			return err
+		}
+	}
+
+	if err := s.addRollups(ctx, tx, aggregateClicks(clicks)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlClickStore) LinksMissingRollups(ctx context.Context) ([]int, error) {
+	var rolledUp bool
+	if err := s.queryRow(ctx, "SELECT EXISTS (SELECT 1 FROM click_rollups_daily)").Scan(&rolledUp); err != nil {
+		return nil, err
+	}
+	if rolledUp {
+		return nil, nil
+	}
+
+	rows, err := s.query(ctx, "SELECT DISTINCT url_id FROM clicks ORDER BY url_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var urlIDs []int
+	for rows.Next() {
+		var urlID int
+		if err := rows.Scan(&urlID); err != nil {
+			return nil, err
+		}
+		urlIDs = append(urlIDs, urlID)
+	}
+	return urlIDs, rows.Err()
+}
+
+// clickArgs returns the values for the placeholders of insertClick
+func (s *sqlClickStore) clickArgs(click *models.Click) []interface{} {
+	return []interface{}{
+		click.URLID, nullString(click.IPAddress), nullString(click.VisitorID), nullString(click.UserAgent), click.Referrer,
+		click.Country, nullString(click.Region),
+		nullString(click.City), nullPositive(click.ASN), nullString(click.Browser), nullString(click.BrowserVersion),
+		nullString(click.OS), nullString(click.OSVersion), nullString(click.Device), nullInt(click.RuleID),
+		nullInt(click.VariantID), click.IsBot, s.dialect.Time(click.ClickedAt),
+	}
+}
+
+func (s *sqlClickStore) CountClicks(ctx context.Context, urlID int, traffic Traffic) (int, error) {
+	matches, args := s.filterCondition("bucket", ClickFilter{Traffic: traffic}, false)
+	var count int
+	err := s.queryRow(ctx,
+		"SELECT COALESCE(SUM(clicks), 0) FROM click_rollups_daily WHERE url_id = ? AND dimension = ?"+matches,
+		append([]interface{}{urlID, string(dimTotal)}, args...)...,
+	).Scan(&count)
+	return count, err
+}
+
+const clickColumns = "id, url_id, ip_address, visitor_id, user_agent, referrer, country, region, city, asn, " +
+	"browser, browser_version, os, os_version, device, rule_id, variant_id, is_bot, clicked_at"
+
+// scanClick reads a row selected with clickColumns
+func scanClick(row interface{ Scan(...interface{}) error }) (*models.Click, error) {
+	var click models.Click
+	var ip, visitorID, userAgent, referrer, country, region, city sql.NullString
+	var browser, browserVersion, os, osVersion, device sql.NullString
+	var asn, ruleID, variantID sql.NullInt64
+	err := row.Scan(&click.ID, &click.URLID, &ip, &visitorID, &userAgent, &referrer, &country, &region, &city, &asn,
+		&browser, &browserVersion, &os, &osVersion, &device, &ruleID, &variantID, &click.IsBot, &click.ClickedAt)
+	if err != nil {
+		return nil, err
+	}
+	click.IPAddress, click.VisitorID, click.UserAgent = ip.String, visitorID.String, userAgent.String
+	click.Referrer, click.Country, click.Region, click.City = referrer.String, country.String, region.String, city.String
+	click.ASN = int(asn.Int64)
+	click.Browser, click.BrowserVersion = browser.String, browserVersion.String
+	click.OS, click.OSVersion, click.Device = os.String, osVersion.String, device.String
+	click.RuleID, click.VariantID = intPtr(ruleID), intPtr(variantID)
+	return &click, nil
+}
+
+func (s *sqlClickStore) EachClick(ctx context.Context, urlID int, f ClickFilter, fn func(click *models.Click) error) error {
+	matches, args := s.filterCondition("clicked_at", f, false)
+	rows, err := s.query(ctx,
+		"SELECT "+clickColumns+" FROM clicks WHERE url_id = ?"+matches+" ORDER BY clicked_at, id",
+		append([]interface{}{urlID}, args...)...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		click, err := scanClick(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// visitorKey identifies a visitor in COUNT(DISTINCT) queries: the visitor
+// ID recorded in privacy mode, else the IP address
+const visitorKey = "COALESCE(visitor_id, NULLIF(ip_address, ''))"
+
+func (s *sqlClickStore) CountUniqueVisitors(ctx context.Context, urlID int, traffic Traffic) (int, error) {
+	matches, args := s.filterCondition("clicked_at", ClickFilter{Traffic: traffic}, false)
+	var count int
+	err := s.queryRow(ctx,
+		"SELECT COUNT(DISTINCT "+visitorKey+") FROM clicks WHERE url_id = ?"+matches,
+		append([]interface{}{urlID}, args...)...,
+	).Scan(&count)
+	return count, err
+}
+
+func (s *sqlClickStore) ClicksByDay(ctx context.Context, urlID int, days int, traffic Traffic) (map[string]int, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	matches, args := s.filterCondition("bucket", ClickFilter{From: since, Traffic: traffic}, false)
+	rows, err := s.query(ctx, `
+		SELECT `+s.dialect.Date("bucket")+`, SUM(clicks)
+		FROM click_rollups_daily
+		WHERE url_id = ? AND dimension = ?`+matches+`
+		GROUP BY bucket
+		ORDER BY bucket DESC
+	`, append([]interface{}{urlID, string(dimTotal)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clicksByDay := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		clicksByDay[day] = count
+	}
+	return clicksByDay, rows.Err()
+}
+
+func (s *sqlClickStore) HourlyClicks(ctx context.Context, urlID int, f ClickFilter) ([]BucketCount, error) {
+	matches, args := s.filterCondition("bucket", f, true)
+	rows, err := s.query(ctx, `
+		SELECT bucket, SUM(clicks)
+		FROM click_rollups_hourly
+		WHERE url_id = ? AND dimension = ?`+matches+`
+		GROUP BY bucket
+		ORDER BY bucket
+	`, append([]interface{}{urlID, string(dimTotal)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []BucketCount{}
+	for rows.Next() {
+		var b BucketCount
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.UTC()
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// filterCondition returns the AND clauses selecting the clicks or rollup
+// rows matching f, whose time is in column, and their arguments. For hourly
+// rollup buckets From is rounded down to the hour.
+func (s *sqlClickStore) filterCondition(column string, f ClickFilter, hourly bool) (string, []interface{}) {
+	var clauses string
+	var args []interface{}
+	if !f.From.IsZero() {
+		from := f.From
+		if hourly {
+			from = from.UTC().Truncate(time.Hour)
+		}
+		clauses += " AND " + column + " >= ?"
+		args = append(args, s.dialect.Time(from))
+	}
+	if !f.To.IsZero() {
+		clauses += " AND " + column + " < ?"
+		args = append(args, s.dialect.Time(f.To))
+	}
+	switch f.Traffic {
+	case TrafficHuman, TrafficBot:
+		clauses += " AND is_bot = ?"
+		args = append(args, f.Traffic == TrafficBot)
+	}
+	return clauses, args
+}
+
+func (s *sqlClickStore) ClicksByRule(ctx context.Context, urlID int, f ClickFilter) (map[int]int, int, error) {
+	matches, args := s.filterCondition("clicked_at", f, false)
+	rows, err := s.query(ctx, `
+		SELECT rule_id, COUNT(*)
+		FROM clicks
+		WHERE url_id = ?`+matches+`
+		GROUP BY rule_id
+	`, append([]interface{}{urlID}, args...)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	clicksByRule := make(map[int]int)
+	unmatched := 0
+	for rows.Next() {
+		var ruleID sql.NullInt64
+		var count int
+		if err := rows.Scan(&ruleID, &count); err != nil {
+			return nil, 0, err
+		}
+		if ruleID.Valid {
+			clicksByRule[int(ruleID.Int64)] = count
+		} else {
+			unmatched = count
+		}
+	}
+	return clicksByRule, unmatched, rows.Err()
+}
+
+func (s *sqlClickStore) ClicksByVariant(ctx context.Context, urlID int, f ClickFilter) (map[int]GroupCount, error) {
+	matches, args := s.filterCondition("clicked_at", f, false)
+	rows, err := s.query(ctx, `
+		SELECT variant_id, COUNT(*), COUNT(DISTINCT `+visitorKey+`)
+		FROM clicks
+		WHERE url_id = ? AND variant_id IS NOT NULL`+matches+`
+		GROUP BY variant_id
+	`, append([]interface{}{urlID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clicksByVariant := make(map[int]GroupCount)
+	for rows.Next() {
+		var variantID int
+		var counts GroupCount
+		if err := rows.Scan(&variantID, &counts.Clicks, &counts.Unique); err != nil {
+			return nil, err
+		}
+		clicksByVariant[variantID] = counts
+	}
+	return clicksByVariant, rows.Err()
+}
+
+func (s *sqlClickStore) TopValues(ctx context.Context, urlID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error) {
+	if dim.rolledUp() {
+		return s.topRollupValues(ctx, urlID, dim, f, limit)
+	}
+	if !dim.valid() {
+		return nil, fmt.Errorf("unknown dimension %q", dim)
+	}
+	column := string(dim)
+	matches, args := s.filterCondition("clicked_at", f, false)
+	rows, err := s.query(ctx, `
+		SELECT `+column+`, COUNT(*) as count
+		FROM clicks
+		WHERE url_id = ? AND `+column+` IS NOT NULL AND `+column+` != ''`+matches+`
+		GROUP BY `+column+`
+		ORDER BY count DESC
+		LIMIT ?
+	`, append(append([]interface{}{urlID}, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []ValueCount{}
+	for rows.Next() {
+		var v ValueCount
+		if err := rows.Scan(&v.Value, &v.Count); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// topRollupValues answers TopValues from the rollups: the daily ones for
+// all-time breakdowns, the hourly ones for a time range
+func (s *sqlClickStore) topRollupValues(ctx context.Context, urlID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error) {
+	table := "click_rollups_daily"
+	if f.Bounded() {
+		table = "click_rollups_hourly"
+	}
+	matches, args := s.filterCondition("bucket", f, true)
+	rows, err := s.query(ctx, `
+		SELECT value, SUM(clicks) as count
+		FROM `+table+`
+		WHERE url_id = ? AND dimension = ? AND value != ''`+matches+`
+		GROUP BY value
+		ORDER BY count DESC, value
+		LIMIT ?
+	`, append(append([]interface{}{urlID, string(dim)}, args...), limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []ValueCount{}
+	for rows.Next() {
+		var v ValueCount
+		if err := rows.Scan(&v.Value, &v.Count); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (s *sqlClickStore) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	key := day.UTC().Format("2006-01-02")
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// Concurrent instances may race to create the salt; the first one wins
+	if _, err := s.exec(ctx,
+		"INSERT INTO visitor_salts (day, salt) VALUES (?, ?) ON CONFLICT (day) DO NOTHING",
+		key, hex.EncodeToString(salt),
+	); err != nil {
+		return nil, err
+	}
+	var stored string
+	if err := s.queryRow(ctx, "SELECT salt FROM visitor_salts WHERE day = ?", key).Scan(&stored); err != nil {
+		return nil, err
+	}
+
+	// The previous day's salt is kept for clicks still queued at midnight
+	expired := day.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	if _, err := s.exec(ctx, "DELETE FROM visitor_salts WHERE day < ?", expired); err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(stored)
+}
+
+func (s *sqlClickStore) DeleteClicksBefore(ctx context.Context, t time.Time) (int64, error) {
+	result, err := s.exec(ctx, "DELETE FROM clicks WHERE clicked_at < ?", s.dialect.Time(t))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// anonymizeBatchSize is the number of clicks AnonymizeClicksBefore updates per transaction
+const anonymizeBatchSize = 500
+
+func (s *sqlClickStore) AnonymizeClicksBefore(ctx context.Context, t time.Time) (int64, error) {
+	var total int64
+	for {
+		n, err := s.anonymizeBatch(ctx, t)
+		total += int64(n)
+		if err != nil || n < anonymizeBatchSize {
+			return total, err
+		}
+	}
+}
+
+// anonymizeBatch anonymizes up to anonymizeBatchSize clicks recorded before t
+func (s *sqlClickStore) anonymizeBatch(ctx context.Context, t time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.Rebind(
+		"SELECT id, COALESCE(ip_address, '') FROM clicks WHERE clicked_at < ? AND NOT anonymized ORDER BY id LIMIT ?",
+	), s.dialect.Time(t), anonymizeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	type expired struct {
+		id int
+		ip string
+	}
+	var clicks []expired
+	for rows.Next() {
+		var click expired
+		if err := rows.Scan(&click.id, &click.ip); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		clicks = append(clicks, click)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.Rebind(
+		"UPDATE clicks SET ip_address = ?, user_agent = NULL, city = NULL, asn = NULL, anonymized = ? WHERE id = ?",
+	))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	for _, click := range clicks {
+		if _, err := stmt.ExecContext(ctx, nullString(privacy.TruncateIP(click.ip)), true, click.id); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(clicks), nil
+}
+
+// userRollups joins the hourly rollups to the links of the user bound to
+// the first placeholder. Queries add conditions and their arguments after it.
+const userRollups = `
+	FROM click_rollups_hourly
+	JOIN urls ON urls.id = click_rollups_hourly.url_id
+	WHERE urls.user_id = ? AND dimension = ?`
+
+func (s *sqlClickStore) UserHourlyClicks(ctx context.Context, userID int, f ClickFilter) ([]BucketCount, error) {
+	matches, args := s.filterCondition("bucket", f, true)
+	rows, err := s.query(ctx, `
+		SELECT bucket, SUM(clicks)`+userRollups+matches+`
+		GROUP BY bucket
+		ORDER BY bucket
+	`, append([]interface{}{userID, string(dimTotal)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []BucketCount{}
+	for rows.Next() {
+		var b BucketCount
+		if err := rows.Scan(&b.Start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		b.Start = b.Start.UTC()
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+func (s *sqlClickStore) UserTopLinks(ctx context.Context, userID int, f ClickFilter, limit int) ([]LinkCount, error) {
+	matches, args := s.filterCondition("bucket", f, true)
+	args = append([]interface{}{userID, string(dimTotal)}, args...)
+	query := `
+		SELECT urls.id, urls.code, urls.original_url, SUM(clicks) as count` + userRollups + matches + `
+		GROUP BY urls.id, urls.code, urls.original_url
+		ORDER BY count DESC, urls.code`
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []LinkCount{}
+	for rows.Next() {
+		var l LinkCount
+		if err := rows.Scan(&l.URLID, &l.Code, &l.OriginalURL, &l.Clicks); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+func (s *sqlClickStore) UserTopValues(ctx context.Context, userID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error) {
+	if !dim.rolledUp() {
+		return nil, fmt.Errorf("dimension %q is not rolled up", dim)
+	}
+	matches, args := s.filterCondition("bucket", f, true)
+	args = append([]interface{}{userID, string(dim)}, args...)
+	query := `
+		SELECT value, SUM(clicks) as count` + userRollups + ` AND value != ''` + matches + `
+		GROUP BY value
+		ORDER BY count DESC, value`
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []ValueCount{}
+	for rows.Next() {
+		var v ValueCount
+		if err := rows.Scan(&v.Value, &v.Count); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// sqlUserStore implements UserStore
+type sqlUserStore struct {
+	sqlBase
+}
+
+func (s *sqlUserStore) Create(ctx context.Context, user *models.User) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	id, err := s.dialect.InsertReturningID(ctx, s.db,
+		"INSERT INTO users (username, email, password_hash, created_at) VALUES (?, ?, ?, ?)",
+		user.Username, user.Email, user.PasswordHash, s.dialect.Time(user.CreatedAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	user.ID = int(id)
+	return nil
+}
+
+const userColumns = "id, username, email, password_hash, created_at, fallback_url, token_version"
+
+// scanUser reads a row selected with userColumns
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	var user models.User
+	var fallbackURL sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt, &fallbackURL, &user.TokenVersion)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.FallbackURL = fallbackURL.String
+	return &user, nil
+}
+
+func (s *sqlUserStore) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return scanUser(s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func (s *sqlUserStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	return scanUser(s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s *sqlUserStore) SetFallbackURL(ctx context.Context, id int, fallbackURL string) error {
+	result, err := s.exec(ctx, "UPDATE users SET fallback_url = ? WHERE id = ?", nullString(fallbackURL), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlUserStore) Exists(ctx context.Context, username, email string) (bool, error) {
+	var exists bool
+	err := s.queryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = ? OR email = ?)", username, email).Scan(&exists)
+	return exists, err
+}
+
+func (s *sqlUserStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	id, err := s.dialect.InsertReturningID(ctx, s.db,
+		"INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		key.UserID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "),
+		s.dialect.Time(key.CreatedAt), s.nullTime(key.ExpiresAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	key.ID = int(id)
+	return nil
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at"
+
+// scanAPIKey reads a row selected with apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.CreatedAt, &lastUsedAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.LastUsedAt = timePtr(lastUsedAt)
+	key.ExpiresAt = timePtr(expiresAt)
+	return &key, nil
+}
+
+func (s *sqlUserStore) APIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	rows, err := s.query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY created_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlUserStore) APIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	return scanAPIKey(s.queryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", keyHash))
+}
+
+func (s *sqlUserStore) TouchAPIKey(ctx context.Context, id int, t time.Time) error {
+	_, err := s.exec(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", s.dialect.Time(t), id)
+	return err
+}
+
+func (s *sqlUserStore) DeleteAPIKey(ctx context.Context, userID, id int) error {
+	result, err := s.exec(ctx, "DELETE FROM api_keys WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlUserStore) TokenVersion(ctx context.Context, id int) (int, error) {
+	var version int
+	err := s.queryRow(ctx, "SELECT token_version FROM users WHERE id = ?", id).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return version, err
+}
+
+func (s *sqlUserStore) RevokeSessions(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.dialect.Rebind("UPDATE users SET token_version = token_version + 1 WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.Rebind("DELETE FROM refresh_tokens WHERE user_id = ?"), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlUserStore) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	id, err := s.dialect.InsertReturningID(ctx, s.db,
+		"INSERT INTO refresh_tokens (user_id, session_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		token.UserID, token.SessionID, token.TokenHash, s.dialect.Time(token.CreatedAt), s.dialect.Time(token.ExpiresAt),
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrConflict
+		}
+		return err
+	}
+	token.ID = int(id)
+	return nil
+}
+
+func (s *sqlUserStore) RefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	var usedAt sql.NullTime
+	err := s.queryRow(ctx,
+		"SELECT id, user_id, session_id, token_hash, created_at, expires_at, used_at FROM refresh_tokens WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&token.ID, &token.UserID, &token.SessionID, &token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.UsedAt = timePtr(usedAt)
+	return &token, nil
+}
+
+func (s *sqlUserStore) UseRefreshToken(ctx context.Context, id int, t time.Time) (bool, error) {
+	result, err := s.exec(ctx, "UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", s.dialect.Time(t), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *sqlUserStore) DeleteSession(ctx context.Context, userID int, sessionID string) error {
+	_, err := s.exec(ctx, "DELETE FROM refresh_tokens WHERE user_id = ? AND session_id = ?", userID, sessionID)
+	return err
+}
+
+func (s *sqlUserStore) DeleteExpiredRefreshTokens(ctx context.Context, userID int, t time.Time) error {
+	_, err := s.exec(ctx, "DELETE FROM refresh_tokens WHERE user_id = ? AND expires_at < ?", userID, s.dialect.Time(t))
+	return err
+}
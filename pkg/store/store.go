@@ -0,0 +1,272 @@
+// Package store defines the persistence interfaces used by the HTTP handlers
+// together with SQL (SQLite/PostgreSQL) and in-memory implementations.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gourl/pkg/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a unique value (code, username) is already taken
+	ErrConflict = errors.New("record already exists")
+)
+
+// Dimension is a clicks column that analytics can be grouped by
+type Dimension string
+
+const (
+	DimReferrer  Dimension = "referrer"
+	DimUserAgent Dimension = "user_agent"
+	DimCountry   Dimension = "country"
+)
+
+// valid reports whether d is a known dimension (and therefore safe to use as a column name)
+func (d Dimension) valid() bool {
+	switch d {
+	case DimReferrer, DimUserAgent, DimCountry:
+		return true
+	}
+	return false
+}
+
+// ValueCount is a single row of a grouped breakdown
+type ValueCount struct {
+	Value string
+	Count int
+}
+
+// GroupCount holds the clicks and unique visitors of one group of clicks
+type GroupCount struct {
+	Clicks int
+	Unique int
+}
+
+// Traffic selects clicks by whether they were classified as bots. The zero
+// value selects all of them.
+type Traffic string
+
+const (
+	TrafficAll   Traffic = "all"
+	TrafficHuman Traffic = "human"
+	TrafficBot   Traffic = "bot"
+)
+
+// ParseTraffic returns the traffic called name, or false if there is none
+func ParseTraffic(name string) (Traffic, bool) {
+	switch traffic := Traffic(name); traffic {
+	case TrafficAll, TrafficHuman, TrafficBot:
+		return traffic, true
+	}
+	return "", false
+}
+
+// matches reports whether a click with the given bot flag belongs to t
+func (t Traffic) matches(isBot bool) bool {
+	switch t {
+	case TrafficHuman:
+		return !isBot
+	case TrafficBot:
+		return isBot
+	}
+	return true
+}
+
+// ClickFilter restricts analytics to clicks in [From, To) of the selected
+// traffic. A zero bound is open. Queries answered from the rollups work at
+// hour precision: hours partly inside the range count in full.
+type ClickFilter struct {
+	From    time.Time
+	To      time.Time
+	Traffic Traffic
+}
+
+// Bounded reports whether the filter restricts the time range
+func (f ClickFilter) Bounded() bool {
+	return !f.From.IsZero() || !f.To.IsZero()
+}
+
+// BucketCount is the number of clicks in the time bucket starting at Start
+type BucketCount struct {
+	Start  time.Time
+	Clicks int
+}
+
+// LinkCount is the number of clicks of one link
+type LinkCount struct {
+	URLID       int
+	Code        string
+	OriginalURL string
+	Clicks      int
+}
+
+// URLField is a mutable attribute of a link, named after its column
+type URLField string
+
+// Attributes Update can save
+const (
+	FieldDestination  URLField = "original_url"
+	FieldExpiresAt    URLField = "expires_at"
+	FieldStartsAt     URLField = "starts_at"
+	FieldPrelaunch    URLField = "prelaunch_url"
+	FieldFallback     URLField = "fallback_url"
+	FieldDisabled     URLField = "disabled"
+	FieldRedirectType URLField = "redirect_type"
+	FieldPassword     URLField = "password_hash"
+	FieldMaxClicks    URLField = "max_clicks"
+	FieldStats        URLField = "stats_visibility"
+)
+
+// URLStore persists short links
+type URLStore interface {
+	// Create inserts a new link and sets its ID. Returns ErrConflict if the code is taken.
+	Create(ctx context.Context, url *models.URL) error
+	// GetByCode returns the link with its targeting rules and variants
+	GetByCode(ctx context.Context, code string) (*models.URL, error)
+	CodeExists(ctx context.Context, code string) (bool, error)
+	ListByUser(ctx context.Context, userID int) ([]models.URL, error)
+	// Update saves the given fields of url and sets UpdatedAt. Other fields
+	// keep their stored values, so edits of different fields made at the
+	// same time (or from a stale copy of url) don't undo each other. If the
+	// destination changed, the previous one is appended to the history,
+	// attributed to editorID.
+	Update(ctx context.Context, url *models.URL, fields []URLField, editorID *int) error
+	// ConsumeUse atomically counts one redirect against the URL's MaxClicks
+	// and updates url.UseCount. It returns false once the limit is reached.
+	ConsumeUse(ctx context.Context, url *models.URL) (bool, error)
+	// SetRules replaces the ordered targeting rules of url, assigning their
+	// IDs and positions, and stores them in url.Rules
+	SetRules(ctx context.Context, url *models.URL, rules []models.TargetingRule) error
+	// SetVariants replaces the weighted A/B destinations of url and its
+	// sticky setting, assigning IDs and positions, and stores them in url
+	SetVariants(ctx context.Context, url *models.URL, variants []models.Variant, sticky bool) error
+	// History returns the destination changes of a URL, newest first
+	History(ctx context.Context, urlID int) ([]models.URLHistoryEntry, error)
+	Delete(ctx context.Context, code string) error
+
+	// CreateShareToken stores a stats share token and sets its ID and CreatedAt
+	CreateShareToken(ctx context.Context, token *models.ShareToken) error
+	// ShareTokens returns the share tokens of a URL, oldest first
+	ShareTokens(ctx context.Context, urlID int) ([]models.ShareToken, error)
+	// DeleteShareToken revokes a share token of a URL. Returns ErrNotFound if
+	// the URL has no token with that ID.
+	DeleteShareToken(ctx context.Context, urlID, id int) error
+	// HasShareToken reports whether a URL has a share token with the given hash
+	HasShareToken(ctx context.Context, urlID int, tokenHash string) (bool, error)
+}
+
+// ClickStore persists click events and answers analytics queries
+type ClickStore interface {
+	Record(ctx context.Context, click *models.Click) error
+	// RecordBatch inserts clicks in a single transaction; either all of them
+	// are stored or none are
+	RecordBatch(ctx context.Context, clicks []models.Click) error
+	// RebuildRollups recomputes the hourly and daily rollups of a URL from
+	// its recorded clicks. Record and RecordBatch keep them up to date; this
+	// is for clicks stored before the rollups existed. Days before the
+	// oldest click keep their rollups since retention may have deleted
+	// their clicks.
+	RebuildRollups(ctx context.Context, urlID int) error
+	// LinksMissingRollups returns the links with clicks when there are no
+	// rollups at all, as right after upgrading from a version without them.
+	// Once any rollup exists it returns nothing.
+	LinksMissingRollups(ctx context.Context) ([]int, error)
+	// CountClicks returns the total clicks of a URL (from the rollups)
+	CountClicks(ctx context.Context, urlID int, traffic Traffic) (int, error)
+	// CountUniqueVisitors counts distinct visitors: their visitor ID where
+	// one was recorded, else their IP address. It scans the raw clicks since
+	// distinct counts can't be summed across rollup buckets.
+	CountUniqueVisitors(ctx context.Context, urlID int, traffic Traffic) (int, error)
+	// ClicksByDay returns YYYY-MM-DD -> clicks for the last n days
+	ClicksByDay(ctx context.Context, urlID int, days int, traffic Traffic) (map[string]int, error)
+	// HourlyClicks returns the clicks per UTC hour matching f, oldest first.
+	// Hours without clicks are left out.
+	HourlyClicks(ctx context.Context, urlID int, f ClickFilter) ([]BucketCount, error)
+	// ClicksByRule returns rule ID -> clicks matching f for clicks routed by
+	// a targeting rule, and the number of matching clicks no rule routed
+	ClicksByRule(ctx context.Context, urlID int, f ClickFilter) (byRule map[int]int, unmatched int, err error)
+	// ClicksByVariant returns variant ID -> clicks and unique visitors matching f
+	ClicksByVariant(ctx context.Context, urlID int, f ClickFilter) (map[int]GroupCount, error)
+	// TopValues returns the most frequent non-empty values of a dimension
+	// among clicks matching f. Referrer, country, browser and device are read
+	// from the rollups.
+	TopValues(ctx context.Context, urlID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error)
+	// UserHourlyClicks returns the clicks per UTC hour matching f across all
+	// links of a user, oldest first. Hours without clicks are left out.
+	UserHourlyClicks(ctx context.Context, userID int, f ClickFilter) ([]BucketCount, error)
+	// UserTopLinks returns the links of a user with the most clicks matching
+	// f, leaving out links without any. A limit of 0 returns them all.
+	UserTopLinks(ctx context.Context, userID int, f ClickFilter, limit int) ([]LinkCount, error)
+	// UserTopValues returns the most frequent non-empty values of a rolled-up
+	// dimension among the clicks matching f across all links of a user. A
+	// limit of 0 returns them all.
+	UserTopValues(ctx context.Context, userID int, dim Dimension, f ClickFilter, limit int) ([]ValueCount, error)
+
+	// EachClick calls fn with the clicks of a URL matching f, oldest first,
+	// reading them one at a time. It stops at the first error fn returns.
+	EachClick(ctx context.Context, urlID int, f ClickFilter, fn func(click *models.Click) error) error
+
+	// VisitorSalt returns the random salt of visitor IDs on a UTC day,
+	// creating it on first use, and deletes the salts of earlier days
+	// except the previous one
+	VisitorSalt(ctx context.Context, day time.Time) ([]byte, error)
+	// DeleteClicksBefore deletes the clicks recorded before t, keeping the rollups
+	DeleteClicksBefore(ctx context.Context, t time.Time) (int64, error)
+	// AnonymizeClicksBefore truncates the IP address of the clicks recorded
+	// before t and clears their raw user agent, city and network
+	AnonymizeClicksBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// UserStore persists user accounts
+type UserStore interface {
+	// Create inserts a new user and sets its ID. Returns ErrConflict if the username or email is taken.
+	Create(ctx context.Context, user *models.User) error
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	GetByID(ctx context.Context, id int) (*models.User, error)
+	// SetFallbackURL changes where the user's unavailable links redirect ("" removes it)
+	SetFallbackURL(ctx context.Context, id int, fallbackURL string) error
+	Exists(ctx context.Context, username, email string) (bool, error)
+
+	// CreateAPIKey stores an API key and sets its ID and CreatedAt
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// APIKeys returns the API keys of a user, oldest first
+	APIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+	// APIKeyByHash returns the API key with the given hash, or ErrNotFound
+	APIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	// TouchAPIKey records that a key was used at t
+	TouchAPIKey(ctx context.Context, id int, t time.Time) error
+	// DeleteAPIKey revokes an API key of a user. Returns ErrNotFound if the
+	// user has no key with that ID.
+	DeleteAPIKey(ctx context.Context, userID, id int) error
+
+	// TokenVersion returns the version access tokens of a user must carry, or ErrNotFound
+	TokenVersion(ctx context.Context, id int) (int, error)
+	// RevokeSessions logs a user out everywhere: it bumps the token version
+	// and deletes every refresh token
+	RevokeSessions(ctx context.Context, id int) error
+
+	// CreateRefreshToken stores a refresh token and sets its ID and CreatedAt
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	// RefreshTokenByHash returns the refresh token with the given hash, used
+	// or not, or ErrNotFound
+	RefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// UseRefreshToken marks a refresh token used at t. It reports false if
+	// the token was already used, so concurrent exchanges can't both succeed.
+	UseRefreshToken(ctx context.Context, id int, t time.Time) (bool, error)
+	// DeleteSession deletes every refresh token of a login session
+	DeleteSession(ctx context.Context, userID int, sessionID string) error
+	// DeleteExpiredRefreshTokens deletes a user's refresh tokens that expired before t
+	DeleteExpiredRefreshTokens(ctx context.Context, userID int, t time.Time) error
+}
+
+// Stores bundles the repositories handed to the HTTP handlers
+type Stores struct {
+	URLs   URLStore
+	Clicks ClickStore
+	Users  UserStore
+}
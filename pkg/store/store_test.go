@@ -0,0 +1,429 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gourl/pkg/database"
+	"gourl/pkg/models"
+)
+
+// backend opens empty stores of one implementation
+type backend struct {
+	name string
+	open func(t *testing.T) *Stores
+}
+
+// backends are the implementations every store test runs against. Postgres
+// needs TEST_POSTGRES_URL and is skipped without it; each run gets its own
+// schema, so any database the user can create schemas in will do.
+var backends = []backend{
+	{"sqlite", openSQLite},
+	{"postgres", openPostgres},
+	{"memory", func(t *testing.T) *Stores { return NewMemory() }},
+}
+
+// forEachBackend runs fn against every backend
+func forEachBackend(t *testing.T, fn func(t *testing.T, s *Stores)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			fn(t, b.open(t))
+		})
+	}
+}
+
+// openSQLite returns stores on a migrated SQLite database in a temp dir
+func openSQLite(t *testing.T) *Stores {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrateTestDB(t, db, database.SQLite)
+	return NewSQLite(db)
+}
+
+// openPostgres returns stores on a fresh, migrated schema of TEST_POSTGRES_URL
+func openPostgres(t *testing.T) *Stores {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	b := make([]byte, 6)
+	rand.Read(b)
+	schema := "gourl_test_" + hex.EncodeToString(b)
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	defer admin.Close()
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		cleanup, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return
+		}
+		defer cleanup.Close()
+		cleanup.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+
+	// lib/pq passes unknown settings on as run-time parameters
+	sep := " "
+	if strings.Contains(dsn, "://") {
+		sep = "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := sql.Open("postgres", dsn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrateTestDB(t, db, database.Postgres)
+	return NewPostgres(db)
+}
+
+// migrateTestDB applies every migration to db
+func migrateTestDB(t *testing.T, db *sql.DB, dialect database.Dialect) {
+	t.Helper()
+
+	migrator, err := database.NewMigrator(db, dialect)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+}
+
+// createURL stores a link with the given code, failing the test on error
+func createURL(t *testing.T, s *Stores, code string) *models.URL {
+	t.Helper()
+
+	url := &models.URL{Code: code, OriginalURL: "https://example.com/" + code, StatsVisibility: models.StatsPublic}
+	if err := s.URLs.Create(context.Background(), url); err != nil {
+		t.Fatalf("Create(%s): %v", code, err)
+	}
+	return url
+}
+
+func TestURLStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		url := createURL(t, s, "abc123")
+		if url.ID == 0 {
+			t.Fatal("Create didn't set the ID")
+		}
+
+		if err := s.URLs.Create(ctx, &models.URL{Code: "abc123", OriginalURL: "https://other.example"}); err != ErrConflict {
+			t.Errorf("Create with a taken code = %v, want ErrConflict", err)
+		}
+
+		got, err := s.URLs.GetByCode(ctx, "abc123")
+		if err != nil {
+			t.Fatalf("GetByCode: %v", err)
+		}
+		if got.ID != url.ID || got.OriginalURL != url.OriginalURL {
+			t.Errorf("GetByCode = %+v, want %+v", got, url)
+		}
+		if _, err := s.URLs.GetByCode(ctx, "missing"); err != ErrNotFound {
+			t.Errorf("GetByCode(missing) = %v, want ErrNotFound", err)
+		}
+
+		for code, want := range map[string]bool{"abc123": true, "missing": false} {
+			exists, err := s.URLs.CodeExists(ctx, code)
+			if err != nil || exists != want {
+				t.Errorf("CodeExists(%s) = %v, %v, want %v", code, exists, err, want)
+			}
+		}
+
+		if err := s.URLs.Delete(ctx, "abc123"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := s.URLs.GetByCode(ctx, "abc123"); err != ErrNotFound {
+			t.Errorf("GetByCode after Delete = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestURLStoreUpdateHistory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		url := createURL(t, s, "edit")
+		previous := url.OriginalURL
+
+		url.OriginalURL = "https://example.com/new"
+		if err := s.URLs.Update(ctx, url, []URLField{FieldDestination}, nil); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		got, err := s.URLs.GetByCode(ctx, "edit")
+		if err != nil {
+			t.Fatalf("GetByCode: %v", err)
+		}
+		if got.OriginalURL != "https://example.com/new" || got.UpdatedAt == nil {
+			t.Errorf("after Update got %q, updated at %v", got.OriginalURL, got.UpdatedAt)
+		}
+
+		history, err := s.URLs.History(ctx, url.ID)
+		if err != nil {
+			t.Fatalf("History: %v", err)
+		}
+		if len(history) != 1 || history[0].PreviousURL != previous || history[0].NewURL != "https://example.com/new" {
+			t.Errorf("History = %+v", history)
+		}
+	})
+}
+
+func TestURLStoreUpdateFields(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		limit := 5
+		url := &models.URL{Code: "fields", OriginalURL: "https://example.com", MaxClicks: &limit, StatsVisibility: models.StatsPublic}
+		if err := s.URLs.Create(ctx, url); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		// Two editors start from the same copy and change different fields
+		first, _ := s.URLs.GetByCode(ctx, "fields")
+		second, _ := s.URLs.GetByCode(ctx, "fields")
+		first.Disabled = true
+		if err := s.URLs.Update(ctx, first, []URLField{FieldDisabled}, nil); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if _, err := s.URLs.ConsumeUse(ctx, url); err != nil {
+			t.Fatalf("ConsumeUse: %v", err)
+		}
+		second.FallbackURL = "https://example.com/fallback"
+		second.MaxClicks = nil
+		if err := s.URLs.Update(ctx, second, []URLField{FieldFallback, FieldMaxClicks}, nil); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		got, err := s.URLs.GetByCode(ctx, "fields")
+		if err != nil {
+			t.Fatalf("GetByCode: %v", err)
+		}
+		if !got.Disabled || got.FallbackURL != "https://example.com/fallback" || got.MaxClicks != nil || got.UseCount != 1 {
+			t.Errorf("after both edits got disabled %v, fallback %q, max clicks %v, use count %d",
+				got.Disabled, got.FallbackURL, got.MaxClicks, got.UseCount)
+		}
+
+		if err := s.URLs.Update(ctx, got, []URLField{"code"}, nil); err == nil {
+			t.Error("Update of an unknown field succeeded")
+		}
+	})
+}
+
+func TestConsumeUse(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		limit := 2
+		url := &models.URL{Code: "limited", OriginalURL: "https://example.com", MaxClicks: &limit, StatsVisibility: models.StatsPublic}
+		if err := s.URLs.Create(ctx, url); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		for i, want := range []bool{true, true, false} {
+			ok, err := s.URLs.ConsumeUse(ctx, url)
+			if err != nil {
+				t.Fatalf("ConsumeUse: %v", err)
+			}
+			if ok != want {
+				t.Errorf("ConsumeUse #%d = %v, want %v", i+1, ok, want)
+			}
+		}
+		if url.UseCount != 2 {
+			t.Errorf("UseCount = %d, want 2", url.UseCount)
+		}
+	})
+}
+
+func TestClickStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		url := createURL(t, s, "clicks")
+		other := createURL(t, s, "other")
+
+		now := time.Now().UTC().Truncate(time.Second)
+		clicks := []models.Click{
+			{URLID: url.ID, IPAddress: "10.0.0.1", Referrer: "https://news.example", Country: "Germany", ClickedAt: now},
+			{URLID: url.ID, IPAddress: "10.0.0.1", Referrer: "https://news.example", Country: "Germany", ClickedAt: now},
+			{URLID: url.ID, IPAddress: "10.0.0.2", Country: "France", ClickedAt: now.Add(-48 * time.Hour)},
+			{URLID: url.ID, IPAddress: "10.0.0.3", Country: "France", IsBot: true, Device: "bot", ClickedAt: now},
+			{URLID: other.ID, IPAddress: "10.0.0.9", Country: "Spain", ClickedAt: now},
+		}
+		if err := s.Clicks.RecordBatch(ctx, clicks); err != nil {
+			t.Fatalf("RecordBatch: %v", err)
+		}
+		if err := s.Clicks.Record(ctx, &models.Click{URLID: url.ID, IPAddress: "10.0.0.4", Country: "Germany", ClickedAt: now}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+
+		counts := map[Traffic]int{TrafficAll: 5, TrafficHuman: 4, TrafficBot: 1}
+		for traffic, want := range counts {
+			got, err := s.Clicks.CountClicks(ctx, url.ID, traffic)
+			if err != nil || got != want {
+				t.Errorf("CountClicks(%s) = %d, %v, want %d", traffic, got, err, want)
+			}
+		}
+
+		unique, err := s.Clicks.CountUniqueVisitors(ctx, url.ID, TrafficHuman)
+		if err != nil || unique != 3 {
+			t.Errorf("CountUniqueVisitors = %d, %v, want 3", unique, err)
+		}
+
+		countries, err := s.Clicks.TopValues(ctx, url.ID, DimCountry, ClickFilter{Traffic: TrafficHuman}, 10)
+		if err != nil {
+			t.Fatalf("TopValues: %v", err)
+		}
+		want := []ValueCount{{"Germany", 3}, {"France", 1}}
+		if len(countries) != len(want) || countries[0] != want[0] || countries[1] != want[1] {
+			t.Errorf("TopValues(country) = %v, want %v", countries, want)
+		}
+
+		referrers, err := s.Clicks.TopValues(ctx, url.ID, DimReferrer, ClickFilter{}, 10)
+		if err != nil || len(referrers) != 1 || referrers[0] != (ValueCount{"https://news.example", 2}) {
+			t.Errorf("TopValues(referrer) = %v, %v", referrers, err)
+		}
+
+		byDay, err := s.Clicks.ClicksByDay(ctx, url.ID, 7, TrafficAll)
+		if err != nil {
+			t.Fatalf("ClicksByDay: %v", err)
+		}
+		if got := byDay[now.Format("2006-01-02")]; got != 4 {
+			t.Errorf("ClicksByDay today = %d, want 4 (%v)", got, byDay)
+		}
+		if got := byDay[now.Add(-48*time.Hour).Format("2006-01-02")]; got != 1 {
+			t.Errorf("ClicksByDay two days ago = %d, want 1 (%v)", got, byDay)
+		}
+	})
+}
+
+func TestUserStore(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		user := &models.User{Username: "ann", Email: "ann@example.com", PasswordHash: "hash"}
+		if err := s.Users.Create(ctx, user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := s.Users.Create(ctx, &models.User{Username: "ann", Email: "other@example.com", PasswordHash: "hash"}); err != ErrConflict {
+			t.Errorf("Create with a taken username = %v, want ErrConflict", err)
+		}
+
+		got, err := s.Users.GetByUsername(ctx, "ann")
+		if err != nil || got.ID != user.ID || got.Email != user.Email {
+			t.Errorf("GetByUsername = %+v, %v", got, err)
+		}
+		if _, err := s.Users.GetByID(ctx, user.ID+100); err != ErrNotFound {
+			t.Errorf("GetByID(missing) = %v, want ErrNotFound", err)
+		}
+
+		exists, err := s.Users.Exists(ctx, "someone", "ann@example.com")
+		if err != nil || !exists {
+			t.Errorf("Exists by email = %v, %v, want true", exists, err)
+		}
+	})
+}
+
+func TestBackfillRollups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		url := createURL(t, s, "upgraded")
+		other := createURL(t, s, "other")
+		now := time.Now().UTC()
+		clicks := []models.Click{
+			{URLID: url.ID, IPAddress: "10.0.0.1", Country: "Germany", ClickedAt: now},
+			{URLID: url.ID, IPAddress: "10.0.0.2", Country: "France", ClickedAt: now.Add(-24 * time.Hour)},
+			{URLID: other.ID, IPAddress: "10.0.0.3", Country: "Spain", ClickedAt: now},
+		}
+		if err := s.Clicks.RecordBatch(ctx, clicks); err != nil {
+			t.Fatalf("RecordBatch: %v", err)
+		}
+
+		// Recorded clicks are rolled up already
+		if ids, err := s.Clicks.LinksMissingRollups(ctx); err != nil || len(ids) != 0 {
+			t.Fatalf("LinksMissingRollups = %v, %v, want none", ids, err)
+		}
+
+		sqlClicks, ok := s.Clicks.(*sqlClickStore)
+		if !ok {
+			return // The memory store has no rollups to lose
+		}
+
+		// Clicks stored before the rollup tables existed
+		for _, table := range []string{"click_rollups_hourly", "click_rollups_daily"} {
+			if _, err := sqlClicks.exec(ctx, "DELETE FROM "+table); err != nil {
+				t.Fatalf("clear %s: %v", table, err)
+			}
+		}
+		if count, _ := s.Clicks.CountClicks(ctx, url.ID, TrafficAll); count != 0 {
+			t.Fatalf("CountClicks without rollups = %d, want 0", count)
+		}
+
+		ids, err := s.Clicks.LinksMissingRollups(ctx)
+		if err != nil || len(ids) != 2 || ids[0] != url.ID || ids[1] != other.ID {
+			t.Fatalf("LinksMissingRollups = %v, %v, want [%d %d]", ids, err, url.ID, other.ID)
+		}
+		n, err := BackfillRollups(ctx, s.Clicks)
+		if err != nil || n != 2 {
+			t.Fatalf("BackfillRollups = %d, %v, want 2", n, err)
+		}
+		if count, err := s.Clicks.CountClicks(ctx, url.ID, TrafficAll); err != nil || count != 2 {
+			t.Errorf("CountClicks after backfill = %d, %v, want 2", count, err)
+		}
+
+		// Once filled, startup leaves them alone
+		if n, err := BackfillRollups(ctx, s.Clicks); err != nil || n != 0 {
+			t.Errorf("second BackfillRollups = %d, %v, want 0", n, err)
+		}
+	})
+}
+
+func TestClicksByRule(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *Stores) {
+		ctx := context.Background()
+		url := createURL(t, s, "rules")
+		if err := s.URLs.SetRules(ctx, url, []models.TargetingRule{
+			{Devices: []string{"mobile"}, Destination: "https://example.com/mobile"},
+		}); err != nil {
+			t.Fatalf("SetRules: %v", err)
+		}
+		rule := url.Rules[0].ID
+
+		now := time.Now().UTC()
+		clicks := []models.Click{
+			{URLID: url.ID, RuleID: &rule, ClickedAt: now},
+			{URLID: url.ID, RuleID: &rule, ClickedAt: now},
+			{URLID: url.ID, ClickedAt: now},
+			{URLID: url.ID, IsBot: true, ClickedAt: now},
+		}
+		if err := s.Clicks.RecordBatch(ctx, clicks); err != nil {
+			t.Fatalf("RecordBatch: %v", err)
+		}
+
+		byRule, unmatched, err := s.Clicks.ClicksByRule(ctx, url.ID, ClickFilter{Traffic: TrafficHuman})
+		if err != nil {
+			t.Fatalf("ClicksByRule: %v", err)
+		}
+		if len(byRule) != 1 || byRule[rule] != 2 || unmatched != 1 {
+			t.Errorf("ClicksByRule = %v, %d unmatched, want 2 routed and 1 unmatched", byRule, unmatched)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+)
+
+// previewTokens identify link preview fetchers and the security scanners
+// mail and chat services run on links before anyone opens them. Crawlers
+// are recognised by ParseUserAgent's bot detection.
+var previewTokens = []string{
+	"slackbot-linkexpanding",
+	"facebot",
+	"skypeuripreview",
+	"iframely",
+	"embedly",
+	"bingpreview",
+	"google-pagerenderer",
+	"google-read-aloud",
+	"vkshare",
+	"mastodon",
+	"headlesschrome",
+	"phantomjs",
+	"proofpoint",
+	"mimecast",
+	"barracuda",
+	"urlscan",
+	"zgrab",
+}
+
+// BotClassifier decides whether a request to a short link was made by an
+// automated client rather than a person
+type BotClassifier struct {
+	patterns []string // Extra lower-case User-Agent substrings
+}
+
+// NewBotClassifier returns a classifier that also treats User-Agents
+// containing any of patterns (case-insensitive) as bots
+func NewBotClassifier(patterns []string) *BotClassifier {
+	b := &BotClassifier{}
+	for _, p := range patterns {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			b.patterns = append(b.patterns, p)
+		}
+	}
+	return b
+}
+
+// IsBot reports whether r comes from a crawler, a link preview fetcher, a
+// HEAD request or a client matching one of the custom patterns. Requests
+// without a User-Agent are treated as bots too.
+func (b *BotClassifier) IsBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	// Browsers label speculative loads and link previews with these headers
+	for _, header := range []string{"Purpose", "X-Purpose", "Sec-Purpose", "X-Moz"} {
+		if purpose := strings.ToLower(r.Header.Get(header)); strings.Contains(purpose, "preview") || strings.Contains(purpose, "prefetch") {
+			return true
+		}
+	}
+
+	ua := strings.ToLower(r.UserAgent())
+	if strings.TrimSpace(ua) == "" {
+		return true
+	}
+	if _, ok := detectBot(ua); ok {
+		return true
+	}
+	for _, token := range previewTokens {
+		if strings.Contains(ua, token) {
+			return true
+		}
+	}
+	for _, p := range b.patterns {
+		if strings.Contains(ua, p) {
+			return true
+		}
+	}
+	return false
+}
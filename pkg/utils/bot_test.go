@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBotClassifier(t *testing.T) {
+	const chrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	classifier := NewBotClassifier([]string{" MyMonitor ", ""})
+
+	tests := []struct {
+		name   string
+		method string
+		ua     string
+		header string // "Name: value" sent with the request
+		bot    bool
+	}{
+		{"browser", "GET", chrome, "", false},
+		{"iPhone", "GET", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "", false},
+		{"Cubot phone", "GET", "Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0 Mobile Safari/537.36", "", false},
+		{"HEAD", "HEAD", chrome, "", true},
+		{"empty user agent", "GET", "", "", true},
+		{"blank user agent", "GET", "   ", "", true},
+		{"crawler", "GET", "Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)", "", true},
+		{"generic spider", "GET", "SomeSpider/3.1", "", true},
+		{"chat preview", "GET", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", "", true},
+		{"mail scanner", "GET", "Mozilla/5.0 Proofpoint URL Defense", "", true},
+		{"headless browser", "GET", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", "", true},
+		{"prefetch", "GET", chrome, "Purpose: prefetch", true},
+		{"prerender", "GET", chrome, "Sec-Purpose: prefetch;prerender", true},
+		{"Firefox preview", "GET", chrome, "X-Moz: prefetch", true},
+		{"custom pattern", "GET", "mymonitor/2.0", "", true},
+		{"custom pattern ignores case", "GET", "Uptime MYMONITOR", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/abc", nil)
+			req.Header.Set("User-Agent", tt.ua)
+			if name, value, ok := strings.Cut(tt.header, ": "); ok {
+				req.Header.Set(name, value)
+			}
+			if got := classifier.IsBot(req); got != tt.bot {
+				t.Errorf("IsBot = %v, want %v", got, tt.bot)
+			}
+		})
+	}
+}
@@ -46,7 +46,7 @@ func ValidateCustomCode(code string) (bool, string) {
 	}
 	
 	// Reserved codes that cannot be used
-	reserved := []string{"api", "static", "health", "index.html", "favicon.ico", "admin", "dashboard", "login", "register", "logout"}
+	reserved := []string{"api", "static", "health", "internal", "index.html", "favicon.ico", "admin", "dashboard", "login", "register", "logout"}
 	for _, r := range reserved {
 		if strings.EqualFold(code, r) {
 			return false, "This code is reserved and cannot be used"
@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Device classes reported by DetectDevice
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+// Operating system families reported by DetectOS
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+	OSOther    = "other"
+)
+
+// DetectOS returns the operating system family of a User-Agent string
+func DetectOS(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		return OSiOS
+	case strings.Contains(ua, "android"):
+		return OSAndroid
+	case strings.Contains(ua, "windows"):
+		return OSWindows
+	case strings.Contains(ua, "cros"):
+		return OSChromeOS
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os x"):
+		return OSMacOS
+	case strings.Contains(ua, "linux"):
+		return OSLinux
+	}
+	return OSOther
+}
+
+// DetectDevice returns the device class of a User-Agent string
+func DetectDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return DeviceTablet
+	case strings.Contains(ua, "android"):
+		// Android phones send "Mobile"; tablets don't
+		if strings.Contains(ua, "mobile") {
+			return DeviceMobile
+		}
+		return DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// DeviceBot is the device class ParseUserAgent reports for crawlers and
+// other automated clients
+const DeviceBot = "bot"
+
+// UserAgent holds what ParseUserAgent extracts from a User-Agent header.
+// Versions are empty when the header doesn't reveal them.
+type UserAgent struct {
+	Browser        string // e.g. "Chrome", "Safari", "Googlebot", "curl" or "Other"
+	BrowserVersion string // Major version, e.g. "120"
+	OS             string // e.g. "Windows", "iOS", "macOS" or "Other"
+	OSVersion      string // e.g. "10", "17.1"
+	Device         string // mobile, tablet, desktop or bot
+}
+
+// browserTokens identifies browsers by the product token that carries their
+// version. Order matters: Chromium-based browsers also send "Chrome/" and
+// nearly everything sends "Safari/", so the specific tokens come first.
+var browserTokens = []struct {
+	token, name string
+}{
+	{"edg/", "Edge"}, {"edge/", "Edge"}, {"edga/", "Edge"}, {"edgios/", "Edge"},
+	{"opr/", "Opera"}, {"opera/", "Opera"}, {"opios/", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"yabrowser/", "Yandex Browser"},
+	{"vivaldi/", "Vivaldi"},
+	{"ucbrowser/", "UC Browser"},
+	{"brave/", "Brave"},
+	{"duckduckgo/", "DuckDuckGo"},
+	{"firefox/", "Firefox"}, {"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chromium/", "Chromium"},
+	{"chrome/", "Chrome"},
+	{"msie ", "Internet Explorer"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+	{"python-requests/", "Python Requests"},
+	{"go-http-client/", "Go HTTP client"},
+	{"okhttp/", "OkHttp"},
+	{"postmanruntime/", "Postman"},
+}
+
+// botTokens identifies well-known crawlers; any agent mentioning "bot",
+// "crawl" or "spider" is treated as a bot as well
+var botTokens = []struct {
+	token, name string
+}{
+	{"googlebot", "Googlebot"},
+	{"bingbot", "Bingbot"},
+	{"yandexbot", "YandexBot"},
+	{"duckduckbot", "DuckDuckBot"},
+	{"baiduspider", "Baiduspider"},
+	{"applebot", "Applebot"},
+	{"facebookexternalhit", "Facebook"},
+	{"twitterbot", "Twitterbot"},
+	{"linkedinbot", "LinkedInBot"},
+	{"slackbot", "Slackbot"},
+	{"discordbot", "Discordbot"},
+	{"telegrambot", "TelegramBot"},
+	{"whatsapp", "WhatsApp"},
+	{"slurp", "Yahoo Slurp"},
+}
+
+// ParseUserAgent extracts the browser, operating system and device class
+// from a User-Agent header
+func ParseUserAgent(userAgent string) UserAgent {
+	ua := strings.ToLower(userAgent)
+	var parsed UserAgent
+
+	parsed.OS, parsed.OSVersion = parseOS(ua)
+
+	if name, ok := detectBot(ua); ok {
+		parsed.Browser = name
+		parsed.Device = DeviceBot
+		return parsed
+	}
+	parsed.Device = DetectDevice(userAgent)
+
+	for _, b := range browserTokens {
+		if i := strings.Index(ua, b.token); i >= 0 {
+			parsed.Browser = b.name
+			parsed.BrowserVersion = leadingDigits(ua[i+len(b.token):])
+			if b.name == "Opera" && b.token == "opera/" {
+				// Presto-era Opera froze its token at 9.80 and moved the real version
+				parsed.BrowserVersion = versionAfter(ua, "version/", parsed.BrowserVersion)
+			}
+			return parsed
+		}
+	}
+
+	switch {
+	case strings.Contains(ua, "trident/"):
+		parsed.Browser = "Internet Explorer"
+		parsed.BrowserVersion = versionAfter(ua, "rv:", "")
+	case strings.Contains(ua, "safari/") || (parsed.OS == "iOS" && strings.Contains(ua, "applewebkit/")):
+		// Safari reports its version in Version/; in-app iOS web views don't
+		parsed.Browser = "Safari"
+		parsed.BrowserVersion = versionAfter(ua, "version/", "")
+	default:
+		parsed.Browser = "Other"
+	}
+	return parsed
+}
+
+// detectBot reports whether ua belongs to an automated client and its name
+func detectBot(ua string) (string, bool) {
+	for _, b := range botTokens {
+		if strings.Contains(ua, b.token) {
+			return b.name, true
+		}
+	}
+	// (Cubot is a phone brand)
+	if (strings.Contains(ua, "bot") && !strings.Contains(ua, "cubot")) || strings.Contains(ua, "crawl") || strings.Contains(ua, "spider") {
+		return "Other bot", true
+	}
+	return "", false
+}
+
+// windowsVersions maps Windows NT kernel versions to product names. Windows
+// 11 still reports NT 10.0.
+var windowsVersions = map[string]string{
+	"10.0": "10", "6.3": "8.1", "6.2": "8", "6.1": "7", "6.0": "Vista", "5.2": "XP", "5.1": "XP",
+}
+
+// parseOS returns the display name and version of the operating system in
+// a lowercased User-Agent
+func parseOS(ua string) (string, string) {
+	switch DetectOS(ua) {
+	case OSiOS:
+		// "CPU iPhone OS 17_1 like Mac OS X" / "CPU OS 17_1 like Mac OS X" (iPad)
+		if i := strings.Index(ua, " os "); i >= 0 {
+			return "iOS", dottedVersion(ua[i+4:])
+		}
+		return "iOS", ""
+	case OSAndroid:
+		return "Android", dottedVersion(after(ua, "android "))
+	case OSWindows:
+		if strings.Contains(ua, "windows phone") {
+			return "Windows Phone", dottedVersion(after(ua, "windows phone "))
+		}
+		return "Windows", windowsVersions[dottedVersion(after(ua, "windows nt "))]
+	case OSChromeOS:
+		return "Chrome OS", ""
+	case OSMacOS:
+		return "macOS", dottedVersion(after(ua, "mac os x "))
+	case OSLinux:
+		return "Linux", ""
+	}
+	return "Other", ""
+}
+
+// after returns the part of s following the first occurrence of token, or ""
+func after(s, token string) string {
+	if i := strings.Index(s, token); i >= 0 {
+		return s[i+len(token):]
+	}
+	return ""
+}
+
+// versionAfter returns the major version following token, or fallback
+func versionAfter(ua, token, fallback string) string {
+	if version := leadingDigits(after(ua, token)); version != "" {
+		return version
+	}
+	return fallback
+}
+
+// leadingDigits returns the run of digits at the start of s
+func leadingDigits(s string) string {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return s[:n]
+}
+
+// dottedVersion returns up to two numeric components at the start of s,
+// separated by dots or underscores, e.g. "10_15_7" -> "10.15"
+func dottedVersion(s string) string {
+	major := leadingDigits(s)
+	if major == "" {
+		return ""
+	}
+	rest := s[len(major):]
+	if len(rest) > 1 && (rest[0] == '.' || rest[0] == '_') {
+		if minor := leadingDigits(rest[1:]); minor != "" {
+			return major + "." + minor
+		}
+	}
+	return major
+}
+
+// PreferredLanguage returns the lowercased language tag with the highest
+// quality in an Accept-Language header (e.g. "pt-br"), or "" if there is none
+func PreferredLanguage(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, q := strings.TrimSpace(part), 1.0
+		if i := strings.Index(tag, ";"); i >= 0 {
+			params := strings.TrimSpace(tag[i+1:])
+			tag = strings.TrimSpace(tag[:i])
+			if strings.HasPrefix(params, "q=") {
+				q = parseQuality(params[2:])
+			}
+		}
+		if tag == "" || tag == "*" || q <= bestQ {
+			continue
+		}
+		best, bestQ = strings.ToLower(tag), q
+	}
+	return best
+}
+
+// parseQuality parses a q-value such as "0.8"; invalid values count as 0
+func parseQuality(s string) float64 {
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0
+	}
+	return q
+}
@@ -0,0 +1,114 @@
+package utils
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want UserAgent
+	}{
+		{
+			"Chrome on Windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{"Chrome", "120", "Windows", "10", DeviceDesktop},
+		},
+		{
+			"Edge on Windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			UserAgent{"Edge", "120", "Windows", "10", DeviceDesktop},
+		},
+		{
+			"Firefox on Linux",
+			"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			UserAgent{"Firefox", "121", "Linux", "", DeviceDesktop},
+		},
+		{
+			"Safari on macOS",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			UserAgent{"Safari", "17", "macOS", "10.15", DeviceDesktop},
+		},
+		{
+			"Safari on iPhone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			UserAgent{"Safari", "17", "iOS", "17.1", DeviceMobile},
+		},
+		{
+			"Chrome on iPad",
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.169 Mobile/15E148 Safari/604.1",
+			UserAgent{"Chrome", "119", "iOS", "16.6", DeviceTablet},
+		},
+		{
+			"iOS in-app web view",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			UserAgent{"Safari", "", "iOS", "16.0", DeviceMobile},
+		},
+		{
+			"Samsung Internet on Android phone",
+			"Mozilla/5.0 (Linux; Android 13; SM-S908B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			UserAgent{"Samsung Internet", "23", "Android", "13", DeviceMobile},
+		},
+		{
+			"Chrome on Android tablet",
+			"Mozilla/5.0 (Linux; Android 12; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{"Chrome", "120", "Android", "12", DeviceTablet},
+		},
+		{
+			"Cubot phone isn't a bot",
+			"Mozilla/5.0 (Linux; Android 10; CUBOT X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.45 Mobile Safari/537.36",
+			UserAgent{"Chrome", "96", "Android", "10", DeviceMobile},
+		},
+		{
+			"Presto Opera",
+			"Opera/9.80 (Windows NT 6.1; WOW64) Presto/2.12.388 Version/12.18",
+			UserAgent{"Opera", "12", "Windows", "7", DeviceDesktop},
+		},
+		{
+			"Internet Explorer 11",
+			"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko",
+			UserAgent{"Internet Explorer", "11", "Windows", "8.1", DeviceDesktop},
+		},
+		{
+			"Chrome OS",
+			"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			UserAgent{"Chrome", "120", "Chrome OS", "", DeviceDesktop},
+		},
+		{
+			"Googlebot",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgent{"Googlebot", "", "Other", "", DeviceBot},
+		},
+		{
+			"unknown crawler",
+			"ExampleCrawler/1.0 (+https://example.com)",
+			UserAgent{"Other bot", "", "Other", "", DeviceBot},
+		},
+		{"curl", "curl/8.4.0", UserAgent{"curl", "8", "Other", "", DeviceDesktop}},
+		{"empty", "", UserAgent{"Other", "", "Other", "", DeviceDesktop}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.ua); got != tt.want {
+				t.Errorf("ParseUserAgent = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"pt-BR,pt;q=0.9,en;q=0.8", "pt-br"},
+		{"en;q=0.5, de", "de"},
+		{"fr;q=0.7, es;q=0.7", "fr"},
+		{"*, it;q=0.1", "it"},
+		{"en;q=0, de;q=bad", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := PreferredLanguage(tt.header); got != tt.want {
+			t.Errorf("PreferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ const API_BASE_URL = window.location.origin; // Use same origin as frontend
 
 // State Management
 let authToken = localStorage.getItem('authToken');
+let refreshToken = localStorage.getItem('refreshToken');
 let currentUser = JSON.parse(localStorage.getItem('currentUser') || 'null');
 
 // Initialize app
@@ -74,9 +75,8 @@ async function handleRegister(event) {
         const data = await response.json();
 
         if (response.ok) {
-            authToken = data.token;
+            saveSession(data);
             currentUser = data.user;
-            localStorage.setItem('authToken', authToken);
             localStorage.setItem('currentUser', JSON.stringify(currentUser));
             updateAuthUI();
             closeAuthModal();
@@ -104,9 +104,8 @@ async function handleLogin(event) {
         const data = await response.json();
 
         if (response.ok) {
-            authToken = data.token;
+            saveSession(data);
             currentUser = data.user;
-            localStorage.setItem('authToken', authToken);
             localStorage.setItem('currentUser', JSON.stringify(currentUser));
             updateAuthUI();
             closeAuthModal();
@@ -120,12 +119,72 @@ async function handleLogin(event) {
 }
 
 function logout() {
+    // End the session server-side too; the access token expires on its own
+    if (refreshToken) {
+        fetch(`${API_BASE_URL}/api/auth/logout`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        }).catch(() => {});
+    }
+    clearSession();
+    updateAuthUI();
+    showNotification('Logged out successfully', 'success');
+}
+
+function saveSession(data) {
+    authToken = data.token;
+    refreshToken = data.refresh_token;
+    localStorage.setItem('authToken', authToken);
+    localStorage.setItem('refreshToken', refreshToken);
+}
+
+function clearSession() {
     authToken = null;
+    refreshToken = null;
     currentUser = null;
     localStorage.removeItem('authToken');
+    localStorage.removeItem('refreshToken');
     localStorage.removeItem('currentUser');
+}
+
+// Exchanges the refresh token for new tokens; logs out when the session is over
+async function refreshSession() {
+    if (!refreshToken) return false;
+    try {
+        const response = await fetch(`${API_BASE_URL}/api/auth/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        });
+        if (response.ok) {
+            saveSession(await response.json());
+            return true;
+        }
+    } catch (error) {
+        return false;
+    }
+    clearSession();
     updateAuthUI();
-    showNotification('Logged out successfully', 'success');
+    showNotification('Your session has expired, please log in again', 'error');
+    return false;
+}
+
+// fetch with the access token, refreshing it once if it has expired
+async function authFetch(url, options = {}) {
+    const send = () => {
+        const headers = { ...(options.headers || {}) };
+        if (authToken) {
+            headers['Authorization'] = `Bearer ${authToken}`;
+        }
+        return fetch(url, { ...options, headers });
+    };
+
+    const response = await send();
+    if (response.status === 401 && authToken && await refreshSession()) {
+        return send();
+    }
+    return response;
 }
 
 function updateAuthUI() {
@@ -166,10 +225,6 @@ async function handleShorten(event) {
             'Content-Type': 'application/json'
         };
 
-        if (authToken) {
-            headers['Authorization'] = `Bearer ${authToken}`;
-        }
-
         const body = { url };
         if (customCode) {
             body.custom_code = customCode;
@@ -179,7 +234,7 @@ async function handleShorten(event) {
             body.expires_at = new Date(expiresAt).toISOString();
         }
 
-        const response = await fetch(`${API_BASE_URL}/api/shorten`, {
+        const response = await authFetch(`${API_BASE_URL}/api/shorten`, {
             method: 'POST',
             headers: headers,
             body: JSON.stringify(body)
@@ -276,9 +331,12 @@ async function handleStats(event) {
     statsDiv.className = 'stats-result show';
     exportButtons.style.display = 'none';
 
+    // Stats of links created while logged in are visible to their owner only,
+    // so authFetch sends the access token
+
     try {
         // Try enhanced stats first
-        const response = await fetch(`${API_BASE_URL}/api/stats/${code}/enhanced`);
+        const response = await authFetch(`${API_BASE_URL}/api/stats/${code}/enhanced`);
         const data = await response.json();
 
         if (response.ok) {
@@ -288,7 +346,7 @@ async function handleStats(event) {
             exportButtons.style.display = 'block';
         } else {
             // Fallback to basic stats
-            const basicResponse = await fetch(`${API_BASE_URL}/api/stats/${code}`);
+            const basicResponse = await authFetch(`${API_BASE_URL}/api/stats/${code}`);
             const basicData = await basicResponse.json();
             
             if (basicResponse.ok) {
@@ -459,11 +517,7 @@ async function loadMyURLs() {
     myUrlsList.innerHTML = '<div class="loading"></div> Loading your URLs...';
 
     try {
-        const response = await fetch(`${API_BASE_URL}/api/my-urls`, {
-            headers: {
-                'Authorization': `Bearer ${authToken}`
-            }
-        });
+        const response = await authFetch(`${API_BASE_URL}/api/my-urls`);
 
         const data = await response.json();
 
@@ -523,11 +577,8 @@ async function deleteURL(code) {
     if (!authToken) return;
 
     try {
-        const response = await fetch(`${API_BASE_URL}/api/urls/${code}`, {
-            method: 'DELETE',
-            headers: {
-                'Authorization': `Bearer ${authToken}`
-            }
+        const response = await authFetch(`${API_BASE_URL}/api/urls/${code}`, {
+            method: 'DELETE'
         });
 
         const data = await response.json();